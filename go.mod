@@ -6,7 +6,6 @@ require (
 	github.com/cbergoon/merkletree v0.2.0
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/vmihailenco/msgpack/v5 v5.3.5
-	github.com/zenazn/pkcs7pad v0.0.0-20170308005700-253a5b1f0e03
 	go.dedis.ch/kyber/v3 v3.0.14
 	google.golang.org/grpc v1.50.1
 	google.golang.org/protobuf v1.28.1
@@ -43,9 +43,29 @@ go build -o ./client ./src/main/client/
 chmod +x ./client
 echo "SUCCESS: 'client' built and made executable."
 
+echo "INFO: Building 'dbtool' executable..."
+go build -o ./dbtool ./src/main/dbtool/
+chmod +x ./dbtool
+echo "SUCCESS: 'dbtool' built and made executable."
+
+echo "INFO: Building 'signerd' executable..."
+go build -o ./signerd ./src/main/signerd/
+chmod +x ./signerd
+echo "SUCCESS: 'signerd' built and made executable."
+
+echo "INFO: Building 'replay' executable..."
+go build -o ./replay ./src/main/replay/
+chmod +x ./replay
+echo "SUCCESS: 'replay' built and made executable."
+
+echo "INFO: Building 'kv' executable..."
+go build -o ./kv ./src/main/kv/
+chmod +x ./kv
+echo "SUCCESS: 'kv' built and made executable."
+
 
 echo ""
 echo "-------------------------------------"
 echo "ALL BUILDS COMPLETED SUCCESSFULLY!"
-echo "Executables (ecdsagen, server, client) are now in the project root directory."
+echo "Executables (ecdsagen, server, client, dbtool, signerd, replay, kv) are now in the project root directory."
 echo "-------------------------------------"
@@ -28,7 +28,19 @@ chmod +x ./server
 go build -mod=vendor -o ./client ./src/main/client
 chmod +x ./client
 
+go build -mod=vendor -o ./dbtool ./src/main/dbtool
+chmod +x ./dbtool
+
+go build -mod=vendor -o ./signerd ./src/main/signerd
+chmod +x ./signerd
+
+go build -mod=vendor -o ./replay ./src/main/replay
+chmod +x ./replay
+
+go build -mod=vendor -o ./kv ./src/main/kv
+chmod +x ./kv
+
 echo "Build finished successfully!"
 
 # List the generated binaries to confirm they were created.
-ls -l ecdsagen server client
+ls -l ecdsagen server client dbtool signerd replay kv
@@ -44,6 +44,23 @@ echo "[Output] Print the output of the sleepy replica"
 cat "${OUTPUT_DIR}/server_2.log"
 sleep 1
 
+# the ledger of every replica: balances, and the transactions it refused
+echo
+echo "[Ledger] balances and refused transactions of every replica"
+for ((i=0; i <= 3; i++)); do
+    echo "replica $i: $(curl -s "http://127.0.0.1:$((13000 + i))/query?path=accounts")"
+    curl -s "http://127.0.0.1:$((13000 + i))/query?path=refused"
+done
+
+# with the utxo application: the outputs double-spent across the committed chain and the forks
+for ((i=0; i <= 3; i++)); do
+    # the audit is made on request; it fails unless the application audits forks
+    if audit=$(curl -sf "http://127.0.0.1:$((13000 + i))/forks"); then
+        echo "[Fork Audit] replica $i"
+        echo "$audit"
+    fi
+done
+
 # kill all server and client
 echo
 echo "[Kill Process] kill all server and client"
@@ -0,0 +1,74 @@
+/*
+Admin API of a replica.
+With adminPortOffset set in conf.json, replica id serves HTTP on 127.0.0.1 at its port plus
+the offset, e.g., 11000 + 2000 for replica 0 in the default configuration. Every endpoint
+answers in JSON:
+
+	GET /evidence             evidence of equivocation found so far
+	GET /evidence?culprit=id  only the evidence against replica id
+	GET /forks                audit of the received forks, also written to etc/output/forkAudit_[id].json
+	GET /query?path=p&data=d  Query(p, d) of the application
+*/
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/utils"
+)
+
+var mux = http.NewServeMux()
+
+// Handle registers an endpoint. It must be called before Start.
+func Handle(pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, handler)
+}
+
+// WriteJSON answers a request with v.
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Address returns the address of the admin API of the replica listening to port, or "" if it is disabled.
+func Address(port string) string {
+	offset := config.AdminPortOffset()
+	if offset <= 0 || len(port) < 2 {
+		return ""
+	}
+	pn, err := utils.StringToInt(port[1:])
+	if err != nil {
+		return ""
+	}
+	return "127.0.0.1:" + utils.IntToString(pn+offset)
+}
+
+// Start serves the admin API of the replica listening to port, if it is enabled.
+func Start(port string) {
+	addr := Address(port)
+	if addr == "" {
+		return
+	}
+	Handle("/evidence", handleEvidence)
+	Handle("/forks", handleForks)
+	Handle("/query", handleQuery)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		p := fmt.Sprintf("[Admin Error] failed to listen %v: %v", addr, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	log.Printf("admin API listening to %v", addr)
+	go http.Serve(lis, mux)
+}
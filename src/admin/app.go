@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sleepy-hotstuff/src/app"
+)
+
+type queryResult struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+	Hex   string `json:"hex"`
+}
+
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	a := app.Current()
+	if a == nil {
+		http.Error(w, "no application", http.StatusNotFound)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	value, err := a.Query(path, []byte(r.URL.Query().Get("data")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, queryResult{Path: path, Value: string(value), Hex: hex.EncodeToString(value)})
+}
@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"net/http"
+	"sleepy-hotstuff/src/evidence"
+	"sleepy-hotstuff/src/utils"
+)
+
+func handleEvidence(w http.ResponseWriter, r *http.Request) {
+	reports := evidence.Reports()
+	culprit := r.URL.Query().Get("culprit")
+	if culprit == "" {
+		WriteJSON(w, reports)
+		return
+	}
+	cid, err := utils.StringToInt64(culprit)
+	if err != nil {
+		http.Error(w, "invalid culprit "+culprit, http.StatusBadRequest)
+		return
+	}
+	filtered := []evidence.Report{}
+	for i := 0; i < len(reports); i++ {
+		if reports[i].Culprit == cid {
+			filtered = append(filtered, reports[i])
+		}
+	}
+	WriteJSON(w, filtered)
+}
@@ -0,0 +1,26 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sleepy-hotstuff/src/consensus"
+	"time"
+)
+
+// an audit waits for the core, which may be busy with a recovery
+const auditTimeout = 30 * time.Second
+
+func handleForks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), auditTimeout)
+	defer cancel()
+	audit, err := consensus.AuditForks(ctx)
+	if err == consensus.ErrNoAuditor {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	WriteJSON(w, audit)
+}
@@ -0,0 +1,139 @@
+/*
+Application interface of the replicas.
+Consensus orders client requests; the application gives them a meaning. It is called by the
+consensus core, in commit order, for every committed block:
+
+	BeginBlock(header)
+	DeliverTx(tx) for every transaction of the block
+	EndBlock(height)
+	Commit() -> state hash
+
+The state hash after a block is carried by later proposals, so that the replicas can compare
+their states. CheckTx filters client requests before they are queued, and Query reads the
+state, e.g., from the admin API.
+A transaction is the OP of a ClientRequest. The first transaction of a block is the coinbase
+of the leader, a message.Transaction from "" to the leader.
+
+An application that also implements ForkAuditor is given, after every executed block, the
+branches of the proposals the replica received, committed or not.
+
+A Responder gives clients the result of their transactions once executed, and Query with
+the path "read" serves reads from the executed state, see message.LevelConsensus and the
+other consistency levels.
+
+An application registers itself with Register, and replicas run the one named by application
+in conf.json. The block methods and CheckTx are called by a single goroutine; Query may be
+called at the same time.
+*/
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type Header struct {
+	View     int
+	Height   int
+	Hash     []byte
+	Proposer int64
+}
+
+// Result of a transaction. Code 0 means success; a failed transaction leaves the state unchanged.
+type Result struct {
+	Code uint32
+	Log  string
+}
+
+const (
+	CodeOK      uint32 = 0
+	CodeInvalid uint32 = 1 // the transaction cannot be decoded
+	CodeRefused uint32 = 2 // the transaction is valid, but refused by the state
+)
+
+// Applications keep the results of the last ResultLimit transactions for their clients, and
+// forget the older ones.
+var ResultLimit = 10000
+
+type Application interface {
+	CheckTx(tx []byte) error
+	BeginBlock(header Header)
+	DeliverTx(tx []byte) Result
+	EndBlock(height int)
+	Commit() []byte
+	Query(path string, data []byte) ([]byte, error)
+}
+
+// A branch of received proposals, from the first height to its tip.
+type Branch struct {
+	Tip    []byte
+	Blocks []BranchBlock
+}
+
+// A received proposal; Txs starts with the coinbase of the proposer.
+type BranchBlock struct {
+	Height   int
+	Hash     []byte
+	Proposer int64
+	Txs      [][]byte
+}
+
+// ForkAuditor is implemented by applications that check forks. The report is written as JSON.
+type ForkAuditor interface {
+	AuditForks(branches []Branch) interface{}
+}
+
+// Responder is implemented by applications that give clients the result of their transactions.
+// Response blocks until tx is executed, or until done is closed.
+type Responder interface {
+	Response(tx []byte, done <-chan struct{}) ([]byte, error)
+}
+
+var registry = map[string]func() Application{}
+
+// Register makes an application available under name, usually from an init function.
+func Register(name string, create func() Application) {
+	registry[name] = create
+}
+
+// Names of the registered applications.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the application registered under name; nil for "".
+func New(name string) (Application, error) {
+	if name == "" {
+		return nil, nil
+	}
+	create, exist := registry[name]
+	if !exist {
+		return nil, fmt.Errorf("[App Error] no application %q, registered ones are %v", name, Names())
+	}
+	return create(), nil
+}
+
+var current Application
+var currentLock sync.RWMutex
+
+// Set the application of the replica. Code embedding the replica can call it before
+// consensus starts, instead of naming a registered application in conf.json.
+func Set(a Application) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = a
+}
+
+// The application of the replica, or nil.
+func Current() Application {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"sleepy-hotstuff/src/cryptolib"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	Register("counter", func() Application { return NewCounter() })
+}
+
+// Counter is the reference application. It counts the delivered transactions and chains
+// their hashes, so the state hash tells whether two replicas executed the same transactions.
+//
+//	Query("count")  number of transactions
+//	Query("height") height of the last committed block
+//	Query("hash")   state hash
+type Counter struct {
+	count   int
+	height  int
+	hash    []byte
+	pending []byte // state hash during a block
+	sync.RWMutex
+}
+
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+func (c *Counter) CheckTx(tx []byte) error {
+	if len(tx) == 0 {
+		return errors.New("empty transaction")
+	}
+	return nil
+}
+
+func (c *Counter) BeginBlock(header Header) {
+	c.Lock()
+	defer c.Unlock()
+	c.pending = c.hash
+	c.height = header.Height
+}
+
+func (c *Counter) DeliverTx(tx []byte) Result {
+	if err := c.CheckTx(tx); err != nil {
+		return Result{Code: CodeInvalid, Log: err.Error()}
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.count++
+	c.pending = cryptolib.GenHash(append(append([]byte{}, c.pending...), tx...))
+	return Result{}
+}
+
+func (c *Counter) EndBlock(height int) {}
+
+func (c *Counter) Commit() []byte {
+	c.Lock()
+	defer c.Unlock()
+	c.hash = c.pending
+	state := make([]byte, 8)
+	binary.BigEndian.PutUint64(state, uint64(c.count))
+	return cryptolib.GenHash(append(state, c.hash...))
+}
+
+func (c *Counter) Query(path string, data []byte) ([]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+	switch path {
+	case "count":
+		return []byte(strconv.Itoa(c.count)), nil
+	case "height":
+		return []byte(strconv.Itoa(c.height)), nil
+	case "hash":
+		return c.hash, nil
+	}
+	return nil, errors.New("unknown path " + path)
+}
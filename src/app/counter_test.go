@@ -0,0 +1,46 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func run(a Application, blocks [][][]byte) []byte {
+	var hash []byte
+	for h, txs := range blocks {
+		a.BeginBlock(Header{Height: h + 1})
+		for _, tx := range txs {
+			a.DeliverTx(tx)
+		}
+		a.EndBlock(h + 1)
+		hash = a.Commit()
+	}
+	return hash
+}
+
+func TestCounter(test *testing.T) {
+	a, err := New("counter")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := New("unknown"); err == nil {
+		test.Fatal("an unknown application was created")
+	}
+	if a.CheckTx(nil) == nil || a.DeliverTx(nil).Code != CodeInvalid {
+		test.Fatal("an empty transaction was accepted")
+	}
+
+	blocks := [][][]byte{{[]byte("a"), []byte("b")}, {[]byte("c")}}
+	hash := run(a, blocks)
+	count, _ := a.Query("count", nil)
+	height, _ := a.Query("height", nil)
+	if string(count) != "3" || string(height) != "2" {
+		test.Fatalf("count %s at height %s", count, height)
+	}
+	if !bytes.Equal(run(NewCounter(), blocks), hash) {
+		test.Fatal("the same blocks give another state hash")
+	}
+	if bytes.Equal(run(NewCounter(), [][][]byte{{[]byte("b"), []byte("a")}, {[]byte("c")}}), hash) {
+		test.Fatal("other blocks give the same state hash")
+	}
+}
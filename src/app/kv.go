@@ -0,0 +1,257 @@
+package app
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	Register("kv", func() Application { return NewKV() })
+}
+
+type KVOpType int
+
+const (
+	KVGet KVOpType = iota + 1
+	KVPut
+	KVDelete
+	KVCAS // put if the version of the key is Version
+)
+
+// A key-value operation, the OP of a ClientRequest. Client and Seq make the operations of a
+// client distinct, so that the same operation sent twice has two results.
+type KVOp struct {
+	Type    KVOpType
+	Key     string
+	Value   []byte
+	Version int
+	Client  int64
+	Seq     int
+}
+
+func (op *KVOp) Serialize() ([]byte, error) {
+	return msgpack.Marshal(op)
+}
+
+// The result of an operation. Version is the revision of the last write of the key, 0 if the
+// key has no value; Height is the height of the block executing the operation, or, for a
+// local read, the last executed height.
+type KVResult struct {
+	Code    uint32 `json:"code"`
+	Value   []byte `json:"value,omitempty"`
+	Version int    `json:"version"`
+	Height  int    `json:"height"`
+	Log     string `json:"log,omitempty"`
+}
+
+var ErrVersion = errors.New("version mismatch")
+
+type kvEntry struct {
+	Value   []byte
+	Version int
+}
+
+// KV is a replicated key-value store. Every write increments the revision of the store, and
+// the version of the key written is the new revision, so a CAS cannot succeed on a key that
+// was deleted and written again. As a Responder, it gives clients the result of their
+// operations once they are executed; the results of the last ResultLimit operations are kept.
+//
+//	Query("get", key)     KVResult of the key in the executed state, as JSON
+//	Query("read", tx)     KVResult of the KVGet operation tx in the executed state, as JSON
+//	Query("keys")         keys with a value, as JSON
+//	Query("revision")     revision of the store
+type KV struct {
+	entries  map[string]kvEntry
+	revision int
+	height   int
+	results  map[string]KVResult        // results of the executed operations, by the hex of their hash
+	order    []string                   // hashes of the results, oldest first
+	waiters  map[string][]chan struct{} // clients waiting for the result of an operation
+	sync.RWMutex
+}
+
+func NewKV() *KV {
+	return &KV{
+		entries: make(map[string]kvEntry),
+		results: make(map[string]KVResult),
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+func decodeKVOp(tx []byte) (KVOp, error) {
+	var op KVOp
+	if err := msgpack.Unmarshal(tx, &op); err != nil || op.Type < KVGet || op.Type > KVCAS {
+		return op, ErrInvalidTx
+	}
+	if op.Key == "" {
+		return op, fmt.Errorf("%w: empty key", ErrInvalidTx)
+	}
+	return op, nil
+}
+
+// The coinbase of the leader means nothing to the store.
+func isCoinbase(tx []byte) bool {
+	t, err := decodeTransaction(tx)
+	return err == nil && t.From == ""
+}
+
+func (kv *KV) CheckTx(tx []byte) error {
+	_, err := decodeKVOp(tx)
+	return err
+}
+
+func (kv *KV) BeginBlock(header Header) {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.height = header.Height
+}
+
+// Read a key. The caller holds the lock.
+func (kv *KV) get(key string) KVResult {
+	e := kv.entries[key]
+	return KVResult{Value: e.Value, Version: e.Version, Height: kv.height}
+}
+
+func (kv *KV) DeliverTx(tx []byte) Result {
+	op, err := decodeKVOp(tx)
+	if err != nil && isCoinbase(tx) {
+		return Result{}
+	}
+
+	kv.Lock()
+	defer kv.Unlock()
+	var result KVResult
+	switch {
+	case err != nil:
+		result = KVResult{Code: CodeInvalid, Log: err.Error()}
+	case op.Type == KVGet:
+		result = kv.get(op.Key)
+	case op.Type == KVCAS && kv.entries[op.Key].Version != op.Version:
+		result = kv.get(op.Key)
+		result.Code = CodeRefused
+		result.Log = fmt.Sprintf("%v: %d, expected %d", ErrVersion, result.Version, op.Version)
+	case op.Type == KVDelete:
+		if _, exist := kv.entries[op.Key]; exist {
+			kv.revision++
+			delete(kv.entries, op.Key)
+		}
+		result = KVResult{Height: kv.height}
+	default:
+		kv.revision++
+		kv.entries[op.Key] = kvEntry{Value: op.Value, Version: kv.revision}
+		result = kv.get(op.Key)
+	}
+	result.Height = kv.height
+
+	hash := hex.EncodeToString(cryptolib.GenHash(tx))
+	if _, exist := kv.results[hash]; !exist {
+		kv.results[hash] = result
+		kv.order = append(kv.order, hash)
+		if len(kv.order) > ResultLimit {
+			delete(kv.results, kv.order[0])
+			kv.order = kv.order[1:]
+		}
+		for _, c := range kv.waiters[hash] {
+			close(c)
+		}
+		delete(kv.waiters, hash)
+	}
+	return Result{Code: result.Code, Log: result.Log}
+}
+
+func (kv *KV) EndBlock(height int) {}
+
+// The state hash covers the revision and the entries, in the order of the keys.
+func (kv *KV) Commit() []byte {
+	kv.RLock()
+	defer kv.RUnlock()
+	type entry struct {
+		Key string
+		kvEntry
+	}
+	var entries []entry
+	for key, e := range kv.entries {
+		entries = append(entries, entry{Key: key, kvEntry: e})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	state, _ := msgpack.Marshal(struct {
+		Revision int
+		Entries  []entry
+	}{kv.revision, entries})
+	return cryptolib.GenHash(state)
+}
+
+// Response waits until the operation tx is executed, and returns its KVResult as JSON. The
+// result of an operation executed before the last ResultLimit ones is no longer known.
+func (kv *KV) Response(tx []byte, done <-chan struct{}) ([]byte, error) {
+	hash := hex.EncodeToString(cryptolib.GenHash(tx))
+	kv.Lock()
+	result, exist := kv.results[hash]
+	if exist {
+		kv.Unlock()
+		return json.Marshal(result)
+	}
+	c := make(chan struct{})
+	kv.waiters[hash] = append(kv.waiters[hash], c)
+	kv.Unlock()
+
+	select {
+	case <-c:
+	case <-done:
+		kv.Lock()
+		defer kv.Unlock()
+		for i, other := range kv.waiters[hash] {
+			if other == c {
+				kv.waiters[hash] = append(kv.waiters[hash][:i], kv.waiters[hash][i+1:]...)
+				break
+			}
+		}
+		if len(kv.waiters[hash]) == 0 {
+			delete(kv.waiters, hash)
+		}
+		return nil, errors.New("operation not executed in time")
+	}
+	kv.RLock()
+	defer kv.RUnlock()
+	result, exist = kv.results[hash]
+	if !exist {
+		return nil, errors.New("result of the operation forgotten")
+	}
+	return json.Marshal(result)
+}
+
+func (kv *KV) Query(path string, data []byte) ([]byte, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+	switch path {
+	case "get":
+		return json.Marshal(kv.get(string(data)))
+	case "read":
+		op, err := decodeKVOp(data)
+		if err != nil {
+			return nil, err
+		}
+		if op.Type != KVGet {
+			return nil, errors.New("not a read")
+		}
+		return json.Marshal(kv.get(op.Key))
+	case "keys":
+		keys := []string{}
+		for key := range kv.entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return json.Marshal(keys)
+	case "revision":
+		return []byte(strconv.Itoa(kv.revision)), nil
+	}
+	return nil, errors.New("unknown path " + path)
+}
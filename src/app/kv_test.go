@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func kvOp(t KVOpType, key string, value string, version int, seq int) []byte {
+	op := KVOp{Type: t, Key: key, Value: []byte(value), Version: version, Client: 7, Seq: seq}
+	tx, _ := op.Serialize()
+	return tx
+}
+
+func TestKV(test *testing.T) {
+	kv := NewKV()
+	if kv.CheckTx(kvOp(KVPut, "", "v", 0, 1)) == nil || kv.CheckTx(transaction("0", "1", 40, 0)) == nil {
+		test.Fatal("CheckTx")
+	}
+
+	// a client waits for the result of its read
+	get := kvOp(KVGet, "a", "", 0, 3)
+	replies := make(chan []byte)
+	go func() {
+		reply, _ := kv.Response(get, nil)
+		replies <- reply
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	kv.BeginBlock(Header{Height: 1})
+	codes := []uint32{
+		kv.DeliverTx(transaction("", "0", CoinbaseReward, 0)).Code,
+		kv.DeliverTx(kvOp(KVPut, "a", "1", 0, 1)).Code,
+		kv.DeliverTx(kvOp(KVCAS, "a", "2", 1, 2)).Code,
+		kv.DeliverTx(get).Code,
+		kv.DeliverTx(kvOp(KVCAS, "a", "3", 1, 4)).Code,
+	}
+	kv.EndBlock(1)
+	hash := kv.Commit()
+	if codes[0] != CodeOK || codes[1] != CodeOK || codes[2] != CodeOK || codes[3] != CodeOK || codes[4] != CodeRefused {
+		test.Fatalf("codes %v", codes)
+	}
+	var result KVResult
+	json.Unmarshal(<-replies, &result)
+	if string(result.Value) != "2" || result.Version != 2 || result.Height != 1 {
+		test.Fatalf("result of the read: %+v", result)
+	}
+
+	// a key deleted and written again has a new version
+	kv.BeginBlock(Header{Height: 2})
+	kv.DeliverTx(kvOp(KVDelete, "a", "", 0, 5))
+	kv.DeliverTx(kvOp(KVCAS, "a", "4", 0, 6))
+	kv.EndBlock(2)
+	kv.Commit()
+	data, _ := kv.Query("read", kvOp(KVGet, "a", "", 0, 7))
+	json.Unmarshal(data, &result)
+	if string(result.Value) != "4" || result.Version != 4 {
+		test.Fatalf("local read: %+v", result)
+	}
+	reply, _ := kv.Response(kvOp(KVCAS, "a", "3", 1, 4), nil)
+	json.Unmarshal(reply, &result)
+	if result.Code != CodeRefused || string(result.Value) != "2" {
+		test.Fatalf("result of the failed CAS: %+v", result)
+	}
+
+	// an operation that is not executed
+	done := make(chan struct{})
+	close(done)
+	if _, err := kv.Response(kvOp(KVGet, "b", "", 0, 8), done); err == nil || len(kv.waiters) != 0 {
+		test.Fatal("waiting for an operation that is not executed")
+	}
+
+	// the state hash depends on the state only
+	other := NewKV()
+	other.BeginBlock(Header{Height: 1})
+	other.DeliverTx(kvOp(KVPut, "a", "1", 0, 1))
+	other.DeliverTx(kvOp(KVPut, "a", "2", 0, 2))
+	if string(other.Commit()) != string(hash) {
+		test.Fatal("the same state gives another hash")
+	}
+}
+
+func TestKVResultLimit(test *testing.T) {
+	limit := ResultLimit
+	ResultLimit = 2
+	defer func() { ResultLimit = limit }()
+
+	kv := NewKV()
+	kv.BeginBlock(Header{Height: 1})
+	for seq := 1; seq <= 3; seq++ {
+		kv.DeliverTx(kvOp(KVPut, "a", "v", 0, seq))
+	}
+	if len(kv.results) != 2 {
+		test.Fatalf("%d results kept", len(kv.results))
+	}
+	done := make(chan struct{})
+	close(done)
+	if _, err := kv.Response(kvOp(KVPut, "a", "v", 0, 1), done); err == nil {
+		test.Fatal("result of the oldest operation kept")
+	}
+	if _, err := kv.Response(kvOp(KVPut, "a", "v", 0, 3), done); err != nil {
+		test.Fatal(err)
+	}
+}
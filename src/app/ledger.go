@@ -0,0 +1,218 @@
+package app
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	Register("ledger", func() Application { return NewLedger() })
+}
+
+// Coins of the coinbase transaction of the leader, see addVote in consensus/hotstuff.go.
+const CoinbaseReward = 50
+
+// Balances before the first block, as in the blockchain dumps of the replicas.
+var Genesis = map[string]int{"0": 50}
+
+var (
+	ErrInvalidTx = errors.New("invalid transaction")
+	ErrOverdraft = errors.New("overdraft")
+	ErrNonce     = errors.New("nonce out of order")
+	ErrCoinbase  = errors.New("invalid coinbase")
+)
+
+// The outcome of a transaction delivered to the ledger.
+type TxRecord struct {
+	Height int                 `json:"height"`
+	Tx     message.Transaction `json:"tx"`
+	Code   uint32              `json:"code"`
+	Log    string              `json:"log,omitempty"`
+}
+
+// Ledger is a payment application on accounts. A transaction is a message.Transaction: From
+// pays Value coins to To. It is applied only if From has the coins and Nonce is the number of
+// transactions From had applied before, so a transaction cannot be applied twice. The first
+// transaction of a block may be a coinbase, from "" to the proposer, of CoinbaseReward coins.
+//
+//	Query("balance", account)  coins of the account
+//	Query("nonce", account)    nonce of the next transaction of the account
+//	Query("rewards", account)  coins the account received as coinbase
+//	Query("accounts")          balances of all the accounts, as JSON
+//	Query("tx", hex hash)      TxRecord of the transaction with this hash, as JSON
+//	Query("refused")           TxRecords of the last refused transactions, as JSON
+//
+// The records of the last ResultLimit transactions, and of the last ResultLimit refused ones,
+// are kept.
+type Ledger struct {
+	balances map[string]int
+	nonces   map[string]int
+	rewards  map[string]int
+	records  map[string]TxRecord
+	order    []string // hashes of the records, oldest first
+	refused  []TxRecord
+	header   Header
+	index    int // index of the next transaction in the block
+	sync.RWMutex
+}
+
+func NewLedger() *Ledger {
+	l := &Ledger{
+		balances: make(map[string]int),
+		nonces:   make(map[string]int),
+		rewards:  make(map[string]int),
+		records:  make(map[string]TxRecord),
+	}
+	for account, coins := range Genesis {
+		l.balances[account] = coins
+	}
+	return l
+}
+
+func decodeTransaction(tx []byte) (message.Transaction, error) {
+	var t message.Transaction
+	if err := t.Deserialize(tx); err != nil {
+		return t, ErrInvalidTx
+	}
+	if t.To == "" || t.Value <= 0 {
+		return t, ErrInvalidTx
+	}
+	return t, nil
+}
+
+// Check a transfer against the state. The caller holds the lock.
+func (l *Ledger) check(t message.Transaction) error {
+	if t.Nonce != l.nonces[t.From] {
+		return fmt.Errorf("%w: %d, expected %d", ErrNonce, t.Nonce, l.nonces[t.From])
+	}
+	if l.balances[t.From] < t.Value {
+		return fmt.Errorf("%w: %s has %d, pays %d", ErrOverdraft, t.From, l.balances[t.From], t.Value)
+	}
+	return nil
+}
+
+// CheckTx refuses a transaction that cannot be applied to the committed state.
+// Coinbase transactions are only made by leaders, in blocks.
+func (l *Ledger) CheckTx(tx []byte) error {
+	t, err := decodeTransaction(tx)
+	if err != nil {
+		return err
+	}
+	if t.From == "" {
+		return ErrCoinbase
+	}
+	l.RLock()
+	defer l.RUnlock()
+	return l.check(t)
+}
+
+func (l *Ledger) BeginBlock(header Header) {
+	l.Lock()
+	defer l.Unlock()
+	l.header = header
+	l.index = 0
+}
+
+func (l *Ledger) DeliverTx(tx []byte) Result {
+	l.Lock()
+	defer l.Unlock()
+	index := l.index
+	l.index++
+
+	t, err := decodeTransaction(tx)
+	result := Result{}
+	switch {
+	case err != nil:
+		result = Result{Code: CodeInvalid, Log: err.Error()}
+	case t.From == "":
+		if index != 0 || t.To != strconv.FormatInt(l.header.Proposer, 10) || t.Value != CoinbaseReward {
+			result = Result{Code: CodeRefused, Log: ErrCoinbase.Error()}
+			break
+		}
+		l.balances[t.To] += t.Value
+		l.rewards[t.To] += t.Value
+	default:
+		if err := l.check(t); err != nil {
+			result = Result{Code: CodeRefused, Log: err.Error()}
+			break
+		}
+		l.balances[t.From] -= t.Value
+		l.balances[t.To] += t.Value
+		l.nonces[t.From]++
+	}
+
+	record := TxRecord{Height: l.header.Height, Tx: t, Code: result.Code, Log: result.Log}
+	hash := hex.EncodeToString(cryptolib.GenHash(tx))
+	// a transaction included again is refused, but it was applied the first time.
+	earlier, exist := l.records[hash]
+	if !exist {
+		l.order = append(l.order, hash)
+		if len(l.order) > ResultLimit {
+			delete(l.records, l.order[0])
+			l.order = l.order[1:]
+		}
+	}
+	if !exist || earlier.Code != CodeOK {
+		l.records[hash] = record
+	}
+	if result.Code != CodeOK {
+		l.refused = append(l.refused, record)
+		if len(l.refused) > ResultLimit {
+			l.refused = l.refused[1:]
+		}
+	}
+	return result
+}
+
+func (l *Ledger) EndBlock(height int) {}
+
+// The state hash covers the balances and the nonces, in the order of the accounts.
+func (l *Ledger) Commit() []byte {
+	l.RLock()
+	defer l.RUnlock()
+	type account struct {
+		Name    string
+		Balance int
+		Nonce   int
+	}
+	var accounts []account
+	for name, balance := range l.balances {
+		accounts = append(accounts, account{Name: name, Balance: balance, Nonce: l.nonces[name]})
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	state, _ := msgpack.Marshal(accounts)
+	return cryptolib.GenHash(state)
+}
+
+func (l *Ledger) Query(path string, data []byte) ([]byte, error) {
+	l.RLock()
+	defer l.RUnlock()
+	switch path {
+	case "balance":
+		return []byte(strconv.Itoa(l.balances[string(data)])), nil
+	case "nonce":
+		return []byte(strconv.Itoa(l.nonces[string(data)])), nil
+	case "rewards":
+		return []byte(strconv.Itoa(l.rewards[string(data)])), nil
+	case "accounts":
+		return json.Marshal(l.balances)
+	case "tx":
+		record, exist := l.records[string(data)]
+		if !exist {
+			return nil, errors.New("unknown transaction")
+		}
+		return json.Marshal(record)
+	case "refused":
+		return json.Marshal(l.refused)
+	}
+	return nil, errors.New("unknown path " + path)
+}
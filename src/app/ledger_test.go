@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	"testing"
+)
+
+func transaction(from string, to string, value int, nonce int) []byte {
+	t := message.Transaction{From: from, To: to, Value: value, Nonce: nonce}
+	tx, _ := t.Serialize()
+	return tx
+}
+
+func TestLedger(test *testing.T) {
+	l := NewLedger()
+	toOne := transaction("0", "1", 40, 0)
+	toTwo := transaction("0", "2", 40, 1)
+
+	if l.CheckTx(toOne) != nil || l.CheckTx(transaction("", "3", CoinbaseReward, 0)) == nil {
+		test.Fatal("CheckTx")
+	}
+
+	// block 1 of replica 3: coinbase, the payment to 1, then the double spend to 2
+	l.BeginBlock(Header{Height: 1, Proposer: 3})
+	codes := []uint32{
+		l.DeliverTx(transaction("", "3", CoinbaseReward, 0)).Code,
+		l.DeliverTx(toOne).Code,
+		l.DeliverTx(toTwo).Code,
+		l.DeliverTx(transaction("1", "2", 40, 0)).Code,
+	}
+	l.EndBlock(1)
+	hash := l.Commit()
+	if codes[0] != CodeOK || codes[1] != CodeOK || codes[2] != CodeRefused || codes[3] != CodeOK {
+		test.Fatalf("codes %v", codes)
+	}
+
+	// the payment to 1 included again, and a coinbase that is not the first transaction
+	l.BeginBlock(Header{Height: 2, Proposer: 1})
+	if l.DeliverTx(transaction("", "1", CoinbaseReward, 0)).Code != CodeOK ||
+		l.DeliverTx(toOne).Code != CodeRefused ||
+		l.DeliverTx(transaction("", "1", CoinbaseReward, 0)).Code != CodeRefused {
+		test.Fatal("replay or second coinbase applied")
+	}
+	l.EndBlock(2)
+	l.Commit()
+
+	expected := map[string]string{"0": "10", "1": "50", "2": "40", "3": "50"}
+	for account, balance := range expected {
+		if b, _ := l.Query("balance", []byte(account)); string(b) != balance {
+			test.Fatalf("balance of %s: %s, expected %s", account, b, balance)
+		}
+	}
+	if r, _ := l.Query("rewards", []byte("1")); string(r) != "50" {
+		test.Fatalf("rewards of 1: %s", r)
+	}
+	var record TxRecord
+	data, _ := l.Query("tx", []byte(hex.EncodeToString(cryptolib.GenHash(toTwo))))
+	json.Unmarshal(data, &record)
+	if record.Code != CodeRefused || record.Height != 1 {
+		test.Fatalf("record of the double spend: %+v", record)
+	}
+	data, _ = l.Query("tx", []byte(hex.EncodeToString(cryptolib.GenHash(toOne))))
+	json.Unmarshal(data, &record)
+	if record.Code != CodeOK {
+		test.Fatalf("record of the payment: %+v", record)
+	}
+	var refused []TxRecord
+	data, _ = l.Query("refused", nil)
+	json.Unmarshal(data, &refused)
+	if len(refused) != 3 {
+		test.Fatalf("refused: %+v", refused)
+	}
+
+	// the state hash depends on the state only
+	other := NewLedger()
+	other.BeginBlock(Header{Height: 1, Proposer: 3})
+	other.DeliverTx(transaction("", "3", CoinbaseReward, 0))
+	other.DeliverTx(toOne)
+	other.DeliverTx(transaction("1", "2", 40, 0))
+	if string(other.Commit()) != string(hash) {
+		test.Fatal("the same state gives another hash")
+	}
+}
+
+func TestLedgerRecordLimit(test *testing.T) {
+	limit := ResultLimit
+	ResultLimit = 2
+	defer func() { ResultLimit = limit }()
+
+	l := NewLedger()
+	l.BeginBlock(Header{Height: 1})
+	for nonce := 0; nonce < 3; nonce++ {
+		l.DeliverTx(transaction("0", "1", 1, nonce))
+		l.DeliverTx(transaction("0", "1", 1000, nonce+1)) // overdraft
+	}
+	if _, err := l.Query("tx", []byte(hex.EncodeToString(cryptolib.GenHash(transaction("0", "1", 1, 0))))); err == nil {
+		test.Fatal("record of an old transaction kept")
+	}
+	if len(l.records) != 2 || len(l.refused) != 2 {
+		test.Fatalf("%d records and %d refused kept", len(l.records), len(l.refused))
+	}
+	if l.refused[1].Tx.Nonce != 3 {
+		test.Fatalf("refused: %+v", l.refused)
+	}
+}
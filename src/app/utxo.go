@@ -0,0 +1,381 @@
+package app
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	Register("utxo", func() Application { return NewUTXO() })
+}
+
+// An output is identified by the transaction creating it and its index in the transaction.
+// The outputs of Genesis are created by the transaction "genesis", in the order of the accounts.
+type OutPoint struct {
+	Tx    string `json:"tx"` // hex of the transaction id
+	Index int    `json:"index"`
+}
+
+type Output struct {
+	To     string `json:"to"`
+	Value  int    `json:"value"`
+	Height int    `json:"height"` // height of the block creating the output
+}
+
+// UTXOTx consumes Inputs and creates Outputs, worth at most the inputs.
+type UTXOTx struct {
+	Inputs  []OutPoint
+	Outputs []Output
+}
+
+func (t *UTXOTx) Serialize() ([]byte, error) {
+	return msgpack.Marshal(t)
+}
+
+// A spend of an output by a transaction, in the committed chain or in a fork.
+type Spend struct {
+	Tx        string `json:"tx"`
+	Height    int    `json:"height"`
+	Tip       string `json:"tip,omitempty"` // hex of the tip of the fork
+	Committed bool   `json:"committed"`
+}
+
+// An output spent by two transactions.
+type DoubleSpend struct {
+	Output OutPoint `json:"output"`
+	Spends []Spend  `json:"spends"`
+}
+
+var ErrSpent = errors.New("output already spent")
+
+// The unspent outputs after a sequence of blocks.
+type utxoState struct {
+	unspent map[OutPoint]Output
+	spentBy map[OutPoint]Spend
+	applied map[string]bool // ids of the applied transactions
+}
+
+func newUTXOState() *utxoState {
+	s := &utxoState{
+		unspent: make(map[OutPoint]Output),
+		spentBy: make(map[OutPoint]Spend),
+		applied: make(map[string]bool),
+	}
+	var accounts []string
+	for account := range Genesis {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	for i, account := range accounts {
+		s.unspent[OutPoint{Tx: "genesis", Index: i}] = Output{To: account, Value: Genesis[account]}
+	}
+	return s
+}
+
+// The unspent outputs of an account, oldest first.
+func (s *utxoState) outputsOf(account string) []OutPoint {
+	var points []OutPoint
+	for p, o := range s.unspent {
+		if o.To == account {
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		a, b := s.unspent[points[i]], s.unspent[points[j]]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if points[i].Tx != points[j].Tx {
+			return points[i].Tx < points[j].Tx
+		}
+		return points[i].Index < points[j].Index
+	})
+	return points
+}
+
+// Decode a transaction. A message.Transaction pays from the oldest outputs of From, with the
+// change back to From; a coinbase, from "", has no input.
+func (s *utxoState) decode(tx []byte) (UTXOTx, bool, error) {
+	var t UTXOTx
+	if err := msgpack.Unmarshal(tx, &t); err == nil && (len(t.Inputs) > 0 || len(t.Outputs) > 0) {
+		if len(t.Inputs) == 0 {
+			return t, false, fmt.Errorf("%w: no input", ErrInvalidTx)
+		}
+		return t, false, nil
+	}
+	payment, err := decodeTransaction(tx)
+	if err != nil {
+		return t, false, err
+	}
+	t.Outputs = []Output{{To: payment.To, Value: payment.Value}}
+	if payment.From == "" {
+		return t, true, nil
+	}
+	sum := 0
+	for _, p := range s.outputsOf(payment.From) {
+		if sum >= payment.Value {
+			break
+		}
+		t.Inputs = append(t.Inputs, p)
+		sum += s.unspent[p].Value
+	}
+	if sum < payment.Value {
+		return t, false, fmt.Errorf("%w: %s has %d, pays %d", ErrOverdraft, payment.From, sum, payment.Value)
+	}
+	if sum > payment.Value {
+		t.Outputs = append(t.Outputs, Output{To: payment.From, Value: sum - payment.Value})
+	}
+	return t, false, nil
+}
+
+// The id of a transaction. Coinbases of a leader are the same bytes, so their height is part of it.
+func txID(tx []byte, coinbase bool, height int) string {
+	if coinbase {
+		tx = append(append([]byte{}, tx...), []byte(strconv.Itoa(height))...)
+	}
+	return hex.EncodeToString(cryptolib.GenHash(tx))
+}
+
+// Apply the transaction at index of a block. The conflicting spend is returned with ErrSpent.
+func (s *utxoState) apply(tx []byte, header Header, index int, spend Spend) (string, Spend, error) {
+	t, coinbase, err := s.decode(tx)
+	id := txID(tx, coinbase, header.Height)
+	if err != nil {
+		return id, Spend{}, err
+	}
+	if s.applied[id] {
+		return id, Spend{}, fmt.Errorf("%w: transaction applied before", ErrNonce)
+	}
+	if coinbase && (index != 0 || t.Outputs[0].To != strconv.FormatInt(header.Proposer, 10) || t.Outputs[0].Value != CoinbaseReward) {
+		return id, Spend{}, ErrCoinbase
+	}
+	in, out := 0, 0
+	for _, p := range t.Inputs {
+		o, exist := s.unspent[p]
+		if !exist {
+			if earlier, spent := s.spentBy[p]; spent {
+				return id, earlier, fmt.Errorf("%w: %s:%d by %s", ErrSpent, p.Tx, p.Index, earlier.Tx)
+			}
+			return id, Spend{}, fmt.Errorf("%w: no output %s:%d", ErrInvalidTx, p.Tx, p.Index)
+		}
+		in += o.Value
+	}
+	for _, o := range t.Outputs {
+		if o.Value <= 0 || o.To == "" {
+			return id, Spend{}, fmt.Errorf("%w: output to %q of %d", ErrInvalidTx, o.To, o.Value)
+		}
+		out += o.Value
+	}
+	if !coinbase && out > in {
+		return id, Spend{}, fmt.Errorf("%w: outputs of %d for inputs of %d", ErrOverdraft, out, in)
+	}
+
+	spend.Tx = id
+	spend.Height = header.Height
+	for _, p := range t.Inputs {
+		delete(s.unspent, p)
+		s.spentBy[p] = spend
+	}
+	for i, o := range t.Outputs {
+		o.Height = header.Height
+		s.unspent[OutPoint{Tx: id, Index: i}] = o
+	}
+	s.applied[id] = true
+	return id, spend, nil
+}
+
+// UTXO is a payment application on unspent outputs. A transaction is a UTXOTx, or a
+// message.Transaction, which spends the oldest outputs of From. The application records the
+// transactions of the committed chain spending an output already spent, and, as a ForkAuditor,
+// the outputs spent by different transactions in the forks the replica received.
+//
+//	Query("balance", account)  coins in the unspent outputs of the account
+//	Query("unspent", account)  unspent outputs of the account, as JSON
+//	Query("conflicts")         DoubleSpends within the committed chain, as JSON
+//	Query("doublespends")      DoubleSpends across the committed chain and the forks, as JSON
+//	Query("refused")           TxRecords of the last ResultLimit refused transactions, as JSON
+type UTXO struct {
+	state        *utxoState
+	header       Header
+	index        int
+	conflicts    []DoubleSpend
+	doubleSpends []DoubleSpend
+	refused      []TxRecord
+	sync.RWMutex
+}
+
+func NewUTXO() *UTXO {
+	return &UTXO{state: newUTXOState()}
+}
+
+func (u *UTXO) CheckTx(tx []byte) error {
+	u.RLock()
+	defer u.RUnlock()
+	t, coinbase, err := u.state.decode(tx)
+	if err != nil {
+		return err
+	}
+	if coinbase {
+		return ErrCoinbase
+	}
+	for _, p := range t.Inputs {
+		if _, exist := u.state.unspent[p]; !exist {
+			return fmt.Errorf("%w: %s:%d", ErrSpent, p.Tx, p.Index)
+		}
+	}
+	return nil
+}
+
+func (u *UTXO) BeginBlock(header Header) {
+	u.Lock()
+	defer u.Unlock()
+	u.header = header
+	u.index = 0
+}
+
+func (u *UTXO) DeliverTx(tx []byte) Result {
+	u.Lock()
+	defer u.Unlock()
+	index := u.index
+	u.index++
+
+	id, earlier, err := u.state.apply(tx, u.header, index, Spend{Committed: true})
+	if err == nil {
+		return Result{}
+	}
+	result := Result{Code: CodeRefused, Log: err.Error()}
+	if errors.Is(err, ErrInvalidTx) {
+		result.Code = CodeInvalid
+	}
+	var payment message.Transaction
+	payment.Deserialize(tx)
+	u.refused = append(u.refused, TxRecord{Height: u.header.Height, Tx: payment, Code: result.Code, Log: result.Log})
+	if len(u.refused) > ResultLimit {
+		u.refused = u.refused[1:]
+	}
+	if errors.Is(err, ErrSpent) {
+		t, _, _ := u.state.decode(tx)
+		for _, p := range t.Inputs {
+			if _, exist := u.state.unspent[p]; !exist {
+				u.conflicts = append(u.conflicts, DoubleSpend{Output: p, Spends: []Spend{earlier,
+					{Tx: id, Height: u.header.Height, Committed: true}}})
+				break
+			}
+		}
+	}
+	return result
+}
+
+func (u *UTXO) EndBlock(height int) {}
+
+// The state hash covers the unspent outputs.
+func (u *UTXO) Commit() []byte {
+	u.RLock()
+	defer u.RUnlock()
+	type entry struct {
+		Point  OutPoint
+		Output Output
+	}
+	var entries []entry
+	for p, o := range u.state.unspent {
+		entries = append(entries, entry{Point: p, Output: o})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Point.Tx != entries[j].Point.Tx {
+			return entries[i].Point.Tx < entries[j].Point.Tx
+		}
+		return entries[i].Point.Index < entries[j].Point.Index
+	})
+	state, _ := msgpack.Marshal(entries)
+	return cryptolib.GenHash(state)
+}
+
+// AuditForks executes every branch from the genesis, and reports the outputs spent by different
+// transactions in the committed chain and the branches.
+func (u *UTXO) AuditForks(branches []Branch) interface{} {
+	spends := make(map[OutPoint][]Spend)
+	add := func(p OutPoint, s Spend) {
+		for _, other := range spends[p] {
+			if other.Tx == s.Tx {
+				return
+			}
+		}
+		spends[p] = append(spends[p], s)
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	for p, s := range u.state.spentBy {
+		add(p, s)
+	}
+	for _, c := range u.conflicts {
+		for _, s := range c.Spends {
+			add(c.Output, s)
+		}
+	}
+	for _, b := range branches {
+		state := newUTXOState()
+		tip := hex.EncodeToString(b.Tip)
+		for _, block := range b.Blocks {
+			header := Header{Height: block.Height, Hash: block.Hash, Proposer: block.Proposer}
+			for i, tx := range block.Txs {
+				state.apply(tx, header, i, Spend{Tip: tip})
+			}
+		}
+		for p, s := range state.spentBy {
+			add(p, s)
+		}
+	}
+
+	u.doubleSpends = nil
+	for p, list := range spends {
+		if len(list) > 1 {
+			sort.Slice(list, func(i, j int) bool { return list[i].Tx < list[j].Tx })
+			u.doubleSpends = append(u.doubleSpends, DoubleSpend{Output: p, Spends: list})
+		}
+	}
+	sort.Slice(u.doubleSpends, func(i, j int) bool {
+		a, b := u.doubleSpends[i].Output, u.doubleSpends[j].Output
+		return a.Tx < b.Tx || (a.Tx == b.Tx && a.Index < b.Index)
+	})
+	return u.doubleSpends
+}
+
+func (u *UTXO) Query(path string, data []byte) ([]byte, error) {
+	u.RLock()
+	defer u.RUnlock()
+	switch path {
+	case "balance":
+		sum := 0
+		for _, p := range u.state.outputsOf(string(data)) {
+			sum += u.state.unspent[p].Value
+		}
+		return []byte(strconv.Itoa(sum)), nil
+	case "unspent":
+		type unspent struct {
+			OutPoint
+			Output
+		}
+		list := []unspent{}
+		for _, p := range u.state.outputsOf(string(data)) {
+			list = append(list, unspent{OutPoint: p, Output: u.state.unspent[p]})
+		}
+		return json.Marshal(list)
+	case "conflicts":
+		return json.Marshal(u.conflicts)
+	case "doublespends":
+		return json.Marshal(u.doubleSpends)
+	case "refused":
+		return json.Marshal(u.refused)
+	}
+	return nil, errors.New("unknown path " + path)
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUTXO(test *testing.T) {
+	u := NewUTXO()
+	genesis := OutPoint{Tx: "genesis", Index: 0}
+	toOne := transaction("0", "1", 40, 0)
+	toTwo := transaction("0", "2", 40, 0)
+	again, _ := (&UTXOTx{Inputs: []OutPoint{genesis}, Outputs: []Output{{To: "3", Value: 50}}}).Serialize()
+
+	if u.CheckTx(toOne) != nil || u.CheckTx(again) != nil || u.CheckTx(transaction("", "3", CoinbaseReward, 0)) == nil {
+		test.Fatal("CheckTx")
+	}
+
+	// block 1 of replica 3: coinbase, the payment to 1 with the change to 0, then the genesis output again
+	u.BeginBlock(Header{Height: 1, Proposer: 3})
+	codes := []uint32{
+		u.DeliverTx(transaction("", "3", CoinbaseReward, 0)).Code,
+		u.DeliverTx(toOne).Code,
+		u.DeliverTx(again).Code,
+	}
+	u.EndBlock(1)
+	u.Commit()
+	if codes[0] != CodeOK || codes[1] != CodeOK || codes[2] != CodeRefused {
+		test.Fatalf("codes %v", codes)
+	}
+	if u.CheckTx(again) == nil {
+		test.Fatal("CheckTx of a spent output")
+	}
+	expected := map[string]string{"0": "10", "1": "40", "3": "50"}
+	for account, balance := range expected {
+		if b, _ := u.Query("balance", []byte(account)); string(b) != balance {
+			test.Fatalf("balance of %s: %s, expected %s", account, b, balance)
+		}
+	}
+	var conflicts []DoubleSpend
+	data, _ := u.Query("conflicts", nil)
+	json.Unmarshal(data, &conflicts)
+	if len(conflicts) != 1 || conflicts[0].Output != genesis {
+		test.Fatalf("conflicts: %+v", conflicts)
+	}
+
+	// a fork of replica 1 pays the genesis output to 2 instead
+	fork := Branch{Tip: []byte{1}, Blocks: []BranchBlock{{Height: 1, Hash: []byte{1}, Proposer: 1,
+		Txs: [][]byte{transaction("", "1", CoinbaseReward, 0), toTwo}}}}
+	same := Branch{Tip: []byte{2}, Blocks: []BranchBlock{{Height: 1, Hash: []byte{2}, Proposer: 3,
+		Txs: [][]byte{transaction("", "3", CoinbaseReward, 0), toOne}}}}
+	report := u.AuditForks([]Branch{fork, same}).([]DoubleSpend)
+	if len(report) != 1 || report[0].Output != genesis || len(report[0].Spends) != 3 {
+		test.Fatalf("double spends: %+v", report)
+	}
+	forked := 0
+	for _, s := range report[0].Spends {
+		if !s.Committed && s.Tip != "01" {
+			test.Fatalf("spend %+v", s)
+		}
+		if !s.Committed {
+			forked++
+		}
+	}
+	if forked != 1 {
+		test.Fatalf("spends of the fork: %+v", report[0].Spends)
+	}
+
+	// the same chain does not double-spend
+	if report := NewUTXO().AuditForks([]Branch{same}).([]DoubleSpend); len(report) != 0 {
+		test.Fatalf("double spends of one branch: %+v", report)
+	}
+}
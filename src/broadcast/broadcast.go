@@ -0,0 +1,217 @@
+/*
+Broadcast primitives among the replicas.
+  - RBC is the reliable broadcast of Bracha: if a correct replica delivers a value of an
+    instance, every correct replica delivers the same value, even if the sender is faulty.
+  - CBC is the consistent broadcast with a signature certificate: the correct replicas that
+    deliver a value of an instance deliver the same one, and the certificate proves it to others.
+  - ECRBC is the reliable broadcast of Cachin and Tessaro with erasure coding: the sender sends
+    each replica a fragment of the value only (see src/erasure).
+
+A broadcast is identified by its sender and an instance number, which counts the broadcasts of
+the sender. The protocols are state machines without network: Broadcast and Handle return the
+actions to carry out, which are sending messages and delivering values. See net.go for the use
+with the sender and the receiver.
+*/
+
+package broadcast
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Instances are forgotten after this time, delivered or not.
+const keepTime = time.Minute
+
+// Instances a replica may open at once, see liveSet. Its messages opening more are dropped until
+// some are delivered or forgotten, so a faulty replica cannot fill the memory of the others.
+const maxLive = 64
+
+// All is the destination of a message sent to every replica, the sender included.
+const All int64 = -1
+
+type Type int
+
+const (
+	SEND  Type = iota // the value from the sender, or the fragment of a replica in ECRBC
+	ECHO              // the value (RBC), a signature (CBC) or the fragment of the replica (ECRBC)
+	READY             // the digest (RBC) or the Merkle root (ECRBC) of the value to deliver
+	FINAL             // the value with its certificate (CBC)
+)
+
+// ID identifies a broadcast.
+type ID struct {
+	Sender   int64
+	Instance int
+}
+
+func (id ID) String() string {
+	return fmt.Sprintf("%d/%d", id.Sender, id.Instance)
+}
+
+type Message struct {
+	Kind    message.ProtocolType // message.RBC, message.CBC or message.ECRBC
+	Type    Type
+	Source  int64 // the replica sending the message
+	ID      ID
+	Value   []byte
+	Hash    []byte             // digest of the value, or Merkle root of the fragments
+	Sig     []byte             // signature of the digest, in an ECHO of CBC
+	Cert    message.Signatures // signatures of the digest, in a FINAL of CBC
+	Size    int                // bytes of the value, in ECRBC
+	Index   int                // index of the fragment in Value, in ECRBC
+	Branch  [][]byte
+	Indexes []int64
+}
+
+func (m *Message) Serialize() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func DeserializeMessage(input []byte) (Message, error) {
+	var m Message
+	err := msgpack.Unmarshal(input, &m)
+	return m, err
+}
+
+type ActionType int
+
+const (
+	SendAction    ActionType = iota // send Msg to To, or to every replica if To is All
+	DeliverAction                   // deliver Value as the value of the broadcast ID
+)
+
+type Action struct {
+	Type  ActionType
+	To    int64
+	Msg   Message
+	ID    ID
+	Value []byte
+}
+
+func send(to int64, m Message) Action {
+	return Action{Type: SendAction, To: to, Msg: m}
+}
+
+func deliver(id ID, value []byte) Action {
+	return Action{Type: DeliverAction, ID: id, Value: value}
+}
+
+// Protocol is a broadcast primitive run by one replica.
+type Protocol interface {
+	// Broadcast starts the broadcast of value by this replica, with an instance number not used
+	// before.
+	Broadcast(instance int, value []byte, now time.Time) []Action
+	// Handle handles a message received from the replica m.Source.
+	Handle(m Message, now time.Time) []Action
+}
+
+// Members are the replicas taking part, with at most F faulty ones among them.
+type Members struct {
+	Self  int64
+	Nodes []int64
+	F     int
+}
+
+func (g Members) member(replica int64) bool {
+	return g.index(replica) >= 0
+}
+
+// The index of a replica is its position in Nodes.
+func (g Members) index(replica int64) int {
+	for i := range g.Nodes {
+		if g.Nodes[i] == replica {
+			return i
+		}
+	}
+	return -1
+}
+
+// The number of messages of distinct replicas that intersect in a correct replica for any two
+// such sets: more than (n+f)/2.
+func (g Members) echoQuorum() int {
+	return (len(g.Nodes)+g.F)/2 + 1
+}
+
+// The instances kept by a protocol. An instance is charged to the replica whose message opened it,
+// the sender with its SEND or another replica with an early ECHO or READY, so that a faulty replica
+// only uses up its own quota. An instance is forgotten once delivered, and the instances delivered
+// are remembered for each sender, with a watermark below which all are taken as delivered, so that
+// a replayed message does not deliver a value again.
+type liveSet struct {
+	started   map[ID]time.Time
+	opener    map[ID]int64
+	count     map[int64]int // instances opened by each replica
+	delivered map[ID]bool   // instances delivered above the watermark of their sender
+	high      map[int64]int // the highest instance delivered of each sender
+}
+
+func newLiveSet() liveSet {
+	return liveSet{
+		started:   make(map[ID]time.Time),
+		opener:    make(map[ID]int64),
+		count:     make(map[int64]int),
+		delivered: make(map[ID]bool),
+		high:      make(map[int64]int),
+	}
+}
+
+// The instances of a sender more than maxLive below the highest one delivered are taken as
+// delivered.
+func (l *liveSet) watermark(sender int64) int {
+	return l.high[sender] - maxLive
+}
+
+// old reports whether the instance id was delivered already.
+func (l *liveSet) old(id ID) bool {
+	return l.delivered[id] || id.Instance <= l.watermark(id.Sender)
+}
+
+// open starts the instance id for a message of replica source, unless source has opened maxLive
+// instances already. It returns the old instances to forget, whether id is opened or not.
+func (l *liveSet) open(id ID, source int64, now time.Time) ([]ID, bool) {
+	var expired []ID
+	for key, started := range l.started {
+		if now.Sub(started) > keepTime {
+			l.forget(key)
+			expired = append(expired, key)
+		}
+	}
+	if l.count[source] >= maxLive {
+		p := fmt.Sprintf("[Broadcast Error] replica %d has opened %d broadcasts already, %v dropped", source, maxLive, id)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return expired, false
+	}
+	l.started[id] = now
+	l.opener[id] = source
+	l.count[source]++
+	return expired, true
+}
+
+func (l *liveSet) forget(id ID) {
+	if _, exist := l.started[id]; !exist {
+		return
+	}
+	l.count[l.opener[id]]--
+	delete(l.started, id)
+	delete(l.opener, id)
+}
+
+// done records the delivery of the instance id, to be forgotten by the protocol.
+func (l *liveSet) done(id ID) {
+	l.forget(id)
+	l.delivered[id] = true
+	if id.Instance <= l.high[id.Sender] {
+		return
+	}
+	l.high[id.Sender] = id.Instance
+	for key := range l.delivered {
+		if key.Sender == id.Sender && key.Instance <= l.watermark(id.Sender) {
+			delete(l.delivered, key)
+		}
+	}
+}
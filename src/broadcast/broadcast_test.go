@@ -0,0 +1,272 @@
+package broadcast
+
+import (
+	"bytes"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/message"
+	"testing"
+	"time"
+)
+
+var nodes = []int64{0, 1, 2, 3}
+
+type envelope struct {
+	to  int64
+	msg Message
+}
+
+// A network delivering the messages in order. Replicas without a protocol are crashed.
+type network struct {
+	protocols map[int64]Protocol
+	queue     []envelope
+	sent      []Message
+	delivered map[int64]map[ID][]byte
+}
+
+func newNetwork(create func(g Members) Protocol, crashed ...int64) *network {
+	net := &network{protocols: make(map[int64]Protocol), delivered: make(map[int64]map[ID][]byte)}
+	for _, node := range nodes {
+		net.protocols[node] = create(Members{Self: node, Nodes: nodes, F: 1})
+		net.delivered[node] = make(map[ID][]byte)
+	}
+	for _, node := range crashed {
+		net.protocols[node] = nil
+	}
+	return net
+}
+
+func (net *network) run(from int64, actions []Action) {
+	net.take(from, actions)
+	for len(net.queue) > 0 {
+		e := net.queue[0]
+		net.queue = net.queue[1:]
+		if p := net.protocols[e.to]; p != nil {
+			net.take(e.to, p.Handle(e.msg, time.Now()))
+		}
+	}
+}
+
+func (net *network) take(from int64, actions []Action) {
+	for _, a := range actions {
+		if a.Type == DeliverAction {
+			if _, exist := net.delivered[from][a.ID]; exist {
+				panic("a value is delivered twice")
+			}
+			net.delivered[from][a.ID] = a.Value
+			continue
+		}
+		net.sent = append(net.sent, a.Msg)
+		if a.To != All {
+			net.queue = append(net.queue, envelope{to: a.To, msg: a.Msg})
+			continue
+		}
+		for _, node := range nodes {
+			net.queue = append(net.queue, envelope{to: node, msg: a.Msg})
+		}
+	}
+}
+
+// Check that the correct replicas delivered value for id, or nothing if value is nil.
+func (net *network) check(test *testing.T, id ID, value []byte) {
+	for node, p := range net.protocols {
+		if p == nil {
+			continue
+		}
+		got, exist := net.delivered[node][id]
+		if exist != (value != nil) || !bytes.Equal(got, value) {
+			test.Fatalf("replica %d delivered %q for %v, %q expected", node, got, id, value)
+		}
+	}
+}
+
+func TestRBC(test *testing.T) {
+	net := newNetwork(func(g Members) Protocol { return NewRBC(g) }, 3)
+	value := []byte("a value")
+	net.run(0, net.protocols[0].Broadcast(1, value, time.Now()))
+	net.check(test, ID{Sender: 0, Instance: 1}, value)
+
+	// replica 3 sends two values: the correct replicas deliver the same one
+	net = newNetwork(func(g Members) Protocol { return NewRBC(g) })
+	id := ID{Sender: 3, Instance: 1}
+	net.protocols[3] = nil
+	var actions []Action
+	for _, to := range []int64{0, 1, 2} {
+		v := []byte("first")
+		if to == 2 {
+			v = []byte("second")
+		}
+		actions = append(actions, send(to, Message{Kind: message.RBC, Type: SEND, Source: 3, ID: id, Value: v}))
+	}
+	actions = append(actions, send(All, Message{Kind: message.RBC, Type: ECHO, Source: 3, ID: id, Value: []byte("second")}))
+	net.run(3, actions)
+	net.check(test, id, nil)
+	net.run(3, []Action{send(All, Message{Kind: message.RBC, Type: ECHO, Source: 3, ID: id, Value: []byte("first")})})
+	net.check(test, id, nil)
+	// a replica only echoes once, so the faulty one cannot make a quorum alone
+	net.run(2, []Action{send(All, Message{Kind: message.RBC, Type: ECHO, Source: 2, ID: id, Value: []byte("first")})})
+	net.check(test, id, nil)
+}
+
+func TestCBC(test *testing.T) {
+	keyring, err := cryptolib.NewKeyring(cryptolib.P256, nodes...)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cryptolib.SetVerifier(keyring)
+	create := func(g Members) Protocol {
+		signer := keyring.Signer(g.Self)
+		return NewCBC(g, func(msg []byte) []byte {
+			sig, _ := signer.Sign(msg)
+			return sig
+		})
+	}
+	net := newNetwork(create, 3)
+	value := []byte("a value")
+	net.run(1, net.protocols[1].Broadcast(7, value, time.Now()))
+	id := ID{Sender: 1, Instance: 7}
+	net.check(test, id, value)
+
+	// the certificate proves the value to others, and to no other value or broadcast
+	var cert message.Signatures
+	for _, m := range net.sent {
+		if m.Type == FINAL {
+			cert = m.Cert
+		}
+	}
+	g := Members{Self: 3, Nodes: nodes, F: 1}
+	if err := VerifyCert(g, id, value, cert); err != nil {
+		test.Fatal(err)
+	}
+	if VerifyCert(g, id, []byte("another value"), cert) == nil || VerifyCert(g, ID{Sender: 1, Instance: 8}, value, cert) == nil {
+		test.Fatal("the certificate is verified for another value or broadcast")
+	}
+	cert.Sigs = cert.Sigs[:2]
+	cert.IDs = cert.IDs[:2]
+	if VerifyCert(g, id, value, cert) == nil {
+		test.Fatal("a certificate with f+1 signatures is verified")
+	}
+}
+
+func TestECRBC(test *testing.T) {
+	net := newNetwork(func(g Members) Protocol { return NewECRBC(g) }, 2)
+	value := bytes.Repeat([]byte("a large proposal "), 1000)
+	actions := net.protocols[0].Broadcast(1, value, time.Now())
+	sent := 0
+	for _, a := range actions {
+		sent += len(a.Msg.Value)
+	}
+	// n fragments of |B|/(f+1) bytes
+	if sent > 2*len(value)+4 {
+		test.Fatalf("the sender sends %d bytes for a value of %d", sent, len(value))
+	}
+	net.run(0, actions)
+	net.check(test, ID{Sender: 0, Instance: 1}, value)
+
+	// a fragment is only accepted from the replica it belongs to
+	net = newNetwork(func(g Members) Protocol { return NewECRBC(g) })
+	actions = net.protocols[1].Broadcast(1, value, time.Now())
+	echo := actions[2].Msg
+	echo.Type = ECHO
+	echo.Source = 3
+	if len(net.protocols[0].Handle(echo, time.Now())) > 0 {
+		test.Fatal("the fragment of replica 2 is accepted from replica 3")
+	}
+	net.run(1, actions)
+	net.check(test, ID{Sender: 1, Instance: 1}, value)
+}
+
+func TestMaxLive(test *testing.T) {
+	r := NewRBC(Members{Self: 0, Nodes: nodes, F: 1})
+	now := time.Now()
+	msg := func(t Type, source int64, sender int64, instance int) Message {
+		return Message{Kind: message.RBC, Type: t, Source: source, ID: ID{Sender: sender, Instance: instance}, Value: []byte("v")}
+	}
+	// replica 3 opens broadcasts of replica 1 with its ECHO messages, up to its own quota
+	for i := 1; i <= maxLive+1; i++ {
+		r.Handle(msg(ECHO, 3, 1, i), now)
+	}
+	if len(r.instances) != maxLive {
+		test.Fatalf("%d instances opened by replica 3, for %d", len(r.instances), maxLive)
+	}
+	// replica 1 still broadcasts, and the old instances make room
+	r.Handle(msg(SEND, 1, 1, maxLive+2), now)
+	if _, exist := r.instances[ID{Sender: 1, Instance: maxLive + 2}]; !exist {
+		test.Fatal("the SEND of replica 1 is dropped")
+	}
+	r.Handle(msg(ECHO, 3, 1, maxLive+3), now.Add(keepTime+time.Second))
+	if _, exist := r.instances[ID{Sender: 1, Instance: maxLive + 3}]; !exist || len(r.instances) != 1 {
+		test.Fatalf("%d instances after the old ones are forgotten", len(r.instances))
+	}
+}
+
+// A value is delivered once, however late its messages are replayed.
+func TestDeliveredOnce(test *testing.T) {
+	net := newNetwork(func(g Members) Protocol { return NewRBC(g) })
+	r := net.protocols[0].(*RBC)
+	value := []byte("a value")
+	for i := 1; i <= maxLive+1; i++ {
+		net.run(1, net.protocols[1].Broadcast(i, value, time.Now()))
+	}
+	if len(r.instances) != 0 {
+		test.Fatalf("%d instances kept after delivery", len(r.instances))
+	}
+	// the messages of instance 1 again, after it is forgotten and below the watermark, and of the
+	// last instance above it
+	later := time.Now().Add(keepTime + time.Second)
+	for _, m := range net.sent {
+		if m.ID.Instance == 1 || m.ID.Instance == maxLive+1 {
+			if acts := r.Handle(m, later); len(acts) > 0 {
+				test.Fatalf("replayed message %v %v handled: %+v", m.Type, m.ID, acts)
+			}
+		}
+	}
+}
+
+func TestSignedMessages(test *testing.T) {
+	keyring, err := cryptolib.NewKeyring(cryptolib.P256, nodes...)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cryptolib.SetVerifier(keyring)
+	cryptolib.SetSigner(keyring.Signer(1))
+	m := Message{Kind: message.RBC, Type: READY, Source: 1, ID: ID{Sender: 2, Instance: 1}, Hash: digest([]byte("v"))}
+	input, err := sign(m)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if opened, err := Open(input); err != nil || opened.Source != 1 || opened.ID != m.ID {
+		test.Fatalf("message of replica 1: %+v %v", opened, err)
+	}
+
+	// replica 1 speaks for replica 3
+	m.Source = 3
+	forged, _ := sign(m)
+	if _, err := Open(forged); err == nil {
+		test.Fatal("a message of replica 3 signed by replica 1 is accepted")
+	}
+	// the signature of a message of the core
+	m.Source = 1
+	msgbyte, _ := m.Serialize()
+	core, _ := message.SerializeWithSignature(1, msgbyte)
+	if _, err := Open(core); err == nil {
+		test.Fatal("a message signed for the core is accepted")
+	}
+}
+
+func TestInstanceNumbers(test *testing.T) {
+	if err := db.OpenDB(test.TempDir()); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	Start(0, 1, nil)
+	first, second := newInstance(), newInstance()
+	if first != 1 || second != 2 {
+		test.Fatalf("instances %d and %d", first, second)
+	}
+	// a replica restarting from disk goes on above the numbers it reserved
+	Start(0, 1, nil)
+	if next := newInstance(); next <= second {
+		test.Fatalf("instance %d used again", next)
+	}
+}
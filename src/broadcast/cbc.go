@@ -0,0 +1,156 @@
+package broadcast
+
+import (
+	"bytes"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sleepy-hotstuff/src/utils"
+	"time"
+)
+
+type cbcInstance struct {
+	value  []byte // the value of this replica as sender
+	cert   message.Signatures
+	sent   bool
+	signed bool
+	final  bool
+}
+
+// CBC is the consistent broadcast with a signature certificate. The sender sends its value to all;
+// every replica signs the digest of the first value it receives from the sender and returns the
+// signature; with more than (n+f)/2 signatures, the sender sends the value with the signatures as
+// certificate, and the replicas deliver it once the certificate is verified. Two certificates of an
+// instance intersect in a correct replica, so they are for the same value. A faulty sender may
+// leave some correct replicas without the value.
+type CBC struct {
+	g         Members
+	sign      func(msg []byte) []byte
+	instances map[ID]*cbcInstance
+	live      liveSet
+}
+
+// NewCBC runs CBC for the replica g.Self, which signs with sign; the signatures of the others are
+// checked with the verifier of cryptolib.
+func NewCBC(g Members, sign func(msg []byte) []byte) *CBC {
+	return &CBC{g: g, sign: sign, instances: make(map[ID]*cbcInstance), live: newLiveSet()}
+}
+
+// The message a replica signs for the value of the broadcast id with the digest h.
+func signedDigest(id ID, h []byte) []byte {
+	input := append(utils.StringToBytes("CBC/"+id.String()+"/"), h...)
+	return digest(input)
+}
+
+// VerifyCert checks the certificate of the value of the broadcast id.
+func VerifyCert(g Members, id ID, value []byte, cert message.Signatures) error {
+	if !bytes.Equal(cert.Hash, digest(value)) || len(cert.Sigs) != len(cert.IDs) {
+		return fmt.Errorf("[Broadcast Error] the certificate of %v is not for its value", id)
+	}
+	signers := make(map[int64]bool)
+	msg := signedDigest(id, cert.Hash)
+	items := make([]cryptolib.SigItem, len(cert.IDs))
+	for i, signer := range cert.IDs {
+		if signers[signer] || !g.member(signer) {
+			return fmt.Errorf("[Broadcast Error] the certificate of %v has a signature of replica %d twice or of no member", id, signer)
+		}
+		signers[signer] = true
+		items[i] = cryptolib.SigItem{ID: signer, Msg: msg, Sig: cert.Sigs[i]}
+	}
+	if len(signers) < g.echoQuorum() {
+		return fmt.Errorf("[Broadcast Error] %d signatures in the certificate of %v, %d needed", len(signers), id, g.echoQuorum())
+	}
+	if !cryptolib.VerifyAll(items) {
+		return fmt.Errorf("[Broadcast Error] a signature of the certificate of %v is not verified", id)
+	}
+	return nil
+}
+
+// The instance of a message of replica source, or nil if it is delivered already or cannot be
+// opened.
+func (c *CBC) instance(id ID, source int64, now time.Time) *cbcInstance {
+	if c.live.old(id) {
+		return nil
+	}
+	inst, exist := c.instances[id]
+	if exist {
+		return inst
+	}
+	expired, ok := c.live.open(id, source, now)
+	for _, key := range expired {
+		delete(c.instances, key)
+	}
+	if !ok {
+		return nil
+	}
+	inst = &cbcInstance{}
+	c.instances[id] = inst
+	return inst
+}
+
+// Forget the instance id, delivered.
+func (c *CBC) done(id ID) {
+	c.live.done(id)
+	delete(c.instances, id)
+}
+
+func (c *CBC) Broadcast(instance int, value []byte, now time.Time) []Action {
+	id := ID{Sender: c.g.Self, Instance: instance}
+	inst := c.instance(id, c.g.Self, now)
+	if inst == nil {
+		return nil
+	}
+	inst.value = value
+	inst.sent = true
+	inst.cert = message.Signatures{Hash: digest(value)}
+	return []Action{send(All, Message{Kind: message.CBC, Type: SEND, Source: c.g.Self, ID: id, Value: value})}
+}
+
+func (c *CBC) Handle(m Message, now time.Time) []Action {
+	if m.Kind != message.CBC || !c.g.member(m.Source) || !c.g.member(m.ID.Sender) {
+		return nil
+	}
+	inst := c.instance(m.ID, m.Source, now)
+	if inst == nil {
+		return nil
+	}
+	switch m.Type {
+	case SEND:
+		if m.Source != m.ID.Sender || inst.signed {
+			return nil
+		}
+		inst.signed = true
+		h := digest(m.Value)
+		echo := Message{Kind: message.CBC, Type: ECHO, Source: c.g.Self, ID: m.ID, Hash: h, Sig: c.sign(signedDigest(m.ID, h))}
+		return []Action{send(m.ID.Sender, echo)}
+	case ECHO:
+		if m.ID.Sender != c.g.Self || !inst.sent || inst.final || !bytes.Equal(m.Hash, inst.cert.Hash) {
+			return nil
+		}
+		for _, signer := range inst.cert.IDs {
+			if signer == m.Source {
+				return nil
+			}
+		}
+		if !cryptolib.VerifySig(m.Source, signedDigest(m.ID, m.Hash), m.Sig) {
+			return nil
+		}
+		inst.cert.Sigs = append(inst.cert.Sigs, m.Sig)
+		inst.cert.IDs = append(inst.cert.IDs, m.Source)
+		if len(inst.cert.IDs) < c.g.echoQuorum() {
+			return nil
+		}
+		inst.final = true
+		final := Message{Kind: message.CBC, Type: FINAL, Source: c.g.Self, ID: m.ID, Value: inst.value, Cert: inst.cert}
+		return []Action{send(All, final)}
+	case FINAL:
+		if err := VerifyCert(c.g, m.ID, m.Value, m.Cert); err != nil {
+			logging.PrintLog(true, logging.ErrorLog, err.Error())
+			return nil
+		}
+		c.done(m.ID)
+		return []Action{deliver(m.ID, m.Value)}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package broadcast
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/erasure"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"time"
+)
+
+type ecrbcInstance struct {
+	frags     map[string]map[int]erasure.Fragment // fragments echoed, by key of the root and size
+	readies   map[string]map[int64]bool
+	voted     map[int64]map[Type]bool
+	sentEcho  bool
+	sentReady bool
+	delivered bool
+}
+
+// ECRBC is the reliable broadcast of Cachin and Tessaro. The sender splits its value into n
+// fragments, any f+1 of which give it back, and sends every replica its fragment with the Merkle
+// proof; every replica echoes its fragment to all. A replica is ready to deliver the value of a
+// Merkle root with more than (n+f)/2 echoes or f+1 ready replicas, and delivers it with 2f+1
+// ready replicas and f+1 fragments. The sender sends n/(f+1) times the value instead of n times.
+type ECRBC struct {
+	g         Members
+	instances map[ID]*ecrbcInstance
+	live      liveSet
+}
+
+func NewECRBC(g Members) *ECRBC {
+	return &ECRBC{g: g, instances: make(map[ID]*ecrbcInstance), live: newLiveSet()}
+}
+
+// The size of the value is not bound by the root, so the replicas agree on both.
+func ecrbcKey(root []byte, size int) string {
+	return fmt.Sprintf("%x/%d", root, size)
+}
+
+// The instance of a message of replica source, or nil if it is delivered already or cannot be
+// opened.
+func (e *ECRBC) instance(id ID, source int64, now time.Time) *ecrbcInstance {
+	if e.live.old(id) {
+		return nil
+	}
+	inst, exist := e.instances[id]
+	if exist {
+		return inst
+	}
+	expired, ok := e.live.open(id, source, now)
+	for _, key := range expired {
+		delete(e.instances, key)
+	}
+	if !ok {
+		return nil
+	}
+	inst = &ecrbcInstance{
+		frags:   make(map[string]map[int]erasure.Fragment),
+		readies: make(map[string]map[int64]bool),
+		voted:   make(map[int64]map[Type]bool),
+	}
+	e.instances[id] = inst
+	return inst
+}
+
+// Forget the instance id, delivered.
+func (e *ECRBC) done(id ID) {
+	e.live.done(id)
+	delete(e.instances, id)
+}
+
+func fragmentOf(m Message) erasure.Fragment {
+	return erasure.Fragment{Index: m.Index, Data: m.Value, Branch: m.Branch, Indexes: m.Indexes}
+}
+
+func (e *ECRBC) Broadcast(instance int, value []byte, now time.Time) []Action {
+	id := ID{Sender: e.g.Self, Instance: instance}
+	root, frags, err := erasure.Split(value, e.g.F+1, len(e.g.Nodes))
+	if err != nil {
+		logging.PrintLog(true, logging.ErrorLog, err.Error())
+		return nil
+	}
+	var actions []Action
+	for i, node := range e.g.Nodes {
+		f := frags[i]
+		m := Message{
+			Kind:    message.ECRBC,
+			Type:    SEND,
+			Source:  e.g.Self,
+			ID:      id,
+			Value:   f.Data,
+			Hash:    root,
+			Size:    len(value),
+			Index:   f.Index,
+			Branch:  f.Branch,
+			Indexes: f.Indexes,
+		}
+		actions = append(actions, send(node, m))
+	}
+	return actions
+}
+
+func (e *ECRBC) Handle(m Message, now time.Time) []Action {
+	if m.Kind != message.ECRBC || !e.g.member(m.Source) || !e.g.member(m.ID.Sender) {
+		return nil
+	}
+	owner := m.Source
+	if m.Type == SEND {
+		owner = e.g.Self
+	}
+	if m.Type != READY && (e.g.index(owner) != m.Index || !fragmentOf(m).Verify(m.Hash)) {
+		p := fmt.Sprintf("[Broadcast Error] fragment %d of %v from replica %d not verified", m.Index, m.ID, m.Source)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return nil
+	}
+	inst := e.instance(m.ID, m.Source, now)
+	if inst == nil {
+		return nil
+	}
+	if m.Type == ECHO || m.Type == READY {
+		if inst.voted[m.Source] == nil {
+			inst.voted[m.Source] = make(map[Type]bool)
+		}
+		if inst.voted[m.Source][m.Type] {
+			return nil
+		}
+		inst.voted[m.Source][m.Type] = true
+	}
+
+	key := ecrbcKey(m.Hash, m.Size)
+	var actions []Action
+	switch m.Type {
+	case SEND:
+		if m.Source != m.ID.Sender || inst.sentEcho {
+			return nil
+		}
+		inst.sentEcho = true
+		echo := m
+		echo.Type = ECHO
+		echo.Source = e.g.Self
+		actions = append(actions, send(All, echo))
+	case ECHO:
+		if inst.frags[key] == nil {
+			inst.frags[key] = make(map[int]erasure.Fragment)
+		}
+		inst.frags[key][m.Index] = fragmentOf(m)
+		if len(inst.frags[key]) >= e.g.echoQuorum() {
+			actions = append(actions, e.ready(inst, m)...)
+		}
+	case READY:
+		add(inst.readies, key, m.Source)
+		if len(inst.readies[key]) >= e.g.F+1 {
+			actions = append(actions, e.ready(inst, m)...)
+		}
+	}
+
+	if inst.delivered || len(inst.readies[key]) < 2*e.g.F+1 || len(inst.frags[key]) < e.g.F+1 {
+		return actions
+	}
+	inst.delivered = true
+	var frags []erasure.Fragment
+	for _, f := range inst.frags[key] {
+		frags = append(frags, f)
+	}
+	value, err := erasure.Reconstruct(frags, e.g.F+1, len(e.g.Nodes), m.Size, m.Hash)
+	if err != nil {
+		// every correct replica finds the same, and none delivers
+		p := fmt.Sprintf("[Broadcast Error] the value of %v is not delivered: %v", m.ID, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return actions
+	}
+	e.done(m.ID)
+	return append(actions, deliver(m.ID, value))
+}
+
+func (e *ECRBC) ready(inst *ecrbcInstance, m Message) []Action {
+	if inst.sentReady {
+		return nil
+	}
+	inst.sentReady = true
+	ready := Message{Kind: message.ECRBC, Type: READY, Source: e.g.Self, ID: m.ID, Hash: m.Hash, Size: m.Size}
+	return []Action{send(All, ready)}
+}
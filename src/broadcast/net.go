@@ -0,0 +1,196 @@
+package broadcast
+
+import (
+	"fmt"
+	"log"
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sleepy-hotstuff/src/utils"
+	"sync"
+	"time"
+)
+
+/*
+The protocols of this replica, run with the sender and the receiver. The messages of RBC, CBC
+and ECRBC travel through RBCSendByteMsg, CBCSendByteMsg and ECRBCSendByteMsg, signed by their
+Source, so that no node can speak for a replica, with or without TLS.
+*/
+
+// The key of the instance numbers reserved in the database. They are reserved by blocks of
+// instanceBlock, so that a replica restarting from disk does not use a number again.
+const instanceKey = "broadcastInstance"
+const instanceBlock = 1000
+
+var lock sync.Mutex
+var self int64
+var protocols map[message.ProtocolType]Protocol
+var handlers = make(map[message.ProtocolType]func(id ID, value []byte))
+var nextInstance int // the instance of the last broadcast of this replica
+var reserved int     // the last instance number reserved
+var active func() bool
+
+// The bytes a replica signs for a message of the broadcast protocols, so that the signature is
+// not one of a message of the core.
+func signedBytes(msgbyte []byte) []byte {
+	return append(utils.StringToBytes("broadcast/"), msgbyte...)
+}
+
+// Sign a message of this replica.
+func sign(m Message) ([]byte, error) {
+	msgbyte, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	signed := message.MessageWithSignature{Msg: msgbyte, Sig: cryptolib.GenSig(signedBytes(msgbyte))}
+	return signed.Serialize()
+}
+
+// Open returns the message of a replica received in input, if it is signed by its Source.
+func Open(input []byte) (Message, error) {
+	signed := message.DeserializeMessageWithSignature(input)
+	m, err := DeserializeMessage(signed.Msg)
+	if err != nil {
+		return m, err
+	}
+	if !cryptolib.VerifySig(m.Source, signedBytes(signed.Msg), signed.Sig) {
+		return m, fmt.Errorf("[Broadcast Error] message for %v not signed by replica %d", m.ID, m.Source)
+	}
+	return m, nil
+}
+
+// Start runs the protocols for replica id among the replicas of the configuration, with at most
+// f faulty ones. Messages are dropped while isActive is false, e.g., while the replica sleeps.
+func Start(id int64, f int, isActive func() bool) {
+	var nodes []int64
+	for _, nid := range config.FetchNodes() {
+		node, err := utils.StringToInt64(nid)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	g := Members{Self: id, Nodes: nodes, F: f}
+
+	lock.Lock()
+	defer lock.Unlock()
+	self = id
+	active = isActive
+	protocols = map[message.ProtocolType]Protocol{
+		message.RBC:   NewRBC(g),
+		message.CBC:   NewCBC(g, cryptolib.GenSig),
+		message.ECRBC: NewECRBC(g),
+	}
+	var stored utils.IntValue
+	if err := db.ReadDB(instanceKey, &stored); err != nil && err != db.ErrNotFound {
+		log.Printf("[Broadcast Error] cannot read the reserved instance numbers: %v", err)
+	}
+	nextInstance = stored.Get()
+	reserved = nextInstance
+}
+
+// The instance number of a new broadcast of this replica.
+func newInstance() int {
+	nextInstance++
+	if nextInstance > reserved {
+		reserved = nextInstance + instanceBlock - 1
+		var v utils.IntValue
+		v.Set(reserved)
+		if err := db.WriteDB(instanceKey, &v); err != nil {
+			log.Printf("[Broadcast Error] cannot reserve instance numbers: %v", err)
+		}
+	}
+	return nextInstance
+}
+
+// OnDeliver installs the handler of the values delivered by the protocol kind.
+func OnDeliver(kind message.ProtocolType, handler func(id ID, value []byte)) {
+	lock.Lock()
+	defer lock.Unlock()
+	handlers[kind] = handler
+}
+
+// Broadcast starts a broadcast of value with the protocol kind, and returns its ID.
+func Broadcast(kind message.ProtocolType, value []byte) (ID, error) {
+	lock.Lock()
+	p, exist := protocols[kind]
+	if !exist {
+		lock.Unlock()
+		return ID{}, fmt.Errorf("[Broadcast Error] protocol %v not started", kind)
+	}
+	id := ID{Sender: self, Instance: newInstance()}
+	deliveries := run(kind, p.Broadcast(id.Instance, value, time.Now()))
+	lock.Unlock()
+
+	notify(kind, deliveries)
+	return id, nil
+}
+
+// HandleMsg handles a message of another replica, received by the receiver.
+func HandleMsg(input []byte) {
+	m, err := Open(input)
+	if err != nil {
+		logging.PrintLog(true, logging.ErrorLog, err.Error())
+		return
+	}
+	lock.Lock()
+	p, exist := protocols[m.Kind]
+	if !exist || (active != nil && !active()) {
+		lock.Unlock()
+		return
+	}
+	deliveries := run(m.Kind, p.Handle(m, time.Now()))
+	lock.Unlock()
+
+	notify(m.Kind, deliveries)
+}
+
+// Carry out the actions of the protocol kind, handling at once the messages to this replica, and
+// return the values delivered.
+func run(kind message.ProtocolType, actions []Action) []Action {
+	p := protocols[kind]
+	var deliveries []Action
+	for len(actions) > 0 {
+		a := actions[0]
+		actions = actions[1:]
+		if a.Type == DeliverAction {
+			deliveries = append(deliveries, a)
+			continue
+		}
+		if a.To == All || a.To == self {
+			actions = append(actions, p.Handle(a.Msg, time.Now())...)
+		}
+		if a.To == self {
+			continue
+		}
+		msgbyte, err := sign(a.Msg)
+		if err != nil {
+			logging.PrintLog(true, logging.ErrorLog, "[Broadcast Error] Not able to sign the message")
+			continue
+		}
+		if a.To == All {
+			for _, nid := range config.FetchNodes() {
+				dest, _ := utils.StringToInt64(nid)
+				sender.BroadcastSend(msgbyte, dest, kind)
+			}
+		} else {
+			sender.BroadcastSend(msgbyte, a.To, kind)
+		}
+	}
+	return deliveries
+}
+
+func notify(kind message.ProtocolType, deliveries []Action) {
+	lock.Lock()
+	handler := handlers[kind]
+	lock.Unlock()
+	if handler == nil {
+		return
+	}
+	for _, a := range deliveries {
+		handler(a.ID, a.Value)
+	}
+}
@@ -0,0 +1,142 @@
+package broadcast
+
+import (
+	"crypto/sha256"
+	"sleepy-hotstuff/src/message"
+	"time"
+)
+
+func digest(value []byte) []byte {
+	h := sha256.Sum256(value)
+	return h[:]
+}
+
+type rbcInstance struct {
+	values    map[string][]byte         // values echoed, by digest
+	echoes    map[string]map[int64]bool // replicas echoing each digest
+	readies   map[string]map[int64]bool // replicas ready to deliver each digest
+	voted     map[int64]map[Type]bool   // the ECHO and READY of each replica, counted once
+	sentEcho  bool
+	sentReady bool
+}
+
+// RBC is the reliable broadcast of Bracha. The sender sends its value to all; every replica echoes
+// the first value it receives from the sender; a replica is ready to deliver a value with more
+// than (n+f)/2 echoes or f+1 ready replicas, and delivers it with 2f+1 ready replicas.
+type RBC struct {
+	g         Members
+	instances map[ID]*rbcInstance
+	live      liveSet
+}
+
+func NewRBC(g Members) *RBC {
+	return &RBC{g: g, instances: make(map[ID]*rbcInstance), live: newLiveSet()}
+}
+
+// The instance of a message of replica source, or nil if it is delivered already or cannot be
+// opened.
+func (r *RBC) instance(id ID, source int64, now time.Time) *rbcInstance {
+	if r.live.old(id) {
+		return nil
+	}
+	inst, exist := r.instances[id]
+	if exist {
+		return inst
+	}
+	expired, ok := r.live.open(id, source, now)
+	for _, key := range expired {
+		delete(r.instances, key)
+	}
+	if !ok {
+		return nil
+	}
+	inst = &rbcInstance{
+		values:  make(map[string][]byte),
+		echoes:  make(map[string]map[int64]bool),
+		readies: make(map[string]map[int64]bool),
+		voted:   make(map[int64]map[Type]bool),
+	}
+	r.instances[id] = inst
+	return inst
+}
+
+// Forget the instance id, delivered.
+func (r *RBC) done(id ID) {
+	r.live.done(id)
+	delete(r.instances, id)
+}
+
+func (r *RBC) Broadcast(instance int, value []byte, now time.Time) []Action {
+	id := ID{Sender: r.g.Self, Instance: instance}
+	return []Action{send(All, Message{Kind: message.RBC, Type: SEND, Source: r.g.Self, ID: id, Value: value})}
+}
+
+func (r *RBC) Handle(m Message, now time.Time) []Action {
+	if m.Kind != message.RBC || !r.g.member(m.Source) || !r.g.member(m.ID.Sender) {
+		return nil
+	}
+	inst := r.instance(m.ID, m.Source, now)
+	if inst == nil {
+		return nil
+	}
+	if m.Type == ECHO || m.Type == READY {
+		if inst.voted[m.Source] == nil {
+			inst.voted[m.Source] = make(map[Type]bool)
+		}
+		if inst.voted[m.Source][m.Type] {
+			return nil
+		}
+		inst.voted[m.Source][m.Type] = true
+	}
+
+	var actions []Action
+	switch m.Type {
+	case SEND:
+		if m.Source != m.ID.Sender || inst.sentEcho {
+			return nil
+		}
+		inst.sentEcho = true
+		echo := Message{Kind: message.RBC, Type: ECHO, Source: r.g.Self, ID: m.ID, Value: m.Value}
+		actions = append(actions, send(All, echo))
+	case ECHO:
+		h := digest(m.Value)
+		key := string(h)
+		inst.values[key] = m.Value
+		add(inst.echoes, key, m.Source)
+		if len(inst.echoes[key]) >= r.g.echoQuorum() {
+			actions = append(actions, r.ready(inst, m.ID, h)...)
+		}
+	case READY:
+		key := string(m.Hash)
+		add(inst.readies, key, m.Source)
+		if len(inst.readies[key]) >= r.g.F+1 {
+			actions = append(actions, r.ready(inst, m.ID, m.Hash)...)
+		}
+	}
+
+	// the value may arrive after the READY messages that deliver it
+	for key, readies := range inst.readies {
+		value, known := inst.values[key]
+		if len(readies) >= 2*r.g.F+1 && known {
+			r.done(m.ID)
+			actions = append(actions, deliver(m.ID, value))
+			break
+		}
+	}
+	return actions
+}
+
+func (r *RBC) ready(inst *rbcInstance, id ID, h []byte) []Action {
+	if inst.sentReady {
+		return nil
+	}
+	inst.sentReady = true
+	return []Action{send(All, Message{Kind: message.RBC, Type: READY, Source: r.g.Self, ID: id, Hash: h})}
+}
+
+func add(votes map[string]map[int64]bool, key string, replica int64) {
+	if votes[key] == nil {
+		votes[key] = make(map[int64]bool)
+	}
+	votes[key][replica] = true
+}
@@ -2,12 +2,14 @@ package clientsender
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sleepy-hotstuff/src/communication"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/cryptolib"
 	logging "sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
 	pb "sleepy-hotstuff/src/proto/communication"
 	"sleepy-hotstuff/src/utils"
 	"sync"
@@ -28,7 +30,11 @@ func BuildConnection(ctx context.Context, nid string, address string) bool {
 	p := fmt.Sprintf("[Client Sender] builidng a connection with %v", nid)
 	logging.PrintLog(verbose, logging.NormalLog, p)
 
-	conn, err := grpc.DialContext(ctx, address, dialOpt...)
+	opts := dialOpt
+	if communication.TLSEnabled() {
+		opts = communication.GetDialOption(nid)
+	}
+	conn, err := grpc.DialContext(ctx, address, opts...)
 	if err != nil {
 		p := fmt.Sprintf("[Client Sender] failed to bulid a connection with %v", err)
 		logging.PrintLog(true, logging.ErrorLog, p)
@@ -40,13 +46,8 @@ func BuildConnection(ctx context.Context, nid string, address string) bool {
 	return true
 }
 
-func SendRequest(rtype pb.MessageType, t1 int64, op []byte, address string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(clientTimer)*time.Millisecond)
-	defer cancel()
-
-	nid := config.FetchReplicaID(address)
-	//v, err := utils.StringToInt64(nid)
-
+// The connection to the replica at address, built if needed. The address is updated with split ports.
+func connect(ctx context.Context, nid string, address string) (pb.SendClient, string, bool) {
 	if config.SplitPorts() {
 		address = communication.UpdateAddress(address)
 	}
@@ -60,14 +61,27 @@ func SendRequest(rtype pb.MessageType, t1 int64, op []byte, address string) {
 			logging.PrintLog(true, logging.ErrorLog, p)
 			communication.NotLive(nid)
 			clientTimer = clientTimer * 2
-
-			//CatchSendRequestError(v, op, rtype, t1)
-			wg.Done()
-			return
+			return nil, address, false
 		} else {
 			c, _ = connections.Get(address)
 		}
 	}
+	return c, address, true
+}
+
+func SendRequest(rtype pb.MessageType, t1 int64, op []byte, address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(clientTimer)*time.Millisecond)
+	defer cancel()
+
+	nid := config.FetchReplicaID(address)
+	//v, err := utils.StringToInt64(nid)
+
+	c, address, suc := connect(ctx, nid, address)
+	if !suc {
+		//CatchSendRequestError(v, op, rtype, t1)
+		wg.Done()
+		return
+	}
 
 	var r *pb.RawMessage
 	r, err = c.SendRequest(ctx, &pb.Request{Type: rtype, Request: op})
@@ -109,6 +123,80 @@ func BroadcastRequest(rtype pb.MessageType, op []byte) {
 	wg.Wait()
 }
 
+// SubmitRequest sends a request to a single live replica, which forwards it to the leaders when
+// forwardRequests is set in conf.json. The replicas are tried in turn, from the one given by the
+// id of the client, until one accepts the request.
+func SubmitRequest(rtype pb.MessageType, op []byte) error {
+	nodes := communication.FetchNodesFromConfig()
+	for i := 0; i < len(nodes); i++ {
+		nid := nodes[(int(id)+i)%len(nodes)]
+		if communication.IsNotLive(nid) {
+			continue
+		}
+		_, err := SendRequestWithLevel(rtype, op, message.LevelAsync, config.FetchAddress(nid))
+		if err == nil {
+			return nil
+		}
+		p := fmt.Sprintf("[Client Sender Error] could not submit the request to node %s, %v", nid, err)
+		logging.PrintLog(verbose, logging.ErrorLog, p)
+	}
+	return errors.New("[Client Sender Error] no replica accepted the request")
+}
+
+// SendRequestWithLevel sends a request with a consistency level (message.LevelConsensus, ...)
+// to the replica at address, and returns its reply.
+func SendRequestWithLevel(rtype pb.MessageType, op []byte, level string, address string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(clientTimer)*time.Millisecond)
+	defer cancel()
+
+	nid := config.FetchReplicaID(address)
+	c, address, suc := connect(ctx, nid, address)
+	if !suc {
+		return nil, fmt.Errorf("[Client Sender Error] did not connect to node %s", nid)
+	}
+	r, err := c.SendRequest(ctx, &pb.Request{Type: rtype, Request: op, Version: level})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetMsg(), nil
+}
+
+// BroadcastRequestWithLevel sends a request with a consistency level to every live replica, and
+// returns the replies by replica id. Replicas that fail are left out.
+func BroadcastRequestWithLevel(rtype pb.MessageType, op []byte, level string) map[string][]byte {
+	replies := make(map[string][]byte)
+	var lock sync.Mutex
+	var done sync.WaitGroup
+
+	nodes := communication.FetchNodesFromConfig()
+	for i := 0; i < len(nodes); i++ {
+		nid := nodes[i]
+		if communication.IsNotLive(nid) {
+			continue
+		}
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			reply, err := SendRequestWithLevel(rtype, op, level, config.FetchAddress(nid))
+			if err != nil {
+				p := fmt.Sprintf("[Client Sender Error] could not get reply from node %s, %v", nid, err)
+				logging.PrintLog(verbose, logging.ErrorLog, p)
+				return
+			}
+			lock.Lock()
+			replies[nid] = reply
+			lock.Unlock()
+		}()
+	}
+	done.Wait()
+	return replies
+}
+
+// The id of the client.
+func ID() int64 {
+	return id
+}
+
 func StartClientSender(cid string, loadkey bool) {
 
 	config.LoadConfig()
@@ -123,6 +211,11 @@ func StartClientSender(cid string, loadkey bool) {
 	cryptolib.StartCrypto(id, config.CryptoOption())
 
 	communication.StartConnectionManager()
+	if communication.TLSEnabled() {
+		if err := communication.LoadTLS(cid); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	connections.Init()
 
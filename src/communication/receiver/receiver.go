@@ -7,16 +7,21 @@ package receiver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"sleepy-hotstuff/src/admin"
+	"sleepy-hotstuff/src/broadcast"
 	"sleepy-hotstuff/src/communication"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/consensus"
 	"sleepy-hotstuff/src/cryptolib"
 	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
 	logging "sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
 	pb "sleepy-hotstuff/src/proto/communication"
 	"sleepy-hotstuff/src/utils"
 	"sync"
@@ -51,7 +56,10 @@ func (s *server) SendRequest(ctx context.Context, in *pb.Request) (*pb.RawMessag
 		// if the ctx is cancelled or timeout, this message has no need to process.
 		return nil, err
 	}
-	return HandleRequest(in)
+	if err := checkRequestSource(ctx, in); err != nil {
+		return nil, err
+	}
+	return HandleRequestWithLevel(ctx, in)
 }
 
 func (s *reserver) SendRequest(ctx context.Context, in *pb.Request) (*pb.RawMessage, error) {
@@ -59,7 +67,88 @@ func (s *reserver) SendRequest(ctx context.Context, in *pb.Request) (*pb.RawMess
 		// if the ctx is cancelled or timeout, this message has no need to process.
 		return nil, err
 	}
-	return HandleRequest(in)
+	if err := checkRequestSource(ctx, in); err != nil {
+		return nil, err
+	}
+	return HandleRequestWithLevel(ctx, in)
+}
+
+// With TLS, a client may only send its own requests: the ID of every request
+// must be the id in the certificate of the client.
+func checkRequestSource(ctx context.Context, in *pb.Request) error {
+	if !communication.TLSEnabled() {
+		return nil
+	}
+	pid, ok := communication.PeerID(ctx)
+	if !ok {
+		return errors.New("[Communication Receiver Error] request without a client certificate")
+	}
+	requests := [][]byte{in.GetRequest()}
+	if in.GetType() == pb.MessageType_WRITE_BATCH {
+		requests = consensus.DeserializeRequests(in.GetRequest())
+	}
+	for i := 0; i < len(requests); i++ {
+		rawMessage := message.DeserializeMessageWithSignature(requests[i])
+		cr := message.DeserializeClientRequest(rawMessage.Msg)
+		if cr.ID != pid {
+			p := fmt.Sprintf("[Communication Receiver Error] client %v sent a request of client %v", pid, cr.ID)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return errors.New(p)
+		}
+	}
+	return nil
+}
+
+// With TLS, a replica message is only accepted from the replica named as its Source. A TQC is
+// accepted from any replica: replicas forward the TQCs of others, which are verified with the
+// signatures they carry.
+func checkReplicaSource(ctx context.Context, msg []byte) bool {
+	if !communication.TLSEnabled() {
+		return true
+	}
+	pid, ok := communication.PeerID(ctx)
+	if !ok {
+		logging.PrintLog(true, logging.ErrorLog, "[Communication Receiver Error] replica message without a certificate")
+		return false
+	}
+	tmp := message.DeserializeMessageWithSignature(msg)
+	content := message.DeserializeHotStuffMessage(tmp.Msg)
+	forwarded := content.Mtype == pb.MessageType_TQC
+	if (content.Source != pid && !forwarded) || config.FetchAddress(utils.Int64ToString(pid)) == "" {
+		p := fmt.Sprintf("[Communication Receiver Error] node %v sent a message of replica %v", pid, content.Source)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return false
+	}
+	return true
+}
+
+// Handle a request with the consistency level in its version field. Requests of the consensus
+// level are ordered, and the reply is their result once executed; reads of the lease and quorum
+// levels are served from the executed state, without consensus.
+func HandleRequestWithLevel(ctx context.Context, in *pb.Request) (*pb.RawMessage, error) {
+	var reply []byte
+	var err error
+	switch in.GetVersion() {
+	case message.LevelAsync:
+		return HandleRequest(in)
+	case message.LevelConsensus:
+		if in.GetType() == pb.MessageType_WRITE_BATCH {
+			return nil, errors.New("[Communication Receiver Error] batches have no results")
+		}
+		h := cryptolib.GenHash(in.GetRequest())
+		go consensus.HandleRequest(in.GetRequest(), utils.BytesToString(h))
+		reply, err = consensus.AwaitResponse(ctx, in.GetRequest())
+	case message.LevelLease:
+		reply, err = consensus.LeaseRead(in.GetRequest())
+	case message.LevelQuorum:
+		reply, err = consensus.LocalRead(in.GetRequest())
+	default:
+		err = fmt.Errorf("[Communication Receiver Error] unknown consistency level %q", in.GetVersion())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RawMessage{Msg: reply, Result: true}, nil
 }
 
 // Handle the request received in SendRequest.
@@ -103,6 +192,13 @@ func (s *server) ABASendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Emp
 	return &pb.Empty{}, nil
 }
 
+// Evidence of equivocation gossiped by other replicas. The evidence is verified on its own,
+// whoever sends it.
+func (s *server) SimpleSendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
+	go evidence.HandleEvidenceMsg(in.GetMsg())
+	return &pb.Empty{}, nil
+}
+
 func (s *server) HACSSSendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
 	// go hacss.HandleHACSSMsg(in.GetMsg())
 	return &pb.Empty{}, nil
@@ -119,12 +215,46 @@ func (s *server) HotStuffSendByteMsg(ctx context.Context, in *pb.RawMessage) (*p
 	//	return &pb.Empty{}, nil
 	//}
 
+	if !checkReplicaSource(ctx, in.GetMsg()) {
+		return &pb.Empty{}, nil
+	}
+
 	go consensus.HandleQCByteMsg(in.GetMsg())
 	consensus.MsgQueue.AppendAndTrimToMaxSize(in.GetMsg())
 	db.PersistValue("MsgQueue", &consensus.MsgQueue, db.PersistAll)
 	return &pb.Empty{}, nil
 }
 
+// Messages of the broadcast protocols, see src/broadcast. They are signed by their Source, and
+// with TLS they are only accepted from it.
+func handleBroadcastMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if communication.TLSEnabled() {
+		pid, ok := communication.PeerID(ctx)
+		m, err := broadcast.DeserializeMessage(message.DeserializeMessageWithSignature(in.GetMsg()).Msg)
+		if !ok || err != nil || m.Source != pid {
+			logging.PrintLog(true, logging.ErrorLog, "[Communication Receiver Error] broadcast message not sent by its source")
+			return &pb.Empty{}, nil
+		}
+	}
+	go broadcast.HandleMsg(in.GetMsg())
+	return &pb.Empty{}, nil
+}
+
+func (s *server) RBCSendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
+	return handleBroadcastMsg(ctx, in)
+}
+
+func (s *server) CBCSendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
+	return handleBroadcastMsg(ctx, in)
+}
+
+func (s *server) ECRBCSendByteMsg(ctx context.Context, in *pb.RawMessage) (*pb.Empty, error) {
+	return handleBroadcastMsg(ctx, in)
+}
+
 /*
 Handle join requests for both static membership (initialization) and dynamic membership.
 Each replica gets a conformation for a membership request.
@@ -159,6 +289,14 @@ func register(port string, splitPort bool) {
 
 }
 
+func serverOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(52428800), grpc.MaxSendMsgSize(52428800)}
+	if communication.TLSEnabled() {
+		opts = append(opts, communication.GetServerOption())
+	}
+	return opts
+}
+
 /*
 Have serve grpc as a function (could be used together with goroutine)
 */
@@ -167,7 +305,7 @@ func serveGRPC(lis net.Listener, splitPort bool) {
 
 	if splitPort {
 
-		s1 := grpc.NewServer(grpc.MaxRecvMsgSize(52428800), grpc.MaxSendMsgSize(52428800))
+		s1 := grpc.NewServer(serverOptions()...)
 
 		pb.RegisterSendServer(s1, &reserver{})
 		log.Printf("listening to split port")
@@ -180,7 +318,7 @@ func serveGRPC(lis net.Listener, splitPort bool) {
 		return
 	}
 
-	s := grpc.NewServer(grpc.MaxRecvMsgSize(52428800), grpc.MaxSendMsgSize(52428800))
+	s := grpc.NewServer(serverOptions()...)
 
 	pb.RegisterSendServer(s, &server{})
 
@@ -206,10 +344,15 @@ func StartReceiver(rid string, cons bool, mem string) {
 	con = config.Consensus()
 
 	sleepTimerValue = config.FetchSleepTimer()
+	if communication.TLSEnabled() && communication.LoadTLS(rid) != nil {
+		os.Exit(1)
+	}
 	if cons {
 		consensus.StartHandler(rid, mem)
 	}
 
+	admin.Start(config.FetchPort(rid))
+
 	if config.SplitPorts() {
 		//wg.Add(1)
 		go register(communication.GetPortNumber(config.FetchPort(rid)), true)
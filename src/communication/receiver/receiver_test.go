@@ -0,0 +1,86 @@
+package receiver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const testConf = `{
+	"consensus": 2,
+	"commOption": "TLS",
+	"replicas": [
+		{"id": "0", "host": "localhost", "port": "11000"},
+		{"id": "1", "host": "localhost", "port": "11001"},
+		{"id": "2", "host": "localhost", "port": "11002"},
+		{"id": "3", "host": "localhost", "port": "11003"}
+	]
+}`
+
+// The context of a call by the node with the certificate of common name cn.
+func peerContext(cn string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	info := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+// A message of replica source, sent by replica from as HandleTQCMsg forwards it.
+func forward(test *testing.T, keyring *cryptolib.Keyring, from int64, content message.HotStuffMessage) []byte {
+	msgbyte, err := content.Serialize()
+	if err != nil {
+		test.Fatal(err)
+	}
+	cryptolib.SetSigner(keyring.Signer(from))
+	request, err := message.SerializeWithSignature(from, msgbyte)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return request
+}
+
+func TestForwardedTQC(test *testing.T) {
+	conf := filepath.Join(test.TempDir(), "conf.json")
+	if err := os.WriteFile(conf, []byte(testConf), 0644); err != nil {
+		test.Fatal(err)
+	}
+	if !config.LoadConfigFile(conf) {
+		test.Fatal("cannot load the configuration")
+	}
+	keyring, err := cryptolib.NewKeyring(cryptolib.P256, 0, 1, 2, 3)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cryptolib.SetVerifier(keyring)
+
+	tqc := message.HotStuffMessage{Mtype: pb.MessageType_TQC, Source: 0, View: 3}
+	if !checkReplicaSource(peerContext("0"), forward(test, keyring, 0, tqc)) {
+		test.Fatal("a TQC is rejected from its source")
+	}
+	// replica 2 forwards the TQC of replica 0
+	if !checkReplicaSource(peerContext("2"), forward(test, keyring, 2, tqc)) {
+		test.Fatal("a forwarded TQC is rejected")
+	}
+	if checkReplicaSource(peerContext("7"), forward(test, keyring, 2, tqc)) {
+		test.Fatal("a TQC is accepted from a node that is not a replica")
+	}
+
+	// other messages are only accepted from their source
+	timeout := message.HotStuffMessage{Mtype: pb.MessageType_TIMEOUT, Source: 0, View: 3}
+	if checkReplicaSource(peerContext("2"), forward(test, keyring, 2, timeout)) {
+		test.Fatal("a message of replica 0 is accepted from replica 2")
+	}
+	if checkReplicaSource(context.Background(), forward(test, keyring, 0, tqc)) {
+		test.Fatal("a message is accepted without a certificate")
+	}
+}
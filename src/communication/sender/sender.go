@@ -36,15 +36,17 @@ var reply []byte
 
 var dialOpt []grpc.DialOption
 var connections communication.AddrConnMap
+var delay time.Duration // added to every message, to simulate a slow replica
 
 func BuildConnection(ctx context.Context, nid string, address string) bool {
 	p := fmt.Sprintf("building a connection with %v", nid)
 	logging.PrintLog(verbose, logging.NormalLog, p)
 
-	/*if config.CommOption() == "TLS" {
-		dialOpt = communication.GetDialOption()
-	}*/
-	conn, err := grpc.DialContext(ctx, address, dialOpt...)
+	opts := dialOpt
+	if communication.TLSEnabled() {
+		opts = communication.GetDialOption(nid)
+	}
+	conn, err := grpc.DialContext(ctx, address, opts...)
 
 	if err != nil {
 		p := fmt.Sprintf("[Communication Sender Error] failed to bulid a connection with %v", err)
@@ -59,6 +61,9 @@ func BuildConnection(ctx context.Context, nid string, address string) bool {
 }
 
 func ByteSend(msg []byte, address string, msgType message.TypeOfMessage) {
+	if delay > 0 {
+		time.Sleep(delay)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(broadcastTimer)*time.Millisecond)
 	defer cancel()
 
@@ -115,6 +120,35 @@ func ByteSend(msg []byte, address string, msgType message.TypeOfMessage) {
 			logging.PrintLog(true, logging.ErrorLog, p)
 			return
 		}
+	case message.Evidence_Msg:
+		// the proto has no RPC of its own for evidence
+		_, err = c.SimpleSendByteMsg(ctx, &pb.RawMessage{Msg: msg})
+		if err != nil {
+			p := fmt.Sprintf("[Communication Sender Error] could not get reply from node %s when send evidence: %v", nid, err)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return
+		}
+	case message.RBC_Msg:
+		_, err = c.RBCSendByteMsg(ctx, &pb.RawMessage{Msg: msg})
+		if err != nil {
+			p := fmt.Sprintf("[Communication Sender Error] could not get reply from node %s when send RBC message: %v", nid, err)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return
+		}
+	case message.CBC_Msg:
+		_, err = c.CBCSendByteMsg(ctx, &pb.RawMessage{Msg: msg})
+		if err != nil {
+			p := fmt.Sprintf("[Communication Sender Error] could not get reply from node %s when send CBC message: %v", nid, err)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return
+		}
+	case message.ECRBC_Msg:
+		_, err = c.ECRBCSendByteMsg(ctx, &pb.RawMessage{Msg: msg})
+		if err != nil {
+			p := fmt.Sprintf("[Communication Sender Error] could not get reply from node %s when send ECRBC message: %v", nid, err)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return
+		}
 	default:
 		log.Fatalf("message type %v not supported", msgType)
 	}
@@ -165,32 +199,36 @@ func JoinBroadcast(msg []byte) {
 	}
 }
 
-func MACBroadcast(msg []byte, mtype message.ProtocolType) {
-
+// EvidenceBroadcast sends evidence of equivocation to the other replicas.
+// The evidence carries the signatures of the culprit, so it is not signed again.
+func EvidenceBroadcast(msg []byte) {
 	nodes := FetchNodesFromConfig()
 
 	for i := 0; i < len(nodes); i++ {
 		nid := nodes[i]
-
-		dest, _ := utils.StringToInt64(nid)
-		request, err := message.SerializeWithMAC(id, dest, msg)
-		if err != nil {
-			logging.PrintLog(true, logging.ErrorLog, "[Sender Error] Not able to generate MAC")
+		if nid == idstring || communication.IsNotLive(nid) {
 			continue
 		}
+		go ByteSend(msg, config.FetchAddress(nid), message.Evidence_Msg)
+	}
+}
 
-		if communication.IsNotLive(nid) {
-			p := fmt.Sprintf("[Communication Sender] Replica %v is not live, don't send message to it", nid)
-			logging.PrintLog(verbose, logging.NormalLog, p)
-			continue
-		}
-		switch mtype {
-		case message.ABA:
-			go ByteSend(request, config.FetchAddress(nid), message.ABA_ALL)
-		case message.HACSS:
-			go ByteSend(request, config.FetchAddress(nid), message.HACSS_ALL)
-		}
-
+// BroadcastSend sends a message of the broadcast protocol mtype (RBC, CBC or ECRBC) to dest.
+// The protocols do not sign their messages.
+func BroadcastSend(msg []byte, dest int64, mtype message.ProtocolType) {
+	nid := utils.Int64ToString(dest)
+	if dest == id || communication.IsNotLive(nid) {
+		return
+	}
+	switch mtype {
+	case message.RBC:
+		go ByteSend(msg, config.FetchAddress(nid), message.RBC_Msg)
+	case message.CBC:
+		go ByteSend(msg, config.FetchAddress(nid), message.CBC_Msg)
+	case message.ECRBC:
+		go ByteSend(msg, config.FetchAddress(nid), message.ECRBC_Msg)
+	default:
+		log.Printf("Not supported type: %v", mtype)
 	}
 }
 
@@ -204,14 +242,6 @@ func SendToNode(msg []byte, dest int64, mtype message.ProtocolType) {
 
 	
 	switch mtype {
-	case message.HACSS:
-		request, err := message.SerializeWithMAC(id, dest, msg)
-		if err != nil {
-			logging.PrintLog(true, logging.ErrorLog, "[Sender Error] Not able to generate MAC")
-			return
-		}
-
-		go ByteSend(request, config.FetchAddress(nid), message.HACSS_ALL)
 	case message.HotStuff:
 		request, err := message.SerializeWithSignature(id, msg)
 		if err != nil {
@@ -257,6 +287,12 @@ func StartSender(rid string) {
 		//grpc.WithKeepaliveParams(kacp),
 	}
 
+	if communication.TLSEnabled() {
+		if err := communication.LoadTLS(rid); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	connections.Init()
 
 	verbose = config.FetchVerbose()
@@ -265,6 +301,11 @@ func StartSender(rid string) {
 	sleepTimerValue = config.FetchSleepTimer()
 }
 
+// Hold every message for d before sending it.
+func SetDelay(d time.Duration) {
+	delay = d
+}
+
 func SetId(newnid int64) {
 	id = newnid
 }
@@ -0,0 +1,72 @@
+/*
+Mutual TLS for the replica and client channels, used if commOption is "TLS" in conf.json.
+Every node presents the certificate created by the key tool, and the common name of the
+certificate is the node id.
+*/
+
+package communication
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const TLS = "TLS"
+
+var tlsConfig *tls.Config
+
+func TLSEnabled() bool {
+	return config.CommOption() == TLS
+}
+
+// LoadTLS loads the certificate of node id. It must be called before the dial and server options are used.
+func LoadTLS(id string) error {
+	var err error
+	tlsConfig, err = cryptolib.LoadTLSConfig(id)
+	if err != nil {
+		p := fmt.Sprintf("[Communication Error] failed to load the TLS certificate of %v: %v", id, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+	}
+	return err
+}
+
+// GetDialOption returns the dial options of a connection to node nid.
+// The server must present the certificate of nid.
+func GetDialOption(nid string) []grpc.DialOption {
+	cfg := tlsConfig.Clone()
+	cfg.ServerName = cryptolib.CertServerName(nid)
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(cfg)),
+		grpc.WithBlock(),
+	}
+}
+
+func GetServerOption() grpc.ServerOption {
+	return grpc.Creds(credentials.NewTLS(tlsConfig))
+}
+
+// PeerID returns the node id in the certificate of the peer of an RPC.
+// ok is false if the connection is not authenticated with TLS.
+func PeerID(ctx context.Context) (int64, bool) {
+	p, exist := peer.FromContext(ctx)
+	if !exist {
+		return 0, false
+	}
+	info, isTLS := p.AuthInfo.(credentials.TLSInfo)
+	if !isTLS || len(info.State.VerifiedChains) == 0 {
+		return 0, false
+	}
+	pid, err := cryptolib.CertID(info.State.VerifiedChains[0][0])
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
@@ -54,6 +54,28 @@ var numOfSleepy int
 var viewChange bool
 var gat bool
 var rotatingTime int
+var restartFromDisk bool
+var commOption string
+var keyScheme string
+var signerSocket string
+var verifyWorkers int
+var batchVerify bool
+var adminPortOffset int
+var journal bool
+var journalPayloads bool
+var journalSize int
+var journalFiles int
+var application string
+var leaseTime int
+var mempoolSize int
+var mempoolBytes int
+var clientQuota int
+var mempoolTTL int
+var blockPolicy string
+var maxBlockBytes int
+var forwardRequests bool
+var daMode bool
+var reliableRec bool
 
 // var numOfActualSleep int
 // var partChurn bool
@@ -80,34 +102,56 @@ const (
 )
 
 type System struct {
-	MaxBatchSize   int       `json:"maxBatchSize"`   // Max batch size for consensus
-	MaxTxSize      int       `json:"maxTxSize"`      // Max Tx size for consensus
-	SleepTimer     int       `json:"sleepTimer"`     // Timer for the while loops to monitor the status of requests. Should be a small value
-	ClientTimer    int       `json:"clientTimer"`    // Timer for clients to monitor the responses and see whether the requests should be re-transmitted.
-	BroadcastTimer int       `json:"broadcastTimer"` // Timer used for replicas to send gRPC messages to each other. Should be set to a value that is close to RTT
-	TParameter     int       `json:"tParameter"`     // coin set 1 when round less than TParameter
-	Verbose        bool      `json:"verbose"`        // Whether log messages should be printed.
-	EvalMode       int       `json:"evalMode"`       // Evaluation mode.
-	ThresholdMode  int       `json:"thresholdMode"`
-	EvalInterval   int       `json:"evalInterval"` // Interval for assessing throughput
-	CryptoOpt      int       `json:"cryptoOpt"`    // Crypto library option
-	LogOpt         int       `json:"logOpt"`
-	Local          bool      `json:"local"`         // Local or not
-	MaliciousNode  bool      `json:"maliciousNode"` // Simulate a simple malicious node
-	MaliciousMode  int       `json:"maliciousMode"` //
-	MaliciousNID   string    `json:"maliciousNID"`  // Malicious node id
-	SplitPorts     bool      `json:"splitPorts"`    // Split ports for request handler and server
-	Consensus      int       `json:"consensus"`     // Protocol
-	PersistLevel   int       `json:"PersistLevel"`
-	RBCType        int       `json:"RBCType"`     //RBC
-	Replicas       []Replica `json:"replicas"`    // Replica information
-	BatchSize      int       `json:"batchSize"`   // batch size in each epoch
-	GAT            bool      `json:"GAT"`         // assume GAT or not
-	NumOfMal       int       `json:"numOfMal"`    // tolerance of byzantine replicas
-	NumOfSleepy    int       `json:"numOfSleepy"` // tolerance of sleepy replicas
-	ViewChange     bool      `json:"viewChange"`
-	RotatingTime   int       `json:"rotatingTime"`
-	Test           Test      `json:"test"`
+	MaxBatchSize    int       `json:"maxBatchSize"`   // Max batch size for consensus
+	MaxTxSize       int       `json:"maxTxSize"`      // Max bytes of the transaction of a client request. 0 for no limit
+	SleepTimer      int       `json:"sleepTimer"`     // Timer for the while loops to monitor the status of requests. Should be a small value
+	ClientTimer     int       `json:"clientTimer"`    // Timer for clients to monitor the responses and see whether the requests should be re-transmitted.
+	BroadcastTimer  int       `json:"broadcastTimer"` // Timer used for replicas to send gRPC messages to each other. Should be set to a value that is close to RTT
+	TParameter      int       `json:"tParameter"`     // coin set 1 when round less than TParameter
+	Verbose         bool      `json:"verbose"`        // Whether log messages should be printed.
+	EvalMode        int       `json:"evalMode"`       // Evaluation mode.
+	ThresholdMode   int       `json:"thresholdMode"`
+	EvalInterval    int       `json:"evalInterval"` // Interval for assessing throughput
+	CryptoOpt       int       `json:"cryptoOpt"`    // Crypto library option
+	LogOpt          int       `json:"logOpt"`
+	Local           bool      `json:"local"`         // Local or not
+	MaliciousNode   bool      `json:"maliciousNode"` // Simulate a simple malicious node
+	MaliciousMode   int       `json:"maliciousMode"` // Sum of the behaviors of the malicious nodes, see consensus/byzantine.go
+	MaliciousNID    string    `json:"maliciousNID"`  // Malicious node id
+	SplitPorts      bool      `json:"splitPorts"`    // Split ports for request handler and server
+	Consensus       int       `json:"consensus"`     // Protocol
+	PersistLevel    int       `json:"PersistLevel"`
+	RBCType         int       `json:"RBCType"`     //RBC
+	Replicas        []Replica `json:"replicas"`    // Replica information
+	BatchSize       int       `json:"batchSize"`   // batch size in each epoch
+	GAT             bool      `json:"GAT"`         // assume GAT or not
+	NumOfMal        int       `json:"numOfMal"`    // tolerance of byzantine replicas
+	NumOfSleepy     int       `json:"numOfSleepy"` // tolerance of sleepy replicas
+	ViewChange      bool      `json:"viewChange"`
+	RotatingTime    int       `json:"rotatingTime"`
+	RestartFromDisk bool      `json:"restartFromDisk"` // Rebuild the replica from its database on start (PersistAll only)
+	CommOption      string    `json:"commOption"`      // "TLS" for mutual TLS with the certificates in etc/key, otherwise plaintext
+	KeyScheme       string    `json:"keyScheme"`       // Scheme of the keys generated by the key tool: P256 (default), P224 or Ed25519
+	SignerSocket    string    `json:"signerSocket"`    // Unix socket of a signing daemon. If empty, replicas sign with the keys in etc/key
+	VerifyWorkers   int       `json:"verifyWorkers"`   // Goroutines verifying signatures in parallel. 0 for the number of CPUs
+	BatchVerify     bool      `json:"batchVerify"`     // Verify Ed25519 signatures as a batch
+	AdminPortOffset int       `json:"adminPortOffset"` // Serve the admin API on 127.0.0.1 at the port of the replica plus this offset. 0 to disable
+	Journal         bool      `json:"journal"`         // Record the messages of the replica in var/log/[id]/journal
+	JournalPayloads bool      `json:"journalPayloads"` // Record the full messages, which the replay tool needs, not only their hashes
+	JournalSize     int       `json:"journalSize"`     // Size in MB of a journal file before it rotates. 0 for 64
+	JournalFiles    int       `json:"journalFiles"`    // Journal files kept. 0 to keep all
+	Application     string    `json:"application"`     // Application executing the committed blocks, see app/app.go. Empty for none
+	LeaseTime       int       `json:"leaseTime"`       // Lease of the leader for reads, in ms from a proposal that got a QC. Below rotatingTime; 0 disables lease reads
+	MempoolSize     int       `json:"mempoolSize"`     // Client requests kept in the mempool. 0 for 10000
+	MempoolBytes    int       `json:"mempoolBytes"`    // Size in MB of the requests in the mempool. 0 for 64
+	ClientQuota     int       `json:"clientQuota"`     // Requests of a single client in the mempool. 0 for no quota
+	MempoolTTL      int       `json:"mempoolTTL"`      // Seconds a request waits in the mempool before it expires. 0 for no expiry
+	BlockPolicy     string    `json:"blockPolicy"`     // Choice of the requests of a block: fifo (default), priority or fair
+	MaxBlockBytes   int       `json:"maxBlockBytes"`   // Max bytes of the requests of a block. 0 for no limit
+	ForwardRequests bool      `json:"forwardRequests"` // Forward the requests of clients to the current and next leaders
+	DAMode          bool      `json:"daMode"`          // Data-availability mode: replicas broadcast batches of requests, proposals carry their certificates
+	ReliableRec     bool      `json:"reliableRec"`     // Broadcast the recovery requests REC1 and REC2 with the reliable broadcast of Bracha
+	Test            Test      `json:"test"`
 }
 
 type Replica struct {
@@ -126,6 +170,45 @@ type TestParam struct {
 	NumOfActualSleep int             `json:"NumOfActualSleep"`
 	SleepTime        int             `json:"sleepTime"`
 	SleepSeq         int             `json:"sleepSeq"`
+	Schedule         Schedule        `json:"schedule"`
+}
+
+// A timeline of sleep and wake-up for churn experiments, run by every replica on its own.
+type Schedule struct {
+	Intervals []SleepInterval `json:"intervals"` // Planned intervals, several per replica if needed
+	Churn     Churn           `json:"churn"`     // Random intervals added to the planned ones
+	MaxAsleep int             `json:"maxAsleep"` // Most replicas asleep or recovering at once, 0 for numOfSleepy and -1 for no bound
+	Recovery  int             `json:"recovery"`  // Milliseconds a replica may take to recover, counted as asleep by maxAsleep
+}
+
+type TriggerType string
+
+const (
+	TriggerTime   TriggerType = "time"   // milliseconds after the replica starts
+	TriggerView   TriggerType = "view"   // the replica enters the view
+	TriggerHeight TriggerType = "height" // the replica reaches the height
+)
+
+type SleepInterval struct {
+	Id       string      `json:"id"`       // Replica falling asleep
+	Trigger  TriggerType `json:"trigger"`  // What At refers to
+	At       int         `json:"at"`       // When the replica falls asleep
+	Duration int         `json:"duration"` // Sleep time in milliseconds
+	RecMode  RecModeType `json:"recMode"`
+}
+
+// Random churn: every replica in Replicas (all of them if empty) falls asleep again and again,
+// after an exponentially distributed time of mean Interval milliseconds, for a time drawn
+// uniformly between MinSleep and MaxSleep milliseconds, until Until milliseconds after the start.
+// Replicas with the same Seed draw the same timeline.
+type Churn struct {
+	Seed     int64       `json:"seed"`
+	Replicas []string    `json:"replicas"`
+	Interval int         `json:"interval"` // 0 to disable churn
+	MinSleep int         `json:"minSleep"`
+	MaxSleep int         `json:"maxSleep"`
+	Until    int         `json:"until"`
+	RecMode  RecModeType `json:"recMode"`
 }
 
 type SleepyReplica struct {
@@ -136,12 +219,6 @@ type SleepyReplica struct {
 }
 
 func LoadConfig() bool {
-
-	nodes = make(map[string]string)
-	nodesReverse = make(map[string]string)
-	portMap = make(map[string]string)
-	nodeIDs = make([]string, 0)
-
 	exepath, err := os.Executable()
 	if err != nil {
 		p := fmt.Sprintf("[Configuration Error]  Failed to get path for the executable")
@@ -157,7 +234,17 @@ func LoadConfig() bool {
 	fmt.Println("homepath %s", homepath)
 
 	defaultFileName := homepath + "/etc/conf.json"
-	f, err := os.Open(defaultFileName)
+	return LoadConfigFile(defaultFileName)
+}
+
+// Load the configuration from the file name instead of etc/conf.json.
+func LoadConfigFile(name string) bool {
+	nodes = make(map[string]string)
+	nodesReverse = make(map[string]string)
+	portMap = make(map[string]string)
+	nodeIDs = make([]string, 0)
+
+	f, err := os.Open(name)
 	if err != nil {
 		p := fmt.Sprintf("[Configuration Error]  Failed to open config file: %v", err)
 		logging.PrintLog(true, logging.ErrorLog, p)
@@ -216,6 +303,28 @@ func LoadConfig() bool {
 	viewChange = system.ViewChange
 	gat = system.GAT
 	rotatingTime = system.RotatingTime
+	restartFromDisk = system.RestartFromDisk
+	commOption = system.CommOption
+	keyScheme = system.KeyScheme
+	signerSocket = system.SignerSocket
+	verifyWorkers = system.VerifyWorkers
+	batchVerify = system.BatchVerify
+	adminPortOffset = system.AdminPortOffset
+	journal = system.Journal
+	journalPayloads = system.JournalPayloads
+	journalSize = system.JournalSize
+	journalFiles = system.JournalFiles
+	application = system.Application
+	leaseTime = system.LeaseTime
+	mempoolSize = system.MempoolSize
+	mempoolBytes = system.MempoolBytes
+	clientQuota = system.ClientQuota
+	mempoolTTL = system.MempoolTTL
+	blockPolicy = system.BlockPolicy
+	maxBlockBytes = system.MaxBlockBytes
+	forwardRequests = system.ForwardRequests
+	daMode = system.DAMode
+	reliableRec = system.ReliableRec
 	// numOfActualSleep = system.NumOfActualSleep
 	// partChurn = system.PartChurn
 	// sleepTime = system.SleepTime
@@ -363,6 +472,70 @@ func GAT() bool {
 
 func FetchRotatingTime() int { return rotatingTime }
 
+func RestartFromDisk() bool { return restartFromDisk }
+
+func CommOption() string { return commOption }
+
+func KeyScheme() string { return keyScheme }
+
+func SignerSocket() string { return signerSocket }
+
+func VerifyWorkers() int { return verifyWorkers }
+
+func BatchVerify() bool { return batchVerify }
+
+func AdminPortOffset() int { return adminPortOffset }
+
+func Journal() bool { return journal }
+
+func JournalPayloads() bool { return journalPayloads }
+
+func JournalSize() int {
+	if journalSize <= 0 {
+		return 64
+	}
+	return journalSize
+}
+
+func JournalFiles() int { return journalFiles }
+
+func Application() string { return application }
+
+func LeaseTime() int { return leaseTime }
+
+func MempoolSize() int {
+	if mempoolSize <= 0 {
+		return 10000
+	}
+	return mempoolSize
+}
+
+func MempoolBytes() int {
+	if mempoolBytes <= 0 {
+		return 64
+	}
+	return mempoolBytes
+}
+
+func ClientQuota() int { return clientQuota }
+
+func MempoolTTL() int { return mempoolTTL }
+
+func BlockPolicy() string {
+	if blockPolicy == "" {
+		return "fifo"
+	}
+	return blockPolicy
+}
+
+func MaxBlockBytes() int { return maxBlockBytes }
+
+func ForwardRequests() bool { return forwardRequests }
+
+func DAMode() bool { return daMode }
+
+func ReliableRecovery() bool { return reliableRec }
+
 func FetchTestTypeAndParam() (TestType, TestParam) {
 	return test.TestId, test.Param
 }
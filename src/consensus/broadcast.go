@@ -0,0 +1,65 @@
+package consensus
+
+import (
+	"fmt"
+	bcast "sleepy-hotstuff/src/broadcast"
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sleepy-hotstuff/src/quorum"
+)
+
+/*
+Messages of the core sent with the primitives of src/broadcast. The value of a broadcast is the
+message signed by its sender, and it is handled as received from the sender once delivered:
+  - with RBCType ECRBC, large messages such as proposals are erasure-coded, see ecrbc.go;
+  - with reliableRec, the recovery requests REC1 and REC2 use the reliable broadcast of Bracha,
+    so that the correct replicas answer the same requests.
+*/
+
+func startBroadcast() {
+	bcast.Start(id, quorum.FSize(), func() bool { return curStatus.Get() != SLEEPING })
+	bcast.OnDeliver(message.RBC, deliverBroadcast)
+	bcast.OnDeliver(message.ECRBC, deliverBroadcast)
+}
+
+// Sign the message and broadcast it with the protocol kind.
+func reliableBroadcast(msg []byte, kind message.ProtocolType) {
+	request, err := message.SerializeWithSignature(id, msg)
+	if err != nil {
+		logging.PrintLog(true, logging.ErrorLog, "[Consensus Error] Not able to sign the message")
+		return
+	}
+	if _, err := bcast.Broadcast(kind, request); err != nil {
+		logging.PrintLog(true, logging.ErrorLog, err.Error())
+		sender.RBCByteBroadcast(msg)
+	}
+}
+
+// The recovery requests are reliably broadcast with reliableRec.
+func recBroadcast(msg []byte) {
+	if config.ReliableRecovery() {
+		emit(Action{Type: ReliableAction, Msg: msg})
+		return
+	}
+	broadcast(msg)
+}
+
+// Handle the message delivered by a broadcast of another replica; the sender handles its own
+// messages when it sends them.
+func deliverBroadcast(bid bcast.ID, value []byte) {
+	if bid.Sender == id {
+		return
+	}
+	signed := message.DeserializeMessageWithSignature(value)
+	if message.DeserializeHotStuffMessage(signed.Msg).Source != bid.Sender {
+		p := fmt.Sprintf("[Consensus Error] broadcast %v carries a message of another replica", bid)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	HandleQCByteMsg(value)
+	MsgQueue.AppendAndTrimToMaxSize(value)
+	db.PersistValue("MsgQueue", &MsgQueue, db.PersistAll)
+}
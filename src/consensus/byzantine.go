@@ -0,0 +1,159 @@
+/*
+Fault injection for experiments.
+With maliciousNode set in conf.json, the replicas listed in maliciousNID misbehave as selected
+by maliciousMode, a sum of the behaviors below (e.g., 3 for equivocating proposals and votes).
+The behaviors reproduce the attacks of the experiments, such as a double spend by an
+equivocating leader or a recovering replica misled by forged ECHO replies.
+*/
+
+package consensus
+
+import (
+	"bytes"
+	"log"
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/utils"
+	"sort"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Behavior int
+
+const (
+	// As leader, send one block to half of the replicas and a conflicting one, with the same
+	// transactions in reverse order, to the other half.
+	EquivocateProposals Behavior = 1 << iota
+	// Vote for every proposal and also for a conflicting block at the same height.
+	ConflictingVotes
+	// Never vote.
+	WithholdVotes
+	// Send the TQC of an older view instead of the current one.
+	StaleTQCs
+	// Answer recovering replicas with the TQC of an older view in ECHO1 and with forged
+	// committedBlocks in ECHO2.
+	ForgeRecovery
+	// Hold every outgoing message for half of the view timer.
+	DelayMessages
+)
+
+var behaviorNames = map[Behavior]string{
+	EquivocateProposals: "equivocate proposals",
+	ConflictingVotes:    "conflicting votes",
+	WithholdVotes:       "withhold votes",
+	StaleTQCs:           "stale TQCs",
+	ForgeRecovery:       "forge recovery replies",
+	DelayMessages:       "delay messages",
+}
+
+// Reports whether this replica is configured to misbehave with b.
+func byzantine(b Behavior) bool {
+	return config.MaliciousNode() && config.MaliciousNID(id) && Behavior(config.MaliciousMode())&b != 0
+}
+
+// Set up the behaviors of this replica that are not triggered by a message.
+func startByzantine() {
+	for b := EquivocateProposals; b <= DelayMessages; b <<= 1 {
+		if byzantine(b) {
+			log.Printf("[Byzantine] replica %v will %s", id, behaviorNames[b])
+		}
+	}
+	if byzantine(DelayMessages) {
+		sender.SetDelay(time.Duration(config.FetchRotatingTime()) * time.Second / 2)
+	}
+}
+
+// Send proposal to the first half of the replicas and a conflicting block to the others.
+// prevHash is the hash of the parent of the proposal.
+func equivocate(proposal message.HotStuffMessage, prevHash []byte) {
+	conflicting := proposal
+	conflicting.OPS = make([]pb.RawMessage, len(proposal.OPS))
+	for i := 0; i < len(proposal.OPS); i++ {
+		conflicting.OPS[i].Msg = proposal.OPS[len(proposal.OPS)-1-i].GetMsg()
+	}
+	batchHash := conflicting.GetMsgHash()
+	if bytes.Equal(batchHash, proposal.GetMsgHash()) {
+		// an empty or single transaction batch
+		batchHash = cryptolib.GenHash(append(batchHash, []byte("equivocation")...))
+	}
+	conflicting.Hash = GenHashOfTwoVal(prevHash, GenHashOfTwoVal(utils.IntToBytes(proposal.Seq), batchHash))
+
+	first, _ := proposal.Serialize()
+	second, _ := conflicting.Serialize()
+	nodes := sortedReplicas()
+	log.Printf("[Byzantine] proposing two blocks at height %d", proposal.Seq)
+	for i := 0; i < len(nodes); i++ {
+		if i < len(nodes)/2 {
+			send(first, nodes[i])
+		} else {
+			send(second, nodes[i])
+		}
+	}
+}
+
+func sortedReplicas() []int64 {
+	var nodes []int64
+	for _, nid := range config.FetchNodes() {
+		v, err := utils.StringToInt64(nid)
+		if err == nil && v != id {
+			nodes = append(nodes, v)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
+
+// Send the leader a second vote at the height of vote, for a block that does not exist.
+// The signature does not go through the signer, which would refuse it.
+func voteConflicting(vote message.HotStuffMessage, leader int64) {
+	vote.Hash = GenHashOfTwoVal(vote.Hash, []byte("conflict"))
+	log.Printf("[Byzantine] voting for a conflicting block at height %d", vote.Seq)
+	emit(Action{Type: VoteAction, To: leader, Vote: vote, Forged: true})
+}
+
+// Return the highest view below v with a complete TQC, and the TQC, or v and tqc if there is none.
+func staleTQC(v int, tqc []message.MessageWithSignature) (int, []message.MessageWithSignature) {
+	for old := v - 1; old >= 0; old-- {
+		if timeoutBuffer.GetLen(old) >= quorum.QuorumSize() {
+			log.Printf("[Byzantine] sending the TQC of view %d instead of view %d", old, v)
+			return old, timeoutBuffer.GetV(old)
+		}
+	}
+	return v, tqc
+}
+
+// Committed blocks with other hashes and a coinbase to this replica, plus one more block
+// above the highest one.
+func forgeCommittedBlocks() []byte {
+	all := committedBlocks.GetAll()
+	forged := make(map[int][]byte)
+	highest := 0
+	for height, blockser := range all {
+		if height > highest {
+			highest = height
+		}
+		forged[height] = forgeBlock(message.DeserializeQCBlock(blockser), height)
+	}
+	forged[highest+1] = forgeBlock(message.QCBlock{}, highest+1)
+	log.Printf("[Byzantine] sending %d forged committed blocks", len(all)+1)
+	ser, _ := msgpack.Marshal(forged)
+	return ser
+}
+
+func forgeBlock(block message.QCBlock, height int) []byte {
+	block.Height = height
+	block.Hash = cryptolib.GenHash(append(utils.IntToBytes(height), []byte("forged")...))
+	tx := message.Transaction{To: utils.Int64ToString(id), Value: 1000000}
+	txser, _ := tx.Serialize()
+	cr := message.ClientRequest{ID: id, OP: txser, TS: timestamp()}
+	crser, _ := cr.Serialize()
+	block.TXS = []message.MessageWithSignature{{Msg: crser}}
+	ser, _ := block.Serialize()
+	return ser
+}
@@ -0,0 +1,136 @@
+package consensus
+
+import (
+	"bytes"
+	"math"
+	"sleepy-hotstuff/src/communication"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/evidence"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/utils"
+	"testing"
+)
+
+// Make replica 0 misbehave as selected by mode.
+func misbehave(test *testing.T, mode Behavior) {
+	loadConf(test, `"maliciousNode": true, "maliciousNID": "0", "maliciousMode": `+utils.IntToString(int(mode)))
+}
+
+func TestEquivocateProposals(test *testing.T) {
+	startCore(test, 0)
+	misbehave(test, EquivocateProposals)
+
+	sent := make(map[int64][]byte)
+	for _, a := range Step(Event{Type: RequestEvent, Requests: [][]byte{[]byte("tx")}}) {
+		switch a.Type {
+		case SendAction:
+			sent[a.To] = a.Msg
+		case BroadcastAction:
+			test.Fatal("an equivocating leader broadcasts its proposal")
+		}
+	}
+	if len(sent) != 3 {
+		test.Fatalf("proposal sent to %d replicas", len(sent))
+	}
+	first := message.DeserializeHotStuffMessage(sent[1])
+	second := message.DeserializeHotStuffMessage(sent[2])
+	if first.Seq != second.Seq || first.View != second.View || bytes.Equal(first.Hash, second.Hash) {
+		test.Fatalf("the two blocks do not conflict: %+v %+v", first, second)
+	}
+	if !bytes.Equal(sent[2], sent[3]) {
+		test.Fatal("the second half of the replicas receives different blocks")
+	}
+}
+
+func TestConflictingVotes(test *testing.T) {
+	keyring := startCore(test, 0)
+	misbehave(test, ConflictingVotes)
+	communication.StartConnectionManager() // the evidence is gossiped
+	evidence.Prune(math.MaxInt32)          // and the votes of another run are forgotten
+	leader := proposal()
+	leader.Source = 1
+
+	// the two votes, as sent by the runner of the core to the leader
+	var sent []message.MessageWithSignature
+	for _, a := range Step(Event{Type: MessageEvent, Msg: leader}) {
+		if a.Type != VoteAction || a.To != 1 {
+			continue
+		}
+		vote := a.Vote
+		sig, err := keyring.Signer(0).Sign(cryptolib.VoteMsg(vote.View, vote.Seq, vote.Hash))
+		if err != nil {
+			test.Fatal(err)
+		}
+		vote.Sig = sig
+		sent = append(sent, signAs(test, keyring, 0, vote))
+	}
+	if len(sent) != 2 {
+		test.Fatalf("%d votes", len(sent))
+	}
+
+	if evidence.Check(message.DeserializeHotStuffMessage(sent[0].Msg), sent[0]) != nil {
+		test.Fatal("evidence from a single vote")
+	}
+	ev := evidence.Check(message.DeserializeHotStuffMessage(sent[1].Msg), sent[1])
+	if ev == nil || ev.Kind != evidence.DoubleVote || ev.Culprit != 0 || ev.Height != 1 || ev.Verify() != nil {
+		test.Fatalf("conflicting vote not detected: %+v", ev)
+	}
+}
+
+// A committed block with the QC of replicas 0, 1 and 2.
+func certified(test *testing.T, keyring *cryptolib.Keyring, height int, hash string) []byte {
+	block := message.QCBlock{Height: height, Hash: cryptolib.GenHash([]byte(hash))}
+	for _, signer := range []int64{0, 1, 2} {
+		sig, err := keyring.Signer(signer).Sign(cryptolib.VoteMsg(block.View, block.Height, block.Hash))
+		if err != nil {
+			test.Fatal(err)
+		}
+		block.QC = append(block.QC, sig)
+		block.IDs = append(block.IDs, signer)
+	}
+	ser, _ := block.Serialize()
+	return ser
+}
+
+func TestForgeRecovery(test *testing.T) {
+	keyring := startCore(test, 0)
+	misbehave(test, ForgeRecovery)
+	committedBlocks.Init()
+	test.Cleanup(committedBlocks.Init)
+	committedBlocks.Insert(1, certified(test, keyring, 1, "block 1"))
+	committedBlocks.Insert(2, certified(test, keyring, 2, "block 2"))
+	genuine, _ := committedBlocks.Serialize()
+
+	// replica 2 recovers, and replica 0 answers with forged committed blocks
+	rec2 := message.HotStuffMessage{Mtype: pb.MessageType_REC2, Source: 2}
+	var forged message.HotStuffMessage
+	for _, a := range Step(Event{Type: MessageEvent, Msg: rec2}) {
+		if a.Type == SendAction && a.To == 2 {
+			forged = message.DeserializeHotStuffMessage(a.Msg)
+		}
+	}
+	if forged.Mtype != pb.MessageType_ECHO2 || bytes.Equal(forged.ComBlocks, genuine) {
+		test.Fatalf("reply: %+v", forged)
+	}
+
+	id, iid = 2, 2
+	cryptolib.SetSigner(keyring.Signer(2))
+	loadConf(test, `"maliciousNode": false`)
+	committedBlocks.Init()
+	committedBlocks.Insert(1, certified(test, keyring, 1, "block 1"))
+	curStatus.Set(RECOVERING)
+	reqHash.Set(forged.Hash)
+
+	Step(Event{Type: MessageEvent, Msg: forged})
+	if _, exist := committedBlocks.Get(2); exist || committedBlocks.GetLen() != 1 {
+		test.Fatalf("forged committed blocks accepted: %v", committedBlocks.GetAll())
+	}
+	honest := forged
+	honest.Source = 1
+	honest.ComBlocks = genuine
+	Step(Event{Type: MessageEvent, Msg: honest})
+	if block, exist := committedBlocks.Get(2); !exist || !bytes.Equal(message.DeserializeQCBlock(block).Hash, cryptolib.GenHash([]byte("block 2"))) {
+		test.Fatal("certified committed block of an honest replica refused")
+	}
+}
@@ -0,0 +1,317 @@
+/*
+The consensus core.
+The protocol is a state machine driven by a single goroutine: Step takes an event (a message,
+a verified vote, a client request, a timer, the replica falling asleep or waking up) and
+returns the actions the replica has to take. The loop then carries them out: it signs and
+sends messages, signs votes, writes to the database and starts timers. Since only Step changes
+the state of consensus, the handlers need no locks. Step reads no clock, the time is that of
+the event, and it writes nothing to the database itself, so the same events given to Step in
+the same order give the same actions.
+Around the core, the receiver checks incoming messages for equivocation and the vote verifier
+checks the signatures of votes before they become events. Certificates carried by messages
+are verified by Step itself, which only depends on the keys.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"log"
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	MessageEvent   EventType = iota // a HotStuff message, Signed as received
+	VoteEvent                       // a vote whose signature has been verified
+	RequestEvent                    // client Requests were received
+	TimeoutEvent                    // the rotating timer of View expired
+	RecoveredEvent                  // enough ECHO2 messages for the recovery request Msg.Hash were collected
+	SleepEvent                      // the replica falls asleep
+	WakeEvent                       // the replica wakes up and recovers with RecMode
+	EvidenceEvent                   // evidence of an equivocation was found, to be stored
+	AuditEvent                      // the received forks are audited, at the request of the admin API
+)
+
+type Event struct {
+	Type     EventType
+	Msg      message.HotStuffMessage
+	Signed   message.MessageWithSignature
+	Requests [][]byte
+	View     int
+	RecMode  config.RecModeType
+	Now      time.Time // when the event was submitted; the core reads no clock
+}
+
+type ActionType int
+
+const (
+	SendAction      ActionType = iota // sign Msg and send it to To
+	BroadcastAction                   // sign Msg and send it to the other replicas
+	DeliverAction                     // sign Msg and handle it as a message received from this replica
+	VoteAction                        // sign the hash of Vote and send the vote to To
+	PersistAction                     // write Value under Key, if the persist level is at least Level
+	TimerAction                       // give Event to Step after Delay
+	ReliableAction                    // sign Msg and broadcast it with the reliable broadcast of Bracha
+)
+
+type Action struct {
+	Type   ActionType
+	To     int64
+	Msg    []byte
+	Vote   message.HotStuffMessage
+	Forged bool // sign Vote without the checks of the signer
+	Key    string
+	Value  db.DBValue
+	Level  db.PersistLevelType
+	Delay  time.Duration
+	Event  Event
+}
+
+var actions []Action // taken by the current step
+
+// the time of the current event
+var eventTime time.Time
+
+// The time of the current event in milliseconds, for the TS fields of messages.
+func timestamp() int64 {
+	return eventTime.UnixNano() / int64(time.Millisecond)
+}
+
+// the rotating timer of view 0 starts with the first client request.
+var timerPending bool
+
+// Step handles an event and returns the actions it leads to.
+func Step(ev Event) []Action {
+	actions = nil
+	eventTime = ev.Now
+	switch ev.Type {
+	case MessageEvent:
+		handleMessage(ev.Msg, ev.Signed)
+	case VoteEvent:
+		addVote(ev.Msg)
+	case RequestEvent:
+		addRequests(ev.Requests)
+	case TimeoutEvent:
+		TimeoutHandler(ev.View)
+	case RecoveredEvent:
+		recovered(ev.Msg.Hash)
+	case SleepEvent:
+		curStatus.Set(SLEEPING)
+	case WakeEvent:
+		// everything in memory is lost, while the database is kept for RecFromDisk.
+		resetHotStuffState(id)
+		err := RecoveryProcess(ev.RecMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the leaders may have changed during the sleep.
+		pool.Reforward()
+	case EvidenceEvent:
+		// the evidence is stored whatever the persist level.
+		persist(evidence.DBKey, evidence.Stored(), db.NoPersist)
+	case AuditEvent:
+		auditForks()
+	}
+	runDeferred()
+	executeCommitted()
+	evictCommitted()
+	expireRequests()
+	if timerPending && (pool.Len() > 0 || LocalView() != 0) {
+		// we view the time the first request is received as the beginning of the system.
+		timerPending = false
+		if config.IsViewChangeMode() {
+			StartRotatingTimer(LocalView())
+		}
+	}
+	propose()
+	forwardRequests()
+	disseminateBatches()
+	result := actions
+	actions = nil
+	return result
+}
+
+func emit(a Action) {
+	actions = append(actions, a)
+}
+
+func send(msg []byte, dest int64) {
+	emit(Action{Type: SendAction, To: dest, Msg: msg})
+}
+
+func broadcast(msg []byte) {
+	emit(Action{Type: BroadcastAction, Msg: msg})
+}
+
+// The message is first received by the node itself.
+func deliver(msg []byte) {
+	emit(Action{Type: DeliverAction, Msg: msg})
+}
+
+func persist(key string, value db.DBValue, level db.PersistLevelType) {
+	emit(Action{Type: PersistAction, Key: key, Value: value, Level: level})
+}
+
+func startTimer(d time.Duration, ev Event) {
+	emit(Action{Type: TimerAction, Delay: d, Event: ev})
+}
+
+// Steps waiting for the replica to reach a view.
+type deferredStep struct {
+	view int
+	run  func()
+}
+
+var deferred []deferredStep
+
+// Run f once the local view is at least v.
+func afterView(v int, f func()) {
+	if LocalView() >= v {
+		f()
+		return
+	}
+	deferred = append(deferred, deferredStep{view: v, run: f})
+}
+
+func runDeferred() {
+	for {
+		var ready []deferredStep
+		var waiting []deferredStep
+		for _, d := range deferred {
+			if LocalView() >= d.view {
+				ready = append(ready, d)
+			} else {
+				waiting = append(waiting, d)
+			}
+		}
+		deferred = waiting
+		if len(ready) == 0 {
+			return
+		}
+		for _, d := range ready {
+			d.run()
+		}
+	}
+}
+
+// Propose a block if this replica leads the view and the previous block has a QC.
+func propose() {
+	if consensus != HotStuff || curStatus.Get() != READY || LeaderID(LocalView()) != iid {
+		return
+	}
+	// awaitingDecisionCopy.GenLen seems to be always > 0,
+	// except the initial period of a leader.
+	if awaitingDecisionCopy.GetLen() == 0 && !hasPending() {
+		return
+	}
+	curStatus.Set(PROCESSING)
+	StartHotStuff()
+}
+
+// Events waiting for the loop. submit never blocks, since the loop submits events itself.
+var pendingEvents []Event
+var eventLock sync.Mutex
+var eventsReady = make(chan struct{}, 1)
+
+// The events are stamped with the time in milliseconds, as the journal records it.
+func submit(ev Event) {
+	if ev.Now.IsZero() {
+		ev.Now = time.UnixMilli(time.Now().UnixMilli())
+	}
+	eventLock.Lock()
+	pendingEvents = append(pendingEvents, ev)
+	eventLock.Unlock()
+	select {
+	case eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+func takeEvents() []Event {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	evs := pendingEvents
+	pendingEvents = nil
+	return evs
+}
+
+// The loop of the core, started by StartHandler.
+func run() {
+	for range eventsReady {
+		for _, ev := range takeEvents() {
+			journalEvent(ev)
+			execute(Step(ev))
+		}
+	}
+}
+
+// Carry out the actions of a step, or of the initialization before the loop starts.
+func execute(list []Action) {
+	journalActions(list)
+	for _, a := range list {
+		switch a.Type {
+		case SendAction:
+			sender.SendToNode(a.Msg, a.To, message.HotStuff)
+		case BroadcastAction:
+			broadcastMsg(a.Msg)
+		case ReliableAction:
+			reliableBroadcast(a.Msg, message.RBC)
+		case DeliverAction:
+			request, err := message.SerializeWithSignature(id, a.Msg)
+			if err != nil {
+				logging.PrintLog(true, logging.ErrorLog, "[Consensus Error] Not able to sign the message")
+				continue
+			}
+			HandleQCByteMsg(request)
+		case VoteAction:
+			sendVote(a)
+		case PersistAction:
+			db.PersistValue(a.Key, a.Value, a.Level)
+		case TimerAction:
+			ev := a.Event
+			time.AfterFunc(a.Delay, func() { submit(ev) })
+		}
+	}
+}
+
+func sendVote(a Action) {
+	msg := a.Vote
+	if a.Forged {
+		msg.Sig = cryptolib.GenSig(cryptolib.VoteMsg(msg.View, msg.Seq, msg.Hash))
+	} else {
+		sig, err := cryptolib.SignVote(msg.View, msg.Seq, msg.Hash)
+		if err != nil {
+			p := fmt.Sprintf("[QCMessage Error] the signer refused the vote for block %d: %v", msg.Seq, err)
+			logging.PrintLog(true, logging.ErrorLog, p)
+			return
+		}
+		msg.Sig = sig
+	}
+	// a signature for the voted block at its view and height, not for the entire message
+	if !a.Forged && !cryptolib.VerifySig(id, cryptolib.VoteMsg(msg.View, msg.Seq, msg.Hash), msg.Sig) {
+		p := fmt.Sprintf("%d can not verify its newly generated sig!", id)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	if a.To == id {
+		// the vote is first received by the leader itself.
+		submit(Event{Type: VoteEvent, Msg: msg})
+		return
+	}
+	msgbyte, err := msg.Serialize()
+	if err != nil {
+		logging.PrintLog(true, logging.ErrorLog, "[QCMessage Error] Not able to serialize the message")
+		return
+	}
+	sender.SendToNode(msgbyte, a.To, message.HotStuff)
+}
@@ -0,0 +1,667 @@
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sleepy-hotstuff/src/app"
+	bcast "sleepy-hotstuff/src/broadcast"
+	"sleepy-hotstuff/src/communication"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/journal"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/safety"
+	"sleepy-hotstuff/src/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConf = `{
+	"consensus": 2,
+	"PersistLevel": 1,
+	"maxBatchSize": 1,
+	"replicas": [
+		{"id": "0", "host": "localhost", "port": "11000"},
+		{"id": "1", "host": "localhost", "port": "11001"},
+		{"id": "2", "host": "localhost", "port": "11002"},
+		{"id": "3", "host": "localhost", "port": "11003"}
+	]
+}`
+
+// Set up the core of replica self among 4 replicas, without network.
+func startCore(test *testing.T, self int64) *cryptolib.Keyring {
+	dir := test.TempDir()
+	conf := filepath.Join(dir, "conf.json")
+	if err := os.WriteFile(conf, []byte(testConf), 0644); err != nil {
+		test.Fatal(err)
+	}
+	if !config.LoadConfigFile(conf) {
+		test.Fatal("cannot load the configuration")
+	}
+	if err := db.OpenDB(filepath.Join(dir, "db")); err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(db.CloseDB)
+
+	id = self
+	iid, _ = utils.Int64ToInt(self)
+	n = config.FetchNumReplicas()
+	consensus = HotStuff
+	curStatus.Init()
+	initMempool()
+	initDA()
+	resetHotStuffState(id)
+	timerPending = false
+
+	keyring, err := cryptolib.NewKeyring(cryptolib.P256, 0, 1, 2, 3)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cryptolib.SetVerifier(keyring)
+	cryptolib.SetSigner(keyring.Signer(self))
+	return keyring
+}
+
+func proposal() message.HotStuffMessage {
+	return message.HotStuffMessage{
+		Mtype:  pb.MessageType_QC,
+		Source: 0,
+		View:   0,
+		Seq:    1,
+		OPS:    []pb.RawMessage{{Msg: []byte("tx")}},
+		Hash:   cryptolib.GenHash([]byte("block 1")),
+	}
+}
+
+func TestStepVote(test *testing.T) {
+	startCore(test, 1)
+	events := []Event{{Type: MessageEvent, Msg: proposal()}}
+
+	var first []Action
+	for _, ev := range events {
+		first = append(first, Step(ev)...)
+	}
+	var votes []Action
+	for _, a := range first {
+		switch a.Type {
+		case VoteAction:
+			votes = append(votes, a)
+		case SendAction, BroadcastAction, DeliverAction:
+			test.Fatalf("a replica sends a message for a proposal: %+v", a)
+		}
+	}
+	if len(votes) != 1 || votes[0].To != 0 || votes[0].Vote.Seq != 1 ||
+		!reflect.DeepEqual(votes[0].Vote.Hash, proposal().Hash) || votes[0].Vote.Source != 1 {
+		test.Fatalf("vote for the proposal: %+v", votes)
+	}
+
+	// the same events from the same state give the same actions
+	resetHotStuffState(id)
+	var second []Action
+	for _, ev := range events {
+		second = append(second, Step(ev)...)
+	}
+	if !reflect.DeepEqual(first, second) {
+		test.Fatalf("replay gives other actions:\n%+v\n%+v", first, second)
+	}
+}
+
+// Write what the actions persist, as the loop does.
+func persistActions(acts []Action) {
+	for _, a := range acts {
+		if a.Type == PersistAction {
+			db.PersistValue(a.Key, a.Value, a.Level)
+		}
+	}
+}
+
+func TestVoteAfterRestart(test *testing.T) {
+	startCore(test, 1)
+	loadConf(test, `"restartFromDisk": true`)
+	persistHotStuffState()
+	persistActions(actions)
+	actions = nil
+	voted, recorded := false, false
+	acts := Step(Event{Type: MessageEvent, Msg: proposal()})
+	for _, a := range acts {
+		if a.Type == VoteAction && !recorded {
+			test.Fatal("the vote is sent before the safety rules are persisted")
+		}
+		recorded = recorded || a.Type == PersistAction && a.Key == safety.DBKey
+		voted = voted || a.Type == VoteAction
+	}
+	if !voted {
+		test.Fatal("no vote for the proposal")
+	}
+	persistActions(acts)
+
+	// the replica crashes and restarts from its database
+	resetHotStuffState(id)
+	if !restartFromDisk() {
+		test.Fatal("the replica does not restart from disk")
+	}
+	Step(Event{Type: SleepEvent})
+	persistActions(Step(Event{Type: WakeEvent, RecMode: config.RecFromDisk}))
+	if safetyRules.VotedView != 0 || safetyRules.VotedHeight != 1 {
+		test.Fatalf("safety rules after the restart: %v", &safetyRules)
+	}
+
+	// another block at the same view and height
+	conflicting := proposal()
+	conflicting.Hash = cryptolib.GenHash([]byte("another block 1"))
+	for _, a := range Step(Event{Type: MessageEvent, Msg: conflicting}) {
+		if a.Type == VoteAction {
+			test.Fatalf("vote for a conflicting block after the restart: %+v", a.Vote)
+		}
+	}
+}
+
+func TestStepPropose(test *testing.T) {
+	startCore(test, 0)
+	for _, a := range Step(Event{Type: RequestEvent}) {
+		if a.Type == DeliverAction || a.Type == BroadcastAction {
+			test.Fatal("a proposal without requests")
+		}
+	}
+
+	var delivered, broadcasted []byte
+	for _, a := range Step(Event{Type: RequestEvent, Requests: [][]byte{[]byte("tx")}}) {
+		switch a.Type {
+		case DeliverAction:
+			delivered = a.Msg
+		case BroadcastAction:
+			broadcasted = a.Msg
+		}
+	}
+	if delivered == nil || !reflect.DeepEqual(delivered, broadcasted) {
+		test.Fatal("the leader does not propose to all the replicas")
+	}
+	content := message.DeserializeHotStuffMessage(delivered)
+	if content.Mtype != pb.MessageType_QC || content.Seq != 1 || len(content.OPS) != 1 {
+		test.Fatalf("proposal: %+v", content)
+	}
+	if curStatus.Get() != PROCESSING || pool.HasPending() {
+		test.Fatal("the leader proposes the same requests again")
+	}
+
+	// once the proposal has a QC, it is committed with its descendants: a view change does not
+	// propose its request again, and a child proposal cannot repeat it
+	receivedBlocksSet.Store(utils.BytesToString(content.Hash), delivered)
+	defer receivedBlocksSet.Delete(utils.BytesToString(content.Hash))
+	curBlock = message.QCBlock{Height: content.Seq, Hash: content.Hash}
+	requeueRequests()
+	if pool.HasPending() {
+		test.Fatal("a request of the branch of the highest QC is proposed again")
+	}
+	qc, _ := curBlock.Serialize()
+	child := message.HotStuffMessage{Mtype: pb.MessageType_QC, Seq: 2, OPS: content.OPS, QC: qc}
+	if checkBlock(child) == nil {
+		test.Fatal("a proposal repeats the request of its parent")
+	}
+	curBlock = message.QCBlock{}
+	requeueRequests()
+	if !pool.HasPending() {
+		test.Fatal("a request off the branch of the highest QC is not proposed again")
+	}
+}
+
+// Load the test configuration with other settings, and return its file.
+func loadConf(test *testing.T, settings string) string {
+	conf := filepath.Join(test.TempDir(), "conf.json")
+	changed := strings.Replace(testConf, `"maxBatchSize": 1,`, `"maxBatchSize": 1, `+settings+`,`, 1)
+	if err := os.WriteFile(conf, []byte(changed), 0644); err != nil {
+		test.Fatal(err)
+	}
+	if !config.LoadConfigFile(conf) {
+		test.Fatal("cannot load the configuration")
+	}
+	return conf
+}
+
+func TestForwardRequests(test *testing.T) {
+	startCore(test, 2)
+	conf := loadConf(test, `"forwardRequests": true`)
+	cr := message.ClientRequest{ID: 7, OP: []byte("tx")}
+	crser, _ := cr.Serialize()
+	request, _ := message.SerializeWithSignature(7, crser)
+
+	// a sleeping replica keeps the request, and forwards it once awake
+	curStatus.Set(SLEEPING)
+	for _, a := range Step(Event{Type: RequestEvent, Requests: [][]byte{request}}) {
+		if a.Type == SendAction {
+			test.Fatal("a sleeping replica forwards a request")
+		}
+	}
+	curStatus.Set(READY)
+	var forwarded []byte
+	var dests []int64
+	for _, a := range Step(Event{Type: RequestEvent}) {
+		if a.Type == SendAction {
+			forwarded = a.Msg
+			dests = append(dests, a.To)
+		}
+	}
+	if !reflect.DeepEqual(dests, []int64{0, 1}) {
+		test.Fatalf("the request is forwarded to %v, not to the leaders of views 0 and 1", dests)
+	}
+	content := message.DeserializeHotStuffMessage(forwarded)
+	if content.Mtype != pb.MessageType_WRITE_BATCH || len(content.OPS) != 1 || !reflect.DeepEqual(content.OPS[0].GetMsg(), request) {
+		test.Fatalf("forwarded message: %+v", content)
+	}
+	for _, a := range Step(Event{Type: RequestEvent, Requests: [][]byte{request}}) {
+		if a.Type == SendAction {
+			test.Fatal("a request is forwarded twice")
+		}
+	}
+
+	// the next leader keeps the forwarded request, without forwarding it again
+	startCore(test, 1)
+	config.LoadConfigFile(conf)
+	for _, a := range Step(Event{Type: MessageEvent, Msg: content}) {
+		if a.Type == SendAction {
+			test.Fatal("a forwarded request is forwarded again")
+		}
+	}
+	if pool.Len() != 1 || !pool.HasPending() {
+		test.Fatal("the forwarded request is not in the mempool")
+	}
+}
+
+// Sign a message as replica signer.
+func signAs(test *testing.T, keyring *cryptolib.Keyring, signer int64, content message.HotStuffMessage) message.MessageWithSignature {
+	msgbyte, _ := content.Serialize()
+	sig, err := keyring.Signer(signer).Sign(msgbyte)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return message.MessageWithSignature{Msg: msgbyte, Sig: sig}
+}
+
+func TestDataAvailability(test *testing.T) {
+	keyring := startCore(test, 1)
+	conf := loadConf(test, `"daMode": true`)
+	cr := message.ClientRequest{ID: 7, OP: []byte("tx")}
+	crser, _ := cr.Serialize()
+	request, _ := message.SerializeWithSignature(7, crser)
+
+	// replica 1 broadcasts a batch of the request, and acknowledges it
+	var batch, ack message.HotStuffMessage
+	for _, a := range Step(Event{Type: RequestEvent, Requests: [][]byte{request}}) {
+		switch a.Type {
+		case BroadcastAction:
+			batch = message.DeserializeHotStuffMessage(a.Msg)
+		case DeliverAction:
+			ack = message.DeserializeHotStuffMessage(a.Msg)
+		}
+	}
+	if batch.Mtype != pb.MessageType_BROADCAST || len(batch.OPS) != 1 || !reflect.DeepEqual(ack.Hash, batch.Hash) {
+		test.Fatalf("batch %+v, acknowledgement %+v", batch, ack)
+	}
+
+	// f+1 acknowledgements make the certificate
+	var certMsg message.HotStuffMessage
+	for _, signer := range []int64{1, 2} {
+		content := ack
+		content.Source = signer
+		for _, a := range Step(Event{Type: MessageEvent, Msg: content, Signed: signAs(test, keyring, signer, content)}) {
+			if a.Type == BroadcastAction {
+				certMsg = message.DeserializeHotStuffMessage(a.Msg)
+			}
+		}
+	}
+	if len(certMsg.Batches) != 1 || len(certMsg.Batches[0].Acks) != 2 {
+		test.Fatalf("certificate: %+v", certMsg)
+	}
+
+	// the leader proposes the certificate instead of the request
+	startCore(test, 0)
+	config.LoadConfigFile(conf)
+	cryptolib.SetVerifier(keyring)
+	cryptolib.SetSigner(keyring.Signer(0))
+	var proposed message.HotStuffMessage
+	for _, a := range Step(Event{Type: MessageEvent, Msg: certMsg}) {
+		if a.Type == BroadcastAction {
+			proposed = message.DeserializeHotStuffMessage(a.Msg)
+		}
+	}
+	if proposed.Mtype != pb.MessageType_QC || len(proposed.OPS) != 0 || len(proposed.Batches) != 1 {
+		test.Fatalf("proposal: %+v", proposed)
+	}
+	forged := certMsg.Batches[0]
+	forged.Acks = forged.Acks[:1]
+	if checkBlock(message.HotStuffMessage{Batches: []message.BatchCert{forged}}) == nil {
+		test.Fatal("a certificate of one replica is accepted")
+	}
+
+	// the batch of a committed block is fetched from the replicas that acknowledged it
+	block := message.QCBlock{Batches: proposed.Batches}
+	actions = nil
+	if _, complete := blockTxs(block); complete {
+		test.Fatal("the block is complete without its batch")
+	}
+	var fetched []int64
+	for _, a := range actions {
+		if a.Type == SendAction && message.DeserializeHotStuffMessage(a.Msg).Mtype == pb.MessageType_RECONSTRUCT {
+			fetched = append(fetched, a.To)
+		}
+	}
+	if !reflect.DeepEqual(fetched, []int64{1, 2}) {
+		test.Fatalf("the batch is fetched from %v", fetched)
+	}
+	reply := batch
+	reply.Mtype = pb.MessageType_RECONSTRUCT
+	reply.Source = 2
+	reply.Batches = proposed.Batches
+	Step(Event{Type: MessageEvent, Msg: reply})
+	txs, complete := blockTxs(block)
+	if !complete || len(txs) != 1 || !reflect.DeepEqual(txs[0].Msg, crser) {
+		test.Fatalf("requests of the block: %+v", txs)
+	}
+}
+
+func TestErasureCodedBroadcast(test *testing.T) {
+	keyring := startCore(test, 0)
+	communication.StartConnectionManager()
+	takeEvents()
+	rbcType = ECRBC
+	defer func() { rbcType = RBC }()
+	content := proposal()
+	content.Source = 1
+	content.OPS = []pb.RawMessage{{Msg: []byte(strings.Repeat("tx", ecrbcMinSize))}}
+	signed := signAs(test, keyring, 1, content)
+	value, _ := signed.Serialize()
+	signedSmall := signAs(test, keyring, 1, proposal())
+	small, _ := signedSmall.Serialize()
+	if !erasureCoded(value) || erasureCoded(small) {
+		test.Fatal("only the large messages are erasure-coded")
+	}
+
+	// replica 1 broadcasts its proposal in fragments, and replica 0 gets it back from them
+	nodes := []int64{0, 1, 2, 3}
+	replicas := make(map[int64]bcast.Protocol)
+	for _, node := range nodes {
+		replicas[node] = bcast.NewECRBC(bcast.Members{Self: node, Nodes: nodes, F: 1})
+	}
+	type envelope struct {
+		from    int64
+		actions []bcast.Action
+	}
+	now := time.Now()
+	queue := []envelope{{from: 1, actions: replicas[1].Broadcast(1, value, now)}}
+	sent := 0
+	var delivered []bcast.Action
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		for _, a := range e.actions {
+			if a.Type == bcast.DeliverAction {
+				if e.from == 0 {
+					delivered = append(delivered, a)
+				}
+				continue
+			}
+			if e.from == 1 && a.Msg.Type == bcast.SEND {
+				sent += len(a.Msg.Value)
+			}
+			for _, node := range nodes {
+				if a.To == bcast.All || a.To == node {
+					queue = append(queue, envelope{from: node, actions: replicas[node].Handle(a.Msg, now)})
+				}
+			}
+		}
+	}
+	if sent > len(value)*len(nodes)/2+len(nodes) {
+		test.Fatalf("replica 1 sends %d bytes for a message of %d", sent, len(value))
+	}
+	if len(delivered) != 1 || !reflect.DeepEqual(delivered[0].Value, value) {
+		test.Fatalf("replica 0 delivers %d messages", len(delivered))
+	}
+	deliverBroadcast(delivered[0].ID, delivered[0].Value)
+	evs := takeEvents()
+	if len(evs) != 1 || evs[0].Type != MessageEvent || evs[0].Msg.Source != 1 || !reflect.DeepEqual(evs[0].Msg.OPS[0].GetMsg(), content.OPS[0].GetMsg()) {
+		test.Fatalf("events: %+v", evs)
+	}
+}
+
+func TestBroadcastDelivery(test *testing.T) {
+	keyring := startCore(test, 0)
+	content := proposal()
+	content.Source = 1
+	signed := signAs(test, keyring, 1, content)
+	value, _ := signed.Serialize()
+	communication.StartConnectionManager()
+	takeEvents()
+
+	// a value is handled as a message of its sender only
+	deliverBroadcast(bcast.ID{Sender: 2, Instance: 1}, value)
+	deliverBroadcast(bcast.ID{Sender: 0, Instance: 1}, value)
+	if evs := takeEvents(); len(evs) != 0 {
+		test.Fatalf("%d events for the message of replica 1 broadcast by others", len(evs))
+	}
+	deliverBroadcast(bcast.ID{Sender: 1, Instance: 1}, value)
+	evs := takeEvents()
+	if len(evs) != 1 || evs[0].Type != MessageEvent || evs[0].Msg.Source != 1 {
+		test.Fatalf("events: %+v", evs)
+	}
+
+	// the recovery requests use the reliable broadcast with reliableRec
+	for _, reliable := range []bool{false, true} {
+		if reliable {
+			loadConf(test, `"reliableRec": true`)
+		}
+		Step(Event{Type: SleepEvent})
+		var rec []Action
+		for _, a := range Step(Event{Type: WakeEvent, RecMode: config.RecKoala2}) {
+			if a.Type == BroadcastAction || a.Type == ReliableAction {
+				rec = append(rec, a)
+			}
+		}
+		if len(rec) != 1 || (rec[0].Type == ReliableAction) != reliable || message.DeserializeHotStuffMessage(rec[0].Msg).Mtype != pb.MessageType_REC1 {
+			test.Fatalf("reliableRec %v: actions %+v", reliable, rec)
+		}
+	}
+}
+
+func TestReplayJournal(test *testing.T) {
+	keyring := startCore(test, 1)
+	content := proposal()
+	msg, _ := content.Serialize()
+	sig, _ := keyring.Signer(0).Sign(msg)
+	ev := Event{Type: MessageEvent, Msg: content, Signed: message.MessageWithSignature{Msg: msg, Sig: sig}}
+	recorded := eventEntry(ev)
+	if recorded.Peer != 0 || recorded.Type != "QC" || recorded.Seq != 1 {
+		test.Fatalf("journal entry: %+v", recorded)
+	}
+
+	out, err := Replay(recorded)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Event != "vote" || out[0].Peer != 0 || out[0].Seq != 1 {
+		test.Fatalf("messages sent in the replay: %+v", out)
+	}
+
+	recorded.Payload = nil
+	if _, err := Replay(recorded); err == nil {
+		test.Fatal("replay of an entry without payload")
+	}
+}
+
+// The journal written by the loop of a replica gives the messages it sent when replayed, at the
+// times of its entries.
+func TestReplayRecordedJournal(test *testing.T) {
+	keyring := startCore(test, 2)
+	loadConf(test, `"forwardRequests": true`)
+	dir := filepath.Join(test.TempDir(), "journal")
+	var err error
+	msgJournal, err = journal.Open(dir, true, 1<<20, 2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer func() { msgJournal = nil }()
+
+	cr := message.ClientRequest{ID: 7, OP: []byte("tx")}
+	crser, _ := cr.Serialize()
+	request, _ := message.SerializeWithSignature(7, crser)
+	start := time.UnixMilli(1700000000000)
+	events := []Event{
+		{Type: MessageEvent, Msg: proposal(), Signed: signAs(test, keyring, 0, proposal()), Now: start},
+		{Type: RequestEvent, Requests: [][]byte{request}, Now: start.Add(1500 * time.Millisecond)},
+	}
+	for _, ev := range events {
+		journalEvent(ev)
+		journalActions(Step(ev))
+	}
+	msgJournal.Close()
+	msgJournal = nil
+	entries, err := journal.Read(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the replica starts again from an empty state, and replays its journal
+	resetHotStuffState(id)
+	initMempool()
+	initDA()
+	var recorded, replayed []journal.Entry
+	for _, e := range entries {
+		if e.Dir == journal.Out {
+			recorded = append(recorded, e)
+			continue
+		}
+		if e.Time == 0 {
+			test.Fatalf("event without its time: %+v", e)
+		}
+		out, err := Replay(e)
+		if err != nil {
+			test.Fatal(err)
+		}
+		replayed = append(replayed, out...)
+	}
+	// a vote, and the request forwarded to the leaders of views 0 and 1 with the time of its event
+	if len(recorded) != 3 || message.DeserializeHotStuffMessage(recorded[1].Payload).TS != events[1].Now.UnixMilli() {
+		test.Fatalf("recorded messages: %+v", recorded)
+	}
+	if !reflect.DeepEqual(recorded, replayed) {
+		test.Fatalf("the replay sends other messages:\n%+v\n%+v", recorded, replayed)
+	}
+}
+
+func TestExecuteCommitted(test *testing.T) {
+	startCore(test, 1)
+	startApplication()
+	counter := app.NewCounter()
+	app.Set(counter)
+	defer app.Set(nil)
+
+	block := func(height int, ops ...string) []byte {
+		b := message.QCBlock{Height: height, Hash: cryptolib.GenHash([]byte{byte(height)})}
+		for _, op := range ops {
+			cr := message.ClientRequest{OP: []byte(op)}
+			crser, _ := cr.Serialize()
+			b.TXS = append(b.TXS, message.MessageWithSignature{Msg: crser})
+		}
+		bser, _ := b.Serialize()
+		return bser
+	}
+	committedBlocks.Insert(3, block(3, "d"))
+	committedBlocks.Insert(1, block(1, "a", "b"))
+	Step(Event{Type: RequestEvent})
+	if count, _ := counter.Query("count", nil); executedHeight != 1 || string(count) != "2" {
+		test.Fatalf("executed %s transactions up to height %d, across a missing block", count, executedHeight)
+	}
+	// the execution resumes once the missing block is committed
+	committedBlocks.Insert(2, block(2, "c"))
+	Step(Event{Type: RequestEvent})
+	if count, _ := counter.Query("count", nil); executedHeight != 3 || string(count) != "4" {
+		test.Fatalf("executed %s transactions up to height %d", count, executedHeight)
+	}
+	// a request in several batches, or blocks, is executed once
+	committedBlocks.Insert(4, block(4, "e", "b", "f", "f"))
+	Step(Event{Type: RequestEvent})
+	if count, _ := counter.Query("count", nil); executedHeight != 4 || string(count) != "6" {
+		test.Fatalf("executed %s transactions up to height %d, with the repeated requests", count, executedHeight)
+	}
+
+	// the state hash goes into the next proposal, and the replicas compare it with theirs
+	var msg message.HotStuffMessage
+	attachAppHash(&msg)
+	if msg.AppHeight != 4 || !reflect.DeepEqual(msg.AppHash, appHashes[4]) {
+		test.Fatalf("state hash of the proposal: %d %x", msg.AppHeight, msg.AppHash)
+	}
+}
+
+func TestReceivedBranches(test *testing.T) {
+	// only the proposals of this test
+	receivedBlocksSet.Range(func(key, value interface{}) bool {
+		receivedBlocksSet.Delete(key)
+		return true
+	})
+	proposal := func(seq int, hash string, parent string, ops ...string) {
+		msg := message.HotStuffMessage{Seq: seq, Source: int64(seq % 4), Hash: []byte(hash)}
+		qc := message.QCBlock{Height: seq - 1, Hash: []byte(parent)}
+		msg.QC, _ = qc.Serialize()
+		for _, op := range ops {
+			cr := message.ClientRequest{OP: []byte(op)}
+			crser, _ := cr.Serialize()
+			signed := message.MessageWithSignature{Msg: crser}
+			sser, _ := signed.Serialize()
+			msg.OPS = append(msg.OPS, pb.RawMessage{Msg: sser})
+		}
+		mser, _ := msg.Serialize()
+		receivedBlocksSet.Store(hash, mser)
+	}
+	// a fork at height 2
+	proposal(1, "a", "genesis", "x")
+	proposal(2, "b", "a", "y")
+	proposal(3, "c", "b")
+	proposal(2, "d", "a", "z")
+	defer func() {
+		for _, hash := range []string{"a", "b", "c", "d"} {
+			receivedBlocksSet.Delete(hash)
+		}
+	}()
+
+	branches := receivedBranches()
+	if len(branches) != 2 || string(branches[0].Tip) != "c" || string(branches[1].Tip) != "d" {
+		test.Fatalf("branches %+v", branches)
+	}
+	long, short := branches[0].Blocks, branches[1].Blocks
+	if len(long) != 3 || len(short) != 2 || string(short[0].Hash) != "a" || long[2].Height != 3 {
+		test.Fatalf("blocks %+v %+v", long, short)
+	}
+	// the coinbase of the proposer comes first
+	if len(short[1].Txs) != 2 || string(short[1].Txs[1]) != "z" || short[1].Proposer != 2 {
+		test.Fatalf("transactions %q", short[1].Txs)
+	}
+}
+
+func TestTimeoutReportsHighQC(test *testing.T) {
+	startCore(test, 1)
+	loadConf(test, `"PersistLevel": 3`)
+	curBlock = message.QCBlock{View: 0, Height: 1, Hash: cryptolib.GenHash([]byte("block 1"))}
+	timeout := func() []byte {
+		var sent []byte
+		for _, a := range Step(Event{Type: TimeoutEvent, View: 0}) {
+			if a.Type == BroadcastAction {
+				sent = a.Msg
+			}
+		}
+		return sent
+	}
+
+	first := timeout()
+	content := message.DeserializeHotStuffMessage(first)
+	if content.Mtype != pb.MessageType_TIMEOUT || !reflect.DeepEqual(message.DeserializeQCBlock(content.QC), curBlock) {
+		test.Fatalf("timeout: %+v", content)
+	}
+	// the timer of the view expires again after a higher QC: the timeout is not changed
+	curBlock = message.QCBlock{View: 0, Height: 2, Hash: cryptolib.GenHash([]byte("block 2"))}
+	if !reflect.DeepEqual(timeout(), first) {
+		test.Fatal("two timeouts sent in a view")
+	}
+}
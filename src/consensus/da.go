@@ -0,0 +1,337 @@
+package consensus
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/da"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/mempool"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/quorum"
+	"time"
+)
+
+/*
+Data-availability mode, see src/da. The messages are HotStuff messages:
+
+	BROADCAST with OPS        a batch of requests of Source, with its Seq and digest in Hash
+	BROADCAST without OPS     the acknowledgement of the batch Hash, signed by Source
+	BROADCAST with Batches    the certificate of a batch, sent by its author to all the replicas
+	RECONSTRUCT without OPS   a request for the batches of the certificates in Batches
+	RECONSTRUCT with OPS      the batch of the certificate Batches[0], as requested
+*/
+
+// Batches of this replica waiting for their certificate; no new batch is broadcast beyond.
+const batchWindow = 8
+
+// A batch without certificate is broadcast again after this time, a missing batch fetched again.
+const batchRetry = time.Second
+
+type pendingBatch struct {
+	acks []message.MessageWithSignature
+	sent time.Time
+}
+
+var batchStore da.Store                         // batches and certificates known to this replica
+var ownBatches map[string]*pendingBatch         // batches of this replica without certificate
+var awaitingBatches map[int][]message.BatchCert // certificates of the blocks proposed by this replica
+var fetching map[string]time.Time               // batches requested from other replicas
+var lastResend time.Time
+
+func daMode() bool {
+	return config.DAMode() && consensus == HotStuff
+}
+
+func awake() bool {
+	s := curStatus.Get()
+	return s != SLEEPING && s != RECOVERING
+}
+
+func initDA() {
+	batchStore.Init()
+	resetDA()
+}
+
+// The state of data availability that is lost with the memory.
+func resetDA() {
+	ownBatches = make(map[string]*pendingBatch)
+	awaitingBatches = make(map[int][]message.BatchCert)
+	fetching = make(map[string]time.Time)
+	lastResend = time.Time{}
+}
+
+// Whether the leader has something to propose.
+func hasPending() bool {
+	if daMode() {
+		return batchStore.HasPending()
+	}
+	return pool.HasPending()
+}
+
+// The certificates of the next proposal.
+func takeCerts() []message.BatchCert {
+	certs := batchStore.Take(0)
+	persist("batches", &batchStore, db.PersistAll)
+	return certs
+}
+
+func batchContent(b da.Batch, digest []byte) message.HotStuffMessage {
+	ops := make([]pb.RawMessage, len(b.Requests))
+	for i := range b.Requests {
+		ops[i].Msg = b.Requests[i]
+	}
+	return message.HotStuffMessage{
+		Mtype:  pb.MessageType_BROADCAST,
+		Source: id,
+		Seq:    b.Seq,
+		Hash:   digest,
+		OPS:    ops,
+		TS:     timestamp(),
+	}
+}
+
+func batchMessage(b da.Batch, digest []byte) []byte {
+	msg := batchContent(b, digest)
+	msgbyte, _ := msg.Serialize()
+	return msgbyte
+}
+
+// Broadcast the batch and acknowledge it, as the first replica to store it.
+func sendBatch(b da.Batch, digest []byte) {
+	broadcast(batchMessage(b, digest))
+	ack := da.Ack(id, b.Seq, digest)
+	ackbyte, _ := ack.Serialize()
+	deliver(ackbyte)
+}
+
+// Broadcast a batch of the pending requests, while few batches of this replica wait for their
+// certificate, and broadcast again the batches that wait for too long.
+func disseminateBatches() {
+	if !daMode() || !awake() {
+		return
+	}
+	now := eventTime
+	if now.Sub(lastResend) >= batchRetry {
+		lastResend = now
+		resendBatches(now)
+	}
+	if !pool.HasPending() || len(ownBatches) >= batchWindow {
+		return
+	}
+	requests := pool.Reap(policy, blockLimits())
+	if len(requests) == 0 {
+		return
+	}
+	batchStore.Seq++
+	b := da.Batch{Author: id, Seq: batchStore.Seq, Requests: requests}
+	digest := da.Digest(id, b.Seq, requests)
+	key, _ := batchStore.AddBatch(b, digest, now)
+	ownBatches[key] = &pendingBatch{sent: now}
+	sendBatch(b, digest)
+	persist("batches", &batchStore, db.PersistAll)
+	persist("mempool", &pool, db.PersistAll)
+}
+
+// The batches of this replica without certificate, also the ones broadcast before a sleep.
+func resendBatches(now time.Time) {
+	for key, b := range batchStore.Batches {
+		if _, certified := batchStore.Certs[key]; b.Author != id || certified {
+			continue
+		}
+		pending, exist := ownBatches[key]
+		if exist && now.Sub(pending.sent) < batchRetry {
+			continue
+		}
+		if !exist {
+			pending = &pendingBatch{}
+			ownBatches[key] = pending
+		}
+		pending.sent = now
+		p := fmt.Sprintf("[DA] batch %d has %d acknowledgements, broadcasting it again", b.Seq, len(pending.acks))
+		logging.PrintLog(verbose, logging.NormalLog, p)
+		digest := da.Digest(b.Author, b.Seq, b.Requests)
+		if len(pending.acks) == 0 {
+			sendBatch(b, digest)
+		} else {
+			broadcast(batchMessage(b, digest))
+		}
+	}
+}
+
+func handleBroadcast(content message.HotStuffMessage, signed message.MessageWithSignature) {
+	if !daMode() {
+		return
+	}
+	switch {
+	case len(content.Batches) > 0:
+		handleCert(content)
+	case len(content.OPS) > 0:
+		handleBatch(content)
+	default:
+		handleAck(content, signed)
+	}
+}
+
+// Store the batch of another replica, and acknowledge it.
+func handleBatch(content message.HotStuffMessage) {
+	requests := make([][]byte, len(content.OPS))
+	hashes := make([]string, len(content.OPS))
+	for i := range content.OPS {
+		requests[i] = content.OPS[i].GetMsg()
+		hashes[i] = mempool.HashOfSigned(message.DeserializeMessageWithSignature(requests[i]))
+	}
+	err := mempool.CheckBlock(requests, blockLimits())
+	if err == nil {
+		b := da.Batch{Author: content.Source, Seq: content.Seq, Requests: requests}
+		_, err = batchStore.AddBatch(b, content.Hash, eventTime)
+	}
+	if err != nil {
+		p := fmt.Sprintf("[DA Error] batch %d of replica %d refused: %v", content.Seq, content.Source, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	// requests sent to several replicas are only put in a batch once.
+	pool.MarkProposed(hashes)
+	persist("batches", &batchStore, db.PersistAll)
+
+	ack := da.Ack(id, content.Seq, content.Hash)
+	ackbyte, _ := ack.Serialize()
+	send(ackbyte, content.Source)
+}
+
+// Add an acknowledgement of a batch of this replica; f+1 of them make its certificate.
+func handleAck(content message.HotStuffMessage, signed message.MessageWithSignature) {
+	key := da.Key(content.Hash)
+	pending, exist := ownBatches[key]
+	if !exist {
+		return
+	}
+	for i := range pending.acks {
+		if message.DeserializeHotStuffMessage(pending.acks[i].Msg).Source == content.Source {
+			return
+		}
+	}
+	item := cryptolib.SigItem{ID: content.Source, Msg: signed.Msg, Sig: signed.Sig}
+	if !cryptolib.VerifyBatch([]cryptolib.SigItem{item})[0] {
+		p := fmt.Sprintf("[DA Error] acknowledgement of replica %d not verified", content.Source)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	pending.acks = append(pending.acks, signed)
+	if len(pending.acks) < quorum.FSize()+1 {
+		return
+	}
+	b, _ := batchStore.Batch(content.Hash)
+	cert := message.BatchCert{Author: id, Seq: b.Seq, Digest: content.Hash, Acks: pending.acks}
+	delete(ownBatches, key)
+	batchStore.AddCert(cert)
+	persist("batches", &batchStore, db.PersistAll)
+
+	msg := message.HotStuffMessage{
+		Mtype:   pb.MessageType_BROADCAST,
+		Source:  id,
+		Seq:     b.Seq,
+		Hash:    content.Hash,
+		Batches: []message.BatchCert{cert},
+		TS:      timestamp(),
+	}
+	msgbyte, _ := msg.Serialize()
+	broadcast(msgbyte)
+}
+
+// Keep the certificate of another replica, to propose it as leader.
+func handleCert(content message.HotStuffMessage) {
+	cert := content.Batches[0]
+	if _, exist := batchStore.Certs[da.Key(cert.Digest)]; exist {
+		return
+	}
+	if err := da.VerifyCert(cert, quorum.FSize()+1); err != nil {
+		logging.PrintLog(true, logging.ErrorLog, err.Error())
+		return
+	}
+	batchStore.AddCert(cert)
+	persist("batches", &batchStore, db.PersistAll)
+}
+
+// Request the batch of a certificate from the replicas that acknowledged it.
+func fetchBatch(cert message.BatchCert) {
+	key := da.Key(cert.Digest)
+	if t, exist := fetching[key]; exist && eventTime.Sub(t) < batchRetry {
+		return
+	}
+	fetching[key] = eventTime
+	msg := message.HotStuffMessage{
+		Mtype:   pb.MessageType_RECONSTRUCT,
+		Source:  id,
+		Batches: []message.BatchCert{cert},
+		TS:      timestamp(),
+	}
+	msgbyte, _ := msg.Serialize()
+	for _, signer := range da.Signers(cert) {
+		if signer != id {
+			send(msgbyte, signer)
+		}
+	}
+}
+
+func handleReconstruct(content message.HotStuffMessage) {
+	if len(content.Batches) == 0 {
+		return
+	}
+	if len(content.OPS) == 0 {
+		for _, cert := range content.Batches {
+			b, exist := batchStore.Batch(cert.Digest)
+			if !exist {
+				continue
+			}
+			msg := batchContent(b, cert.Digest)
+			msg.Mtype = pb.MessageType_RECONSTRUCT
+			msg.Batches = []message.BatchCert{cert}
+			msgbyte, _ := msg.Serialize()
+			send(msgbyte, content.Source)
+		}
+		return
+	}
+	cert := content.Batches[0]
+	key := da.Key(cert.Digest)
+	if _, waiting := fetching[key]; !waiting {
+		return
+	}
+	requests := make([][]byte, len(content.OPS))
+	for i := range content.OPS {
+		requests[i] = content.OPS[i].GetMsg()
+	}
+	b := da.Batch{Author: cert.Author, Seq: cert.Seq, Requests: requests}
+	if _, err := batchStore.AddBatch(b, cert.Digest, eventTime); err != nil {
+		p := fmt.Sprintf("[DA Error] batch %x from replica %d refused: %v", cert.Digest, content.Source, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	delete(fetching, key)
+	persist("batches", &batchStore, db.PersistAll)
+}
+
+// The requests of a committed block: in data-availability mode, the requests of its batches follow
+// the coinbase. A batch that is not stored yet is fetched, and the block is not complete.
+func blockTxs(b message.QCBlock) ([]message.MessageWithSignature, bool) {
+	if len(b.Batches) == 0 {
+		return b.TXS, true
+	}
+	txs := append([]message.MessageWithSignature(nil), b.TXS...)
+	complete := true
+	for _, cert := range b.Batches {
+		batch, exist := batchStore.Batch(cert.Digest)
+		if !exist {
+			fetchBatch(cert)
+			complete = false
+			continue
+		}
+		for _, request := range batch.Requests {
+			txs = append(txs, message.DeserializeMessageWithSignature(request))
+		}
+	}
+	return txs, complete
+}
@@ -6,8 +6,10 @@ import (
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/cryptolib"
 	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
 	"sleepy-hotstuff/src/utils"
 	"sync"
+	"time"
 )
 
 type ConsensusType int
@@ -66,15 +68,27 @@ func StartHandler(rid string, mem string) {
 	config.LoadConfig()
 	cryptolib.StartCrypto(id, config.CryptoOption())
 	consensus = ConsensusType(config.Consensus())
+	rbcType = RbcType(config.RBCType())
 
 	n = config.FetchNumReplicas()
 	curStatus.Init()
 	epoch.Init()
 	midTime = make(map[int]int64)
-	queue.Init()
-	db.PersistValue("queue", &queue, db.PersistAll)
+	// the initialization is taken as an event of the start time
+	eventTime = time.Now()
+	restart := restartFromDisk()
+	if !restart {
+		// a fresh replica does not inherit the state of the previous run.
+		db.ClearDB()
+	}
+	initMempool()
+	initDA()
 	MsgQueue.Init()
-	db.PersistValue("queue", &MsgQueue, db.PersistAll)
+	if !restart {
+		db.PersistValue("mempool", &pool, db.PersistAll)
+		db.PersistValue("batches", &batchStore, db.PersistAll)
+		db.PersistValue("MsgQueue", &MsgQueue, db.PersistAll)
+	}
 	verbose = config.FetchVerbose()
 	sleepTimerValue = config.FetchSleepTimer()
 
@@ -82,7 +96,11 @@ func StartHandler(rid string, mem string) {
 	switch consensus {
 	case HotStuff:
 		log.Printf("running HotStuff")
-		InitHotStuff(id)
+		if restart {
+			resetHotStuffState(id)
+		} else {
+			InitHotStuff(id)
+		}
 		if config.EvalMode() > 0 {
 			// genesisTime = utils.MakeTimestamp()
 			curOPS.Init()
@@ -93,7 +111,32 @@ func StartHandler(rid string, mem string) {
 	}
 
 	sender.StartSender(rid)
-	go RequestMonitor(LocalView())
+	startBroadcast()
+	startJournal()
+	startApplication()
+	startByzantine()
+	// the evidence is found on the receiving goroutines, and in Step while replaying the messages
+	// of the database, so it is written by the loop as the other values.
+	evidence.SetStore(func(l *evidence.List) error {
+		submit(Event{Type: EvidenceEvent})
+		return nil
+	})
+	if restart {
+		log.Printf("restarting replica %v from the local database", id)
+		if err := evidence.Load(); err != nil {
+			log.Printf("cannot load the stored evidence: %v", err)
+		}
+		curStatus.Set(SLEEPING)
+		err := RecoveryProcess(config.RecFromDisk)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	timerPending = true
+	// the actions of the initialization, taken before the loop starts.
+	execute(actions)
+	actions = nil
+	go run()
 
 	//go func() {
 	//	for true {
@@ -102,9 +145,16 @@ func StartHandler(rid string, mem string) {
 	//	}
 	//}()
 
-	if t, _ := config.FetchTestTypeAndParam(); t != config.Test_off {
-		go TestSleepAndRecover(id)
+	go TestSleepAndRecover(id)
+}
+
+// A replica restarts from disk only if everything has been persisted
+// and the database holds the state of a previous run.
+func restartFromDisk() bool {
+	if !config.RestartFromDisk() || db.PersistLevelType(config.PersistLevel()) != db.PersistAll {
+		return false
 	}
+	return db.HasValue("Sequence")
 }
 
 type QueueHead struct {
@@ -141,14 +191,16 @@ const (
 
 func (c *CurStatus) Set(status Status) {
 	c.Lock()
-	defer c.Unlock()
 	c.enum = status
+	c.Unlock()
+	statusSignal.Broadcast()
 }
 
 func (c *CurStatus) Init() {
 	c.Lock()
-	defer c.Unlock()
 	c.enum = READY
+	c.Unlock()
+	statusSignal.Broadcast()
 }
 
 func (c *CurStatus) Get() Status {
@@ -0,0 +1,32 @@
+package consensus
+
+import (
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/message"
+)
+
+/*
+Erasure-coded broadcast of large messages, such as proposals, when RBCType is ECRBC. The
+broadcaster signs the message and broadcasts it with the ECRBC primitive of src/broadcast: the
+message is split into n fragments, any f+1 of which give it back, each replica receives its
+fragment with the Merkle proof and echoes it to the others, and the replicas deliver the message
+once enough of them are ready, then handle it as received from the broadcaster. The broadcaster
+sends n/(f+1) times the message instead of n times.
+*/
+
+// Smaller messages are sent whole to every replica.
+const ecrbcMinSize = 4096
+
+// Whether a message is erasure-coded when broadcast.
+func erasureCoded(msg []byte) bool {
+	return rbcType == ECRBC && len(msg) >= ecrbcMinSize
+}
+
+// Broadcast a message of the core, erasure-coded when it is large and RBCType is ECRBC.
+func broadcastMsg(msg []byte) {
+	if !erasureCoded(msg) {
+		sender.RBCByteBroadcast(msg)
+		return
+	}
+	reliableBroadcast(msg, message.ECRBC)
+}
@@ -3,12 +3,9 @@ package consensus
 import (
 	"fmt"
 	"log"
-	"sleepy-hotstuff/src/config"
-	"sleepy-hotstuff/src/db"
 	"sleepy-hotstuff/src/logging"
 	"sleepy-hotstuff/src/quorum"
 	"sleepy-hotstuff/src/utils"
-	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -17,7 +14,6 @@ var verbose bool //verbose level
 var id int64     //id of server
 var iid int      //id in type int, start a RBC using it to instanceid
 var errs error
-var queue Queue         // cached client requests
 var queueHead QueueHead // hash of the request that is in the first place of the queue
 var sleepTimerValue int // sleeptimer for the while loop that continues to monitor the queue or the request status
 var consensus ConsensusType
@@ -76,75 +72,6 @@ func CaptureLastRBCLat() {
 
 }
 
-// This func is used to grab cached requests from clients and propose new proposals.
-// So if the node is not a leader, it will leave this func.
-// Modify: when invoking this func, users need to input the view number associated with this monitor.
-// This monitor will return when its view number is not the current view.
-func RequestMonitor(v int) {
-	if consensus == HotStuff {
-		// comments: Now we only test the view change of hotstuff.
-		for queue.IsEmpty() && LocalView() == 0 {
-			// wait until the first client sends its first request.
-			// then we start the rotatingTimer.
-			// In other words, we view the time the first request is received
-			//as the beginning of the system.
-			// log.Printf("wait for new requests in queue.")
-			continue
-		}
-		if config.IsViewChangeMode() {
-			StartRotatingTimer(v)
-		}
-	}
-
-	for {
-		if v != LocalView() {
-			return
-		}
-		switch consensus {
-		case HotStuff:
-			sleepLock.RLock()
-			if curStatus.Get() == SLEEPING {
-				sleepLock.RUnlock()
-				return
-			}
-			if LeaderID(v) != int(id) {
-				sleepLock.RUnlock()
-				return
-			}
-
-			if !(curStatus.Get() == READY && (awaitingDecisionCopy.GetLen() > 0 || !queue.IsEmpty())) {
-				// awaitingDecisionCopy.GenLen seems to be always > 0,
-				// except the initial period of a leader.
-
-				if awaitingDecisionCopy.GetLen() == 0 {
-					log.Printf("[Error] awaitingDecisionCopy.GetLen() == 0")
-				}
-				if curStatus.Get() != READY {
-					//log.Printf("[Error] curStatus is %v, is not READY!", curStatus.Get())
-				}
-
-				// Here, wait for sleepTimerValue is not a suitable method to wait until curState == READY
-				// It will add the block latency.
-				if queue.IsEmpty() {
-					// log.Printf("sleep %d ms", 5*sleepTimerValue)
-					time.Sleep(time.Duration(sleepTimerValue) * time.Millisecond)
-				} else {
-					time.Sleep(time.Duration(sleepTimerValue) * time.Millisecond)
-				}
-				sleepLock.RUnlock()
-				continue
-			}
-
-			curStatus.Set(PROCESSING)
-			batch := queue.GrabWithMaxLenAndClear()
-			db.PersistValue("queue", &queue, db.PersistAll)
-			log.Println("batchSize:", len(batch))
-			StartHotStuff(batch)
-			sleepLock.RUnlock()
-		}
-	}
-}
-
 func HandleRequest(request []byte, hash string) {
 	//log.Printf("Handling request")
 	//rawMessage := message.DeserializeMessageWithSignature(request)
@@ -157,8 +84,7 @@ func HandleRequest(request []byte, hash string) {
 	//log.Printf("Receive len %v op %v\n",len(request),m.OP)
 	batchSize = 1
 	requestSize = len(request)
-	queue.Append(request)
-	db.PersistValue("queue", &queue, db.PersistAll)
+	submit(Event{Type: RequestEvent, Requests: [][]byte{request}})
 }
 
 func HandleBatchRequest(requests []byte) {
@@ -183,8 +109,7 @@ func HandleBatchRequest(requests []byte) {
 	}*/
 	batchSize = Len
 	requestSize = len(requestArr[0])
-	queue.AppendBatch(requestArr)
-	db.PersistValue("queue", &queue, db.PersistAll)
+	submit(Event{Type: RequestEvent, Requests: requestArr})
 }
 
 func DeserializeRequests(input []byte) [][]byte {
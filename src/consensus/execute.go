@@ -0,0 +1,153 @@
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sleepy-hotstuff/src/app"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/mempool"
+	"sleepy-hotstuff/src/message"
+	"sort"
+)
+
+var executedHeight int       // height of the last committed block executed by the application
+var appHashes map[int][]byte // state hash of the application after each executed height
+
+// Requests executed in the last executedWindow heights are not executed again. A client that sends
+// a request to several replicas can get it into several batches, or blocks, in a short time.
+const executedWindow = 1000
+
+var executedRequests map[string]bool // hashes of the requests executed recently
+var executedAt map[int][]string      // hashes of the requests executed at each recent height
+
+// Set up the application named in conf.json, unless one was set before consensus starts.
+func startApplication() {
+	executedHeight = 0
+	appHashes = make(map[int][]byte)
+	executedRequests = make(map[string]bool)
+	executedAt = make(map[int][]string)
+	if app.Current() != nil {
+		return
+	}
+	a, err := app.New(config.Application())
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Set(a)
+}
+
+// The transaction of a client request, a serialized MessageWithSignature.
+func txOf(request []byte) []byte {
+	signed := message.DeserializeMessageWithSignature(request)
+	return message.DeserializeClientRequest(signed.Msg).OP
+}
+
+// Keep the requests accepted by CheckTx.
+func checkTxs(requests [][]byte) [][]byte {
+	a := app.Current()
+	if a == nil {
+		return requests
+	}
+	var accepted [][]byte
+	for _, request := range requests {
+		if err := a.CheckTx(txOf(request)); err != nil {
+			p := fmt.Sprintf("[App Error] request refused by the application: %v", err)
+			logging.PrintLog(verbose, logging.ErrorLog, p)
+			continue
+		}
+		accepted = append(accepted, request)
+	}
+	return accepted
+}
+
+// Execute the blocks committed since the last step, in the order of their heights. The execution
+// stops at the first height missing here, and resumes at a later step, once the block is
+// committed, or received with the committed blocks of a recovery.
+func executeCommitted() {
+	a := app.Current()
+	if a == nil || committedBlocks.GetLen() == 0 {
+		return
+	}
+	var heights []int
+	for h := range committedBlocks.GetAll() {
+		if h > executedHeight {
+			heights = append(heights, h)
+		}
+	}
+	sort.Ints(heights)
+	for _, h := range heights {
+		if h != executedHeight+1 {
+			p := fmt.Sprintf("[App] block %d waits for block %d, not committed here yet", h, executedHeight+1)
+			logging.PrintLog(verbose, logging.NormalLog, p)
+			break
+		}
+		bser, _ := committedBlocks.Get(h)
+		b := message.DeserializeQCBlock(bser)
+		txs, complete := blockTxs(b)
+		if !complete {
+			p := fmt.Sprintf("[App] block %d waits for the requests of its batches", h)
+			logging.PrintLog(verbose, logging.NormalLog, p)
+			break
+		}
+		a.BeginBlock(app.Header{View: b.View, Height: h, Hash: b.Hash, Proposer: int64(LeaderID(b.View))})
+		for i := 0; i < len(txs); i++ {
+			// the first transaction is the coinbase of the leader.
+			if i > 0 && executedTwice(txs[i], h) {
+				p := fmt.Sprintf("[App] transaction %d of block %d already executed, skipped", i, h)
+				logging.PrintLog(verbose, logging.NormalLog, p)
+				continue
+			}
+			tx := message.DeserializeClientRequest(txs[i].Msg).OP
+			result := a.DeliverTx(tx)
+			if result.Code != app.CodeOK {
+				p := fmt.Sprintf("[App] transaction %d of block %d failed with code %d: %s", i, h, result.Code, result.Log)
+				logging.PrintLog(verbose, logging.NormalLog, p)
+			}
+		}
+		a.EndBlock(h)
+		appHashes[h] = a.Commit()
+		executedHeight = h
+		forgetExecuted(h)
+	}
+}
+
+// Reports whether the request was executed recently, and records it as executed at height h
+// otherwise. The heights only depend on the committed blocks, so all the replicas skip the same ones.
+func executedTwice(request message.MessageWithSignature, h int) bool {
+	hash := mempool.HashOfSigned(request)
+	if executedRequests[hash] {
+		return true
+	}
+	executedRequests[hash] = true
+	executedAt[h] = append(executedAt[h], hash)
+	return false
+}
+
+// Forget the requests executed executedWindow heights below h.
+func forgetExecuted(h int) {
+	for _, hash := range executedAt[h-executedWindow] {
+		delete(executedRequests, hash)
+	}
+	delete(executedAt, h-executedWindow)
+}
+
+// Attach the state hash of the application to a proposal.
+func attachAppHash(msg *message.HotStuffMessage) {
+	if executedHeight > 0 {
+		msg.AppHeight = executedHeight
+		msg.AppHash = appHashes[executedHeight]
+	}
+}
+
+// Compare the state hash of a proposal with the one of this replica.
+func checkAppHash(content message.HotStuffMessage) {
+	ours, exist := appHashes[content.AppHeight]
+	if content.AppHeight == 0 || !exist || bytes.Equal(ours, content.AppHash) {
+		return
+	}
+	p := fmt.Sprintf("[App Error] replica %d has the state hash %x after block %d, this replica has %x",
+		content.Source, content.AppHash, content.AppHeight, ours)
+	logging.PrintLog(true, logging.ErrorLog, p)
+}
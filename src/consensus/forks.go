@@ -0,0 +1,168 @@
+package consensus
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sleepy-hotstuff/src/app"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/message"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var forkAuditFile = "./etc/output/forkAudit_%d.json"
+
+var ErrNoAuditor = errors.New("[Audit Error] the application does not audit forks")
+
+// The audits are made by the core, which rebuilds all the branches; the last one is kept for
+// the requests that wait for it.
+var audits struct {
+	done int
+	last ForkAudit
+	sync.Mutex
+}
+
+var auditSignal signal // an audit was made
+
+// The fork audit of a replica, with the modes that decide whether it can double-spend.
+type ForkAudit struct {
+	Replica      int64              `json:"replica"`
+	Test         int                `json:"test"`
+	PersistLevel int                `json:"persistLevel"`
+	Sleepy       bool               `json:"sleepy"`
+	RecMode      config.RecModeType `json:"recMode"`
+	Height       int                `json:"height"` // last executed height
+	Branches     int                `json:"branches"`
+	Report       interface{}        `json:"report"`
+}
+
+// The branches of the proposals in receivedBlocksSet, one for every proposal that is not a parent.
+func receivedBranches() []app.Branch {
+	blocks := make(map[string]app.BranchBlock)
+	parents := make(map[string]string)
+	isParent := make(map[string]bool)
+	receivedBlocksSet.Range(func(key, value interface{}) bool {
+		msg := message.DeserializeHotStuffMessage(value.([]byte))
+		coinbase := message.Transaction{From: "", To: strconv.FormatInt(msg.Source, 10), Value: app.CoinbaseReward}
+		cbser, _ := coinbase.Serialize()
+		b := app.BranchBlock{Height: msg.Seq, Hash: msg.Hash, Proposer: msg.Source, Txs: [][]byte{cbser}}
+		for i := range msg.OPS {
+			if len(msg.OPS[i].GetMsg()) == 0 {
+				continue
+			}
+			b.Txs = append(b.Txs, txOf(msg.OPS[i].GetMsg()))
+		}
+		// in data-availability mode, the batches that were not received are left out.
+		for _, cert := range msg.Batches {
+			if batch, exist := batchStore.Batch(cert.Digest); exist {
+				for _, request := range batch.Requests {
+					b.Txs = append(b.Txs, txOf(request))
+				}
+			}
+		}
+		hash := hex.EncodeToString(msg.Hash)
+		blocks[hash] = b
+		if len(msg.QC) > 0 {
+			parent := hex.EncodeToString(message.DeserializeQCBlock(msg.QC).Hash)
+			parents[hash] = parent
+			isParent[parent] = true
+		}
+		return true
+	})
+
+	var tips []string
+	for hash := range blocks {
+		if !isParent[hash] {
+			tips = append(tips, hash)
+		}
+	}
+	sort.Strings(tips)
+	var branches []app.Branch
+	for _, tip := range tips {
+		var chain []app.BranchBlock
+		// proposals are received once, but a faulty leader could build a cycle.
+		seen := make(map[string]bool)
+		for hash := tip; !seen[hash]; hash = parents[hash] {
+			b, exist := blocks[hash]
+			if !exist {
+				break
+			}
+			seen[hash] = true
+			chain = append(chain, b)
+		}
+		sort.Slice(chain, func(i, j int) bool { return chain[i].Height < chain[j].Height })
+		branches = append(branches, app.Branch{Tip: blocks[tip].Hash, Blocks: chain})
+	}
+	return branches
+}
+
+// AuditForks has the core audit the received forks, and returns the audit, which is also
+// written to etc/output/forkAudit_[id].json. The audit rebuilds every branch, so it is only made
+// on request.
+func AuditForks(ctx context.Context) (ForkAudit, error) {
+	if _, ok := app.Current().(app.ForkAuditor); !ok {
+		return ForkAudit{}, ErrNoAuditor
+	}
+	audits.Lock()
+	before := audits.done
+	audits.Unlock()
+	submit(Event{Type: AuditEvent})
+	for {
+		done := auditSignal.C()
+		audits.Lock()
+		if audits.done > before {
+			audit := audits.last
+			audits.Unlock()
+			return audit, nil
+		}
+		audits.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ForkAudit{}, ctx.Err()
+		}
+	}
+}
+
+// Audit the received forks, if the application checks forks, and write the report to
+// etc/output/forkAudit_[id].json.
+func auditForks() {
+	auditor, ok := app.Current().(app.ForkAuditor)
+	if !ok {
+		return
+	}
+	branches := receivedBranches()
+	testid, _ := config.FetchTestTypeAndParam()
+	audit := ForkAudit{
+		Replica:      id,
+		Test:         int(testid),
+		PersistLevel: config.PersistLevel(),
+		Height:       executedHeight,
+		Branches:     len(branches),
+		Report:       auditor.AuditForks(branches),
+	}
+	sleepy, param := ParamOfSleepyReplica(id)
+	audit.Sleepy = sleepy
+	audit.RecMode = param.RecMode
+	audits.Lock()
+	audits.done++
+	audits.last = audit
+	audits.Unlock()
+	auditSignal.Broadcast()
+
+	jsonData, err := json.MarshalIndent(audit, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling the fork audit to JSON: %v", err)
+		return
+	}
+	err = ioutil.WriteFile(fmt.Sprintf(forkAuditFile, id), jsonData, 0644)
+	if err != nil {
+		log.Printf("Error writing the fork audit to file: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package consensus
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+)
+
+// Bytes of the requests of a forwarding message, below the 4 MB limit of gRPC messages.
+const forwardChunk = 1 << 20
+
+/*
+Requests are forwarded between replicas in a HotStuff message of type WRITE_BATCH, with the
+requests in OPS. A replica forwards the requests it receives from clients to the leaders of the
+current and next views, and again to the new leaders after a view change, until they are
+proposed. A sleeping replica keeps the requests it receives and forwards them once awake.
+*/
+
+// Forward the requests received from clients and not forwarded yet.
+func forwardRequests() {
+	// in data-availability mode, the replicas broadcast the requests in batches instead.
+	if !config.ForwardRequests() || consensus != HotStuff || daMode() || !awake() {
+		return
+	}
+	requests := pool.Forward()
+	if len(requests) == 0 {
+		return
+	}
+	v := LocalView()
+	dests := []int64{int64(LeaderID(v))}
+	if next := int64(LeaderID(v + 1)); next != dests[0] {
+		dests = append(dests, next)
+	}
+	for len(requests) > 0 {
+		var ops []pb.RawMessage
+		bytes := 0
+		for len(requests) > 0 && (len(ops) == 0 || bytes+len(requests[0]) <= forwardChunk) {
+			ops = append(ops, pb.RawMessage{Msg: requests[0]})
+			bytes += len(requests[0])
+			requests = requests[1:]
+		}
+		msg := message.HotStuffMessage{
+			Mtype:  pb.MessageType_WRITE_BATCH,
+			Source: id,
+			View:   v,
+			OPS:    ops,
+			TS:     timestamp(),
+		}
+		msgbyte, err := msg.Serialize()
+		if err != nil {
+			logging.PrintLog(true, logging.ErrorLog, "[Mempool Error] Not able to serialize forwarded requests")
+			return
+		}
+		for _, dest := range dests {
+			if dest != id {
+				send(msgbyte, dest)
+			}
+		}
+	}
+	persist("mempool", &pool, db.PersistAll)
+}
+
+// Add the requests forwarded by another replica. They are known already when the client sent
+// them to this replica too, or when several replicas forwarded them.
+func handleForwarded(content message.HotStuffMessage) {
+	requests := make([][]byte, len(content.OPS))
+	for i := range content.OPS {
+		requests[i] = content.OPS[i].GetMsg()
+	}
+	now := eventTime
+	added := 0
+	for _, request := range checkTxs(requests) {
+		if _, err := pool.Add(request, now); err == nil {
+			added++
+		}
+	}
+	p := fmt.Sprintf("[Mempool] %d of %d requests forwarded by replica %d added", added, len(requests), content.Source)
+	logging.PrintLog(verbose, logging.NormalLog, p)
+	if added > 0 {
+		persist("mempool", &pool, db.PersistAll)
+	}
+}
@@ -8,14 +8,15 @@ import (
 	"io/ioutil"
 	"log"
 	"sleepy-hotstuff/src/communication"
-	"sleepy-hotstuff/src/communication/sender"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/cryptolib"
 	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
 	"sleepy-hotstuff/src/logging"
 	"sleepy-hotstuff/src/message"
 	pb "sleepy-hotstuff/src/proto/communication"
 	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/safety"
 	"sleepy-hotstuff/src/utils"
 	"strconv"
 	"sync"
@@ -28,6 +29,7 @@ var curBlock message.QCBlock //recently voted block
 var votedBlocks utils.IntByteMap
 var lockedBlock message.QCBlock //locked block
 var curHash utils.ByteValue     //current hash
+var safetyRules safety.Rules    //highest voted block and locked QC, checked before voting
 
 // it seems that awaitingDecision and awaitingDecisionCopy are almost only written and not read.
 // awaitingBlocks is read to fill the preHash and prepreHash,
@@ -40,16 +42,13 @@ var awaitingDecisionCopy utils.IntByteMap
 
 var committedBlocks utils.IntByteMap // record the committed block history.
 var receivedBlocksSet sync.Map // record all received block proposals (key: hash_string, value: serialized HotStuffMessage)
+var receivedBlocksFile = "./etc/output/receivedBlocks_%d.json" // written with the id of the replica
 
 var vcAwaitingVotes utils.IntIntMap
 var vcTime int64
 
 var timer *time.Timer // timer for view changes
 
-var cblock sync.Mutex
-var lqcLock sync.RWMutex
-var bufferLock sync.Mutex
-
 // A replica will only send out messages of a step if it enters the previous step
 var buffer utils.StringIntMap
 
@@ -64,6 +63,13 @@ var genesisTime int64
 var forcePrint bool
 
 func InitHotStuff(thisid int64) {
+	resetHotStuffState(thisid)
+	persistHotStuffState()
+}
+
+// Reset the in-memory state of hotstuff without touching the database,
+// which is what a replica loses when it crashes or falls asleep.
+func resetHotStuffState(thisid int64) {
 	buffer.Init()
 
 	t, p := config.FetchTestTypeAndParam()
@@ -75,30 +81,29 @@ func InitHotStuff(thisid int64) {
 
 	Sequence.Init()
 	InitView()
-	db.PersistValue("Sequence", &Sequence, db.PersistAll)
 	votedBlocks.Init()
-	db.PersistValue("votedBlocks", &votedBlocks, db.PersistAll)
 	awaitingBlocks.Init()
 	awaitingBlocksTXS.Init()
-	db.PersistValue("awaitingBlocks", &awaitingBlocks, db.PersistAll)
 	awaitingDecision.Init()
-	db.PersistValue("awaitingDecision", &awaitingDecision, db.PersistAll)
 	awaitingDecisionCopy.Init()
-	db.PersistValue("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
 
 	if committedBlocks.GetLen() == 0 {
 		committedBlocks.Init()
-		db.PersistValue("committedBlocks", &committedBlocks, db.PersistCritical)
 	}
-	SetView(0)
+	SetLeader(LeaderID(0) == iid)
 
 	timeoutBuffer.Init(n)
+	lastTimeout.msg = nil
 	recBuffer.Init()
 
 	curBlock = message.QCBlock{}
 	lockedBlock = message.QCBlock{}
 	curHash.Init()
+	safetyRules.Init()
 	vcAwaitingVotes.Init()
+	deferred = nil
+	proposedAt = nil
+	resetDA()
 
 	cryptolib.StartECDSA(thisid)
 
@@ -110,6 +115,17 @@ func InitHotStuff(thisid int64) {
 	//}
 }
 
+// Write the initial state of hotstuff to the database.
+func persistHotStuffState() {
+	persist("Sequence", &Sequence, db.PersistAll)
+	persist("votedBlocks", &votedBlocks, db.PersistAll)
+	persist("awaitingBlocks", &awaitingBlocks, db.PersistAll)
+	persist("awaitingDecision", &awaitingDecision, db.PersistAll)
+	persist("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
+	persist("committedBlocks", &committedBlocks, db.PersistCritical)
+	SetView(0)
+}
+
 func getTransactions(batch []pb.RawMessage) [][]byte {
 	txs := make([][]byte, len(batch))
 	for i := 0; i < len(batch); i++ {
@@ -120,17 +136,28 @@ func getTransactions(batch []pb.RawMessage) [][]byte {
 
 // This func is invoked by the leader to broadcast a new proposal,
 // so it may be invoked for many times by one node.
-func StartHotStuff(batch []pb.RawMessage) {
+// The requests of the block are chosen by the block policy.
+func StartHotStuff() {
+	var batch []pb.RawMessage
+	var certs []message.BatchCert
+	if daMode() {
+		certs = takeCerts()
+	} else {
+		batch = reapBatch()
+	}
+	log.Println("batchSize:", len(batch))
 	seq := Increment()
 	msg := message.HotStuffMessage{
-		Mtype:  pb.MessageType_QC,
-		Seq:    seq,
-		Source: id,
-		View:   LocalView(),
-		OPS:    batch,
-		TS:     utils.MakeTimestamp(),
-		Num:    quorum.NSize(),
+		Mtype:   pb.MessageType_QC,
+		Seq:     seq,
+		Source:  id,
+		View:    LocalView(),
+		OPS:     batch,
+		TS:      timestamp(),
+		Num:     quorum.NSize(),
+		Batches: certs,
 	}
+	attachAppHash(&msg)
 
 	msg.QC = FetchBlockInfo(seq)
 	//if curBlock.Height != 0 {
@@ -140,22 +167,28 @@ func StartHotStuff(batch []pb.RawMessage) {
 	//the hotstuff message.
 	// Unfortunately, the Seq is also used as the height of block, which are bugs needed to correct.
 	tmphash := msg.GetMsgHash()
+	prevHash := curHash.Get()
 	msg.Hash = ObtainCurHash(tmphash, seq) // Hash(curHash + Hash(seq+OPS))
 	curHash.Set(msg.Hash)
-	db.PersistValue("curHash", &curHash, db.PersistAll)
+	persist("curHash", &curHash, db.PersistAll)
 	awaitingBlocks.Insert(seq, msg.Hash)
+	recordProposal(seq)
 	txs := getTransactions(batch)
 	awaitingBlocksTXS.SetValue(seq, txs)
-	db.PersistValue("awaitingBlocks", &awaitingBlocks, db.PersistAll)
+	awaitingBatches[seq] = certs
+	persist("awaitingBlocks", &awaitingBlocks, db.PersistAll)
 	awaitingDecisionCopy.Insert(seq, msg.Hash)
-	db.PersistValue("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
+	persist("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
 
 	log.Printf("proposing block with height %d, awaiting %d blocks", seq, awaitingDecisionCopy.GetLen())
 
 	msgbyte, _ := msg.Serialize()
-	request, _ := message.SerializeWithSignature(id, msgbyte)
-	go HandleQCByteMsg(request) // this message is first ``received'' by the node itself.
-	sender.RBCByteBroadcast(msgbyte)
+	deliver(msgbyte)
+	if byzantine(EquivocateProposals) {
+		equivocate(msg, prevHash)
+		return
+	}
+	broadcast(msgbyte)
 }
 
 // Fetch the current block (can be used as the parent block).
@@ -166,9 +199,7 @@ func FetchBlockInfo(seq int) []byte {
 	}
 	var msg []byte
 	var err error
-	cblock.Lock()
 	msg, err = curBlock.Serialize()
-	cblock.Unlock()
 	if err != nil {
 		log.Printf("fail to serialize curblock")
 		return []byte("")
@@ -183,14 +214,16 @@ func GetSeq() int {
 
 func Increment() int {
 	Sequence.Increment()
-	db.PersistValue("Sequence", &Sequence, db.PersistAll)
+	seqSignal.Broadcast()
+	persist("Sequence", &Sequence, db.PersistAll)
 	return Sequence.Get()
 }
 
 func UpdateSeq(seq int) {
 	if seq > GetSeq() {
 		Sequence.Set(seq)
-		db.PersistValue("Sequence", &Sequence, db.PersistAll)
+		seqSignal.Broadcast()
+		persist("Sequence", &Sequence, db.PersistAll)
 		// log.Printf("update sequence to %v", Sequence.Get())
 	}
 }
@@ -243,21 +276,34 @@ func DeleteBuffer(key string, btype TypeOfBuffer) {
 	}
 }
 
+// HandleQCByteMsg takes a message of another replica, or of this one, to the core.
 func HandleQCByteMsg(inputMsg []byte) {
 	tmp := message.DeserializeMessageWithSignature(inputMsg)
-	input := tmp.Msg
 	//TODO: tmp.Sig should be verified.
+	content := message.DeserializeHotStuffMessage(tmp.Msg)
+	communication.SetLive(utils.Int64ToString(content.Source))
 
-	content := message.DeserializeHotStuffMessage(input)
+	// log.Printf("receive a %v msg from replica: %v at seq: %d", content.Mtype, content.Source, content.Seq)
 
-	mtype := content.Mtype
-	source := content.Source
-	communication.SetLive(utils.Int64ToString(source))
-
-	// log.Printf("receive a %v msg from replica: %v at seq: %d", mtype, source, content.Seq)
+	if curStatus.Get() == SLEEPING {
+		return
+	}
+	evidence.Check(content, tmp)
+	if content.Mtype == pb.MessageType_TQC {
+		for i := 0; i < len(content.V); i++ {
+			evidence.Check(message.DeserializeHotStuffMessage(content.V[i].Msg), content.V[i])
+		}
+	}
+	if content.Mtype == pb.MessageType_QCREP {
+		// the signature is verified with other votes by the vote verifier, which then submits the vote
+		submitVote(content)
+		return
+	}
+	submit(Event{Type: MessageEvent, Msg: content, Signed: tmp})
+}
 
-	sleepLock.RLock()
-	defer sleepLock.RUnlock()
+func handleMessage(content message.HotStuffMessage, tmp message.MessageWithSignature) {
+	mtype := content.Mtype
 	if curStatus.Get() == SLEEPING {
 		return
 	}
@@ -270,8 +316,6 @@ func HandleQCByteMsg(inputMsg []byte) {
 	switch mtype {
 	case pb.MessageType_QC:
 		HandleNormalMsg(content)
-	case pb.MessageType_QCREP:
-		HandleNormalRepMsg(content)
 	case pb.MessageType_TIMEOUT:
 		HandleTimeoutMsg(content, tmp)
 	case pb.MessageType_TQC:
@@ -288,6 +332,12 @@ func HandleQCByteMsg(inputMsg []byte) {
 		HandleRec2Msg(content)
 	case pb.MessageType_ECHO2:
 		HandleEcho2Msg(content)
+	case pb.MessageType_WRITE_BATCH:
+		handleForwarded(content)
+	case pb.MessageType_BROADCAST:
+		handleBroadcast(content, tmp)
+	case pb.MessageType_RECONSTRUCT:
+		handleReconstruct(content)
 	}
 }
 
@@ -310,7 +360,7 @@ func VerifyBlock(curSeq int, source int64, blockinfo message.QCBlock) bool {
 		return true
 	}
 
-	if blockinfo.Height < lockedBlock.Height {
+	if safetyRules.SafeToExtend(blockinfo.Height) != nil {
 		return false
 	}
 
@@ -326,8 +376,6 @@ func VerifyBlock(curSeq int, source int64, blockinfo message.QCBlock) bool {
 }
 
 func HandleNormalMsg(content message.HotStuffMessage) { //For replica to process proposals from the leader
-	viewMux.RLock()
-	defer viewMux.RUnlock()
 	if content.View < LocalView() || curStatus.Get() == VIEWCHANGE {
 		return
 	}
@@ -343,22 +391,21 @@ func HandleNormalMsg(content message.HotStuffMessage) { //For replica to process
 	if vcTime > 0 {
 		// this seems not to be ture in view 0,
 		//since vcTime is not assigned a value in view 0.
-		vcdTime := utils.MakeTimestamp()
+		vcdTime := timestamp()
 		log.Printf("processing block sequence %v, %v ms", content.Seq, vcdTime-vcTime)
 	}
 
 	if content.OPS != nil {
 		awaitingDecision.Insert(content.Seq, content.Hash)
-		db.PersistValue("awaitingDecision", &awaitingDecision, db.PersistAll)
+		persist("awaitingDecision", &awaitingDecision, db.PersistAll)
 		//dTime := utils.MakeTimestamp()
 		//diff,_ := utils.Int64ToInt(dTime - cTime)
 		//log.Printf("[%v] ++latency-1 for QCM %v ms", content.Seq, diff)
 		if !Leader() {
 			awaitingDecisionCopy.Insert(content.Seq, content.Hash)
-			db.PersistValue("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
+			persist("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
 		}
 	}
-	// TODO： content.Seq should also be checked whether it has been voted.
 	blockinfo := message.DeserializeQCBlock(content.QC)
 	if !VerifyBlock(content.Seq, content.Source, blockinfo) {
 		log.Printf("[QC] HotStuff Block with height %d not verified", blockinfo.Height)
@@ -366,6 +413,7 @@ func HandleNormalMsg(content message.HotStuffMessage) { //For replica to process
 		logging.PrintLog(true, logging.ErrorLog, p)
 		return
 	}
+	checkAppHash(content)
 	/*if content.OPS != nil{
 		dTime := utils.MakeTimestamp()
 		diff,_ := utils.Int64ToInt(dTime - cTime)
@@ -377,36 +425,44 @@ func HandleNormalMsg(content message.HotStuffMessage) { //For replica to process
 	// Store all received blocks in set (key: hash, no duplicates)
 	contentSer, _ := content.Serialize()
 	receivedBlocksSet.Store(hash, contentSer)
+	markProposed(content)
 
 	ProcessQCInfo(hash, blockinfo, content)
-	msg := message.HotStuffMessage{
-		Mtype:  pb.MessageType_QCREP,
-		Source: id,
-		View:   LocalView(),
-		Hash:   content.Hash,
-		Seq:    content.Seq,
+	if curStatus.Get() == RECOVERING {
+		// the proposal is replayed from the database, and it might have been voted before the crash.
+		return
 	}
-
-	sig := cryptolib.GenSig(id, content.Hash)
-	msg.Sig = sig // a signature for the voted block, not for the entire message
-
-	if !cryptolib.VerifySig(id, content.Hash, msg.Sig) {
-		p := fmt.Sprintf("%d can not verify its newly generated sig!", id)
+	if byzantine(WithholdVotes) {
+		return
+	}
+	if err := checkBlock(content); err != nil {
+		p := fmt.Sprintf("[QC] refuse to vote for block %d in view %d: %v", content.Seq, content.View, err)
 		logging.PrintLog(true, logging.ErrorLog, p)
 		return
 	}
-
-	msgbyte, err := msg.Serialize()
+	// the vote is persisted before the vote action, so that it survives a restart.
+	err := safetyRules.Vote(content.View, content.Seq, content.Hash)
 	if err != nil {
-		logging.PrintLog(true, logging.ErrorLog, "[QCMessage Error] Not able to serialize the message")
+		p := fmt.Sprintf("[QC] refuse to vote for block %d in view %d: %v", content.Seq, content.View, err)
+		logging.PrintLog(true, logging.ErrorLog, p)
 		return
 	}
-	msgwithsig, _ := message.SerializeWithSignature(id, msgbyte)
-	if Leader() {
-		// the message is first received by the leader itself.
-		go HandleQCByteMsg(msgwithsig)
+	persist(safety.DBKey, &safetyRules, db.PersistCritical)
+	votedBlocks.Insert(content.Seq, content.Hash)
+	persist("votedBlocks", &votedBlocks, db.PersistAll)
+
+	// the vote is for the view of the block, as recorded by the safety rules.
+	msg := message.HotStuffMessage{
+		Mtype:  pb.MessageType_QCREP,
+		Source: id,
+		View:   content.View,
+		Hash:   content.Hash,
+		Seq:    content.Seq,
+	}
+	emit(Action{Type: VoteAction, To: source, Vote: msg})
+	if byzantine(ConflictingVotes) {
+		voteConflicting(msg, source)
 	}
-	go sender.SendToNode(msgbyte, source, message.HotStuff)
 }
 
 // it seems that this func is useless, since queueHead is not set to a value in another place.
@@ -701,7 +757,7 @@ func saveReceivedBlocksToFile() error {
 		return err
 	}
 
-	filename := fmt.Sprintf("./etc/output/receivedBlocks_%d.json", id)
+	filename := fmt.Sprintf(receivedBlocksFile, id)
 	err = ioutil.WriteFile(filename, jsonData, 0644)
 	if err != nil {
 		log.Printf("Error writing receivedBlocks to file: %v", err)
@@ -718,7 +774,6 @@ func ProcessQCInfo(hash string, blockinfo message.QCBlock, content message.HotSt
 		}
 		if blockinfo.Height >= 3 {
 			// deliver/commit block
-			lqcLock.RLock()
 			blockser, _ := lockedBlock.Serialize()
 			if blockinfo.Height >= lockedBlock.Height+2 {
 				// Bug: for the leader, blockinfo.height = lockedBlock.height+1,
@@ -737,35 +792,33 @@ func ProcessQCInfo(hash string, blockinfo message.QCBlock, content message.HotSt
 				go saveReceivedBlocksToFile()
 			}
 			// log.Printf("blockinfo height: %d, lockedblock height: %d, curBlock height: %d", blockinfo.Height, lockedBlock.Height, curBlock.Height)
-			lqcLock.RUnlock()
-			db.PersistValue("committedBlocks", &committedBlocks, db.PersistCritical)
+			persist("committedBlocks", &committedBlocks, db.PersistCritical)
 		}
-		lqcLock.Lock()
 		lockedBlock = curBlock // why curBlock is exactly blockinfo's parent?
 		// todo: for leader, this lockedBlock is wrongly updated to the prepared block.
 		// This is because the curBlock has been updated to blockinfo.
 		// The needed modification may be complex, since we need to set the lockedblock to
 		//the exact parent block of curblock when updating curblock.
-		db.PersistValue("lockedBlock", &lockedBlock, db.PersistCritical)
-		lqcLock.Unlock()
+		persist("lockedBlock", &lockedBlock, db.PersistCritical)
+		if safetyRules.UpdateLock(lockedBlock.View, lockedBlock.Height, lockedBlock.Hash) {
+			persist(safety.DBKey, &safetyRules, db.PersistCritical)
+		}
 		votedBlocks.Delete(curBlock.Height)
-		db.PersistValue("votedBlocks", &votedBlocks, db.PersistAll)
+		persist("votedBlocks", &votedBlocks, db.PersistAll)
 	}
 	if !Leader() && blockinfo.Height > curBlock.Height {
-		cblock.Lock()
 		curBlock = blockinfo
-		db.PersistValue("curBlock", &curBlock, db.PersistAll)
-		cblock.Unlock()
+		persist("curBlock", &curBlock, db.PersistAll)
 		curHash.Set(curBlock.Hash)
-		db.PersistValue("curHash", &curHash, db.PersistAll)
+		persist("curHash", &curHash, db.PersistAll)
 	}
 
 	if content.Seq > 3 {
 		//awaitingBlocks.Delete(content.Seq-3)
 		awaitingDecision.Delete(content.Seq - 3)
-		db.PersistValue("awaitingDecision", &awaitingDecision, db.PersistAll)
+		persist("awaitingDecision", &awaitingDecision, db.PersistAll)
 		awaitingDecisionCopy.Delete(content.Seq - 3)
-		db.PersistValue("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
+		persist("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
 	}
 
 	if curBlock.PrePreHash != nil {
@@ -779,11 +832,7 @@ func ProcessQCInfo(hash string, blockinfo message.QCBlock, content message.HotSt
 			log.Printf("deliver block height %v, %v ms", content.Seq-3, vcdTime - vcTime)
 		}*/
 		//log.Printf("***[%v] deliver request, curSeq %v", content.Seq-3, sequence.GetSeq())
-		if config.EvalMode() == 0 {
-			//todo deliver block
-		} else {
-
-		}
+		// the committed blocks are delivered to the application at the end of the step, see executeCommitted.
 
 		if content.OPS != nil {
 			go HandleQueue(ch, content.OPS)
@@ -792,9 +841,11 @@ func ProcessQCInfo(hash string, blockinfo message.QCBlock, content message.HotSt
 	UpdateSeq(content.Seq)
 }
 
-func HandleNormalRepMsg(content message.HotStuffMessage) {
-	viewMux.RLock()
-	defer viewMux.RUnlock()
+// Add a vote whose signature has been verified to the quorum of its block.
+func addVote(content message.HotStuffMessage) {
+	if curStatus.Get() == SLEEPING || curStatus.Get() == RECOVERING {
+		return
+	}
 	if content.View < LocalView() || curStatus.Get() == VIEWCHANGE {
 		return
 	}
@@ -802,19 +853,12 @@ func HandleNormalRepMsg(content message.HotStuffMessage) {
 	h, exist := awaitingBlocks.Get(content.Seq)
 	// the block of Seq must be the one 'I' proposed
 	if exist && bytes.Compare(h, content.Hash) != 0 {
-		p := fmt.Sprintf("[QC] hash not matching", content.Seq)
-		logging.PrintLog(true, logging.ErrorLog, p)
-		return
-	}
-	if !cryptolib.VerifySig(content.Source, content.Hash, content.Sig) {
-		p := fmt.Sprintf("[QC] signature for QCRep with height %v not verified", content.Seq)
+		p := fmt.Sprintf("[QC] hash not matching for block %d", content.Seq)
 		logging.PrintLog(true, logging.ErrorLog, p)
 		return
 	}
 	hash := utils.BytesToString(content.Hash)
 
-	bufferLock.Lock()
-	defer bufferLock.Unlock()
 	// check that if there has existed a prepare qc for the block of hash.
 	v, _ := GetBufferContent("BLOCK"+hash, BUFFER)
 	if v == PREPARED {
@@ -822,23 +866,25 @@ func HandleNormalRepMsg(content message.HotStuffMessage) {
 		return
 	}
 
-	quorum.Add(content.Source, hash, content.Sig, quorum.PP)
-	if quorum.CheckQuorum(hash, quorum.PP) {
+	// votes are signed for their view and height too, so only votes for the same ones form a QC
+	key := fmt.Sprintf("%d/%d/%s", content.View, content.Seq, hash)
+	quorum.Add(content.Source, key, content.Sig, quorum.PP)
+	if quorum.CheckQuorum(key, quorum.PP) {
 		UpdateBufferContent("BLOCK"+hash, PREPARED, BUFFER)
+		extendLease(content.Seq)
 
-		cer_byte := quorum.FetchCer(hash)
+		cer_byte := quorum.FetchCer(key)
 		if cer_byte == nil {
 			p := fmt.Sprintf("[QC] cannnot obtain certificate from cache for block %v", content.Seq)
 			logging.PrintLog(verbose, logging.ErrorLog, p)
 		}
 		_, sigs, ids := message.DeserializeSignatures(cer_byte)
 
+		// the view and height are the voted ones, which the signatures of the QC cover
 		qcblock := message.QCBlock{
-			View: LocalView(),
-			// Height: content.Seq,
-			Height: curBlock.Height + 1, // this is a temporary solution,
-			// since curBlock might not be the parent block of this qcblock (I'm not sure).
-			QC:   sigs,
+			View:   content.View,
+			Height: content.Seq,
+			QC:     sigs,
 			IDs:  ids,
 			Hash: content.Hash,
 		}
@@ -866,7 +912,7 @@ func HandleNormalRepMsg(content message.HotStuffMessage) {
 			cr := message.ClientRequest{
 				ID: id,
 				OP: btxser,
-				TS: utils.MakeTimestamp(),
+				TS: timestamp(),
 			}
 			crser, _ := cr.Serialize()
 			qcblock.TXS[0] = message.MessageWithSignature{
@@ -876,12 +922,11 @@ func HandleNormalRepMsg(content message.HotStuffMessage) {
 				qcblock.TXS[i] = message.DeserializeMessageWithSignature(txs[i-1])
 			}
 		}
+		qcblock.Batches = awaitingBatches[content.Seq]
 
 		if qcblock.Height > curBlock.Height {
-			cblock.Lock()
 			curBlock = qcblock
-			db.PersistValue("curBlock", &curBlock, db.PersistAll)
-			cblock.Unlock()
+			persist("curBlock", &curBlock, db.PersistAll)
 		} else {
 			log.Printf("New block's QC has Seq: %v <= curBlock.Height: %v", content.Seq, curBlock.Height)
 		}
@@ -901,9 +946,9 @@ func evaluation(lenOPS int, seq int) {
 	defer clock.Unlock()
 	val := curOPS.Get()
 	if seq == 1 {
-		beginTime = utils.MakeTimestamp()
+		beginTime = timestamp()
 		lastTime = beginTime
-		genesisTime = utils.MakeTimestamp()
+		genesisTime = timestamp()
 	}
 
 	tval := totalOPS.Get()
@@ -920,14 +965,14 @@ func evaluation(lenOPS int, seq int) {
 	//the time of the leader packing and sending the block i, where latency(i) is the evaluated value.
 	if val+lenOPS >= config.MaxBatchSize() {
 		curOPS.Set(0)
-		var endTime = utils.MakeTimestamp()
+		var endTime = timestamp()
 		var throughput int
 		lat, _ := utils.Int64ToInt(endTime - beginTime)
 		if lat > 0 {
 			throughput = 1000 * (val + lenOPS) / lat // tx/s
 		}
 
-		clockTime, _ := utils.Int64ToInt(utils.MakeTimestamp() - genesisTime)
+		clockTime, _ := utils.Int64ToInt(timestamp() - genesisTime)
 		log.Printf("[Replica] Processed %d (ops=%d, clockTime=%d ms, seq=%v) operations using %d ms. "+
 			"Throughput %d tx/s. ", tval, lenOPS, clockTime, seq, lat, throughput)
 		var p = fmt.Sprintf("[Replica] Processed %d (ops=%d, clockTime=%d ms, seq=%v) operations using %d ms. "+
@@ -0,0 +1,158 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/journal"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The journal of the events taken by the core and of the messages sent, if enabled in conf.json.
+var msgJournal *journal.Journal
+
+var eventNames = map[EventType]string{
+	MessageEvent:   "message",
+	VoteEvent:      "vote",
+	RequestEvent:   "request",
+	TimeoutEvent:   "timeout",
+	RecoveredEvent: "recovered",
+	SleepEvent:     "sleep",
+	WakeEvent:      "wake",
+	EvidenceEvent:  "evidence",
+	AuditEvent:     "audit",
+}
+
+func startJournal() {
+	if !config.Journal() {
+		return
+	}
+	dir, err := logging.LogDir()
+	if err == nil {
+		msgJournal, err = journal.Open(filepath.Join(dir, "journal"), config.JournalPayloads(),
+			int64(config.JournalSize())<<20, config.JournalFiles())
+	}
+	if err != nil {
+		log.Printf("[Journal Error] cannot open the journal: %v", err)
+	}
+}
+
+func hashOf(payload []byte) string {
+	return hex.EncodeToString(cryptolib.GenHash(payload))
+}
+
+// The journal entry of an event.
+func eventEntry(ev Event) journal.Entry {
+	e := journal.Entry{Dir: journal.In, Peer: id, Event: eventNames[ev.Type], View: ev.View, RecMode: int(ev.RecMode)}
+	if !ev.Now.IsZero() {
+		e.Time = ev.Now.UnixMilli()
+	}
+	var payload []byte
+	switch ev.Type {
+	case MessageEvent:
+		payload, _ = ev.Signed.Serialize()
+	case VoteEvent, RecoveredEvent:
+		payload, _ = ev.Msg.Serialize()
+	case RequestEvent:
+		payload, _ = msgpack.Marshal(ev.Requests)
+	}
+	if ev.Type == MessageEvent || ev.Type == VoteEvent {
+		e.Peer = ev.Msg.Source
+		e.Type = ev.Msg.Mtype.String()
+		e.View = ev.Msg.View
+		e.Seq = ev.Msg.Seq
+	}
+	if payload != nil {
+		e.Hash = hashOf(payload)
+		e.Payload = payload
+	}
+	return e
+}
+
+// The journal entry of an action sending a message, if it is one, at the time of the event
+// that led to it.
+func actionEntry(a Action) (journal.Entry, bool) {
+	var payload []byte
+	e := journal.Entry{Dir: journal.Out, Time: timestamp(), Event: eventNames[MessageEvent], Peer: a.To}
+	switch a.Type {
+	case SendAction:
+		payload = a.Msg
+	case BroadcastAction, ReliableAction:
+		payload = a.Msg
+		e.Peer = journal.AllPeers
+	case VoteAction:
+		payload, _ = a.Vote.Serialize()
+		e.Event = eventNames[VoteEvent]
+	default:
+		return e, false
+	}
+	content := message.DeserializeHotStuffMessage(payload)
+	e.Type = content.Mtype.String()
+	e.View = content.View
+	e.Seq = content.Seq
+	e.Hash = hashOf(payload)
+	e.Payload = payload
+	return e, true
+}
+
+func journalEvent(ev Event) {
+	if msgJournal == nil {
+		return
+	}
+	if err := msgJournal.Write(eventEntry(ev)); err != nil {
+		log.Printf("[Journal Error] %v", err)
+	}
+}
+
+func journalActions(list []Action) {
+	if msgJournal == nil {
+		return
+	}
+	for _, a := range list {
+		if e, ok := actionEntry(a); ok {
+			if err := msgJournal.Write(e); err != nil {
+				log.Printf("[Journal Error] %v", err)
+			}
+		}
+	}
+}
+
+// EventOf rebuilds the event of an input entry of the journal.
+func EventOf(e journal.Entry) (Event, error) {
+	var ev Event
+	found := false
+	for t, name := range eventNames {
+		if name == e.Event {
+			ev.Type = t
+			found = true
+		}
+	}
+	if e.Dir != journal.In || !found {
+		return ev, fmt.Errorf("[Journal Error] %s %s is not an event of the core", e.Dir, e.Event)
+	}
+	ev.View = e.View
+	ev.RecMode = config.RecModeType(e.RecMode)
+	// the core takes the time of the event, so the replay runs on the clock of the journal.
+	ev.Now = time.UnixMilli(e.Time)
+	if e.Payload == nil && e.Hash != "" {
+		return ev, errors.New("[Journal Error] the journal has no payloads, enable journalPayloads to replay it")
+	}
+	switch ev.Type {
+	case MessageEvent:
+		ev.Signed = message.DeserializeMessageWithSignature(e.Payload)
+		ev.Msg = message.DeserializeHotStuffMessage(ev.Signed.Msg)
+	case VoteEvent, RecoveredEvent:
+		ev.Msg = message.DeserializeHotStuffMessage(e.Payload)
+	case RequestEvent:
+		ev.Requests = DeserializeRequests(e.Payload)
+	}
+	return ev, nil
+}
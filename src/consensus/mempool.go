@@ -0,0 +1,273 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/da"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/mempool"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/utils"
+	"sort"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var pool mempool.Mempool // client requests waiting to be committed
+var evictedHeight int    // height of the last committed block removed from the pool
+var lastExpiry time.Time
+var policy mempool.Policy // choice of the requests of the blocks proposed by this replica
+
+func init() {
+	db.RegisterMigration(db.Migration{From: 1, Name: "client requests in a mempool", Apply: migrateQueue})
+}
+
+func mempoolLimits() mempool.Limits {
+	return mempool.Limits{
+		MaxTxs:      config.MempoolSize(),
+		MaxBytes:    config.MempoolBytes() * 1024 * 1024,
+		ClientQuota: config.ClientQuota(),
+		TTL:         time.Duration(config.MempoolTTL()) * time.Second,
+		MaxTxSize:   config.MaxTxSize(),
+	}
+}
+
+// The limits of a block, for the blocks proposed and the ones voted for.
+func blockLimits() mempool.BlockLimits {
+	return mempool.BlockLimits{
+		MaxTxs:    config.MaxBatchSize(),
+		MaxBytes:  config.MaxBlockBytes(),
+		MaxTxSize: config.MaxTxSize(),
+	}
+}
+
+func initMempool() {
+	pool.Init(mempoolLimits())
+	evictedHeight = 0
+	lastExpiry = time.Time{}
+	var err error
+	policy, err = mempool.NewPolicy(config.BlockPolicy())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Add the requests of clients accepted by the application to the pool.
+func addRequests(requests [][]byte) {
+	now := eventTime
+	for _, request := range checkTxs(requests) {
+		if _, err := pool.AddLocal(request, now); err != nil {
+			p := fmt.Sprintf("[Mempool] request refused: %v", err)
+			logging.PrintLog(verbose, logging.ErrorLog, p)
+		}
+	}
+	persist("mempool", &pool, db.PersistAll)
+}
+
+// The batch of the next proposal.
+func reapBatch() []pb.RawMessage {
+	requests := pool.Reap(policy, blockLimits())
+	batch := make([]pb.RawMessage, len(requests))
+	for i := range requests {
+		batch[i].Msg = requests[i]
+	}
+	persist("mempool", &pool, db.PersistAll)
+	return batch
+}
+
+// The requests of a proposal of another leader are not proposed again, unless the view changes.
+func markProposed(content message.HotStuffMessage) {
+	var hashes []string
+	for i := range content.OPS {
+		hash, _ := mempool.HashOf(content.OPS[i].GetMsg())
+		hashes = append(hashes, hash)
+	}
+	pool.MarkProposed(hashes)
+	if len(content.Batches) > 0 {
+		batchStore.MarkProposed(content.Batches)
+		persist("batches", &batchStore, db.PersistAll)
+	}
+}
+
+// The proposals received from the block hash down to the last committed block removed from the
+// pool, the block itself first. Their requests are committed with the first of their descendants.
+func uncommittedBranch(hash []byte) []message.HotStuffMessage {
+	var branch []message.HotStuffMessage
+	// proposals are received once, but a faulty leader could build a cycle.
+	seen := make(map[string]bool)
+	for len(hash) > 0 && !seen[utils.BytesToString(hash)] {
+		seen[utils.BytesToString(hash)] = true
+		v, exist := receivedBlocksSet.Load(utils.BytesToString(hash))
+		if !exist {
+			break
+		}
+		b := message.DeserializeHotStuffMessage(v.([]byte))
+		if b.Seq <= evictedHeight {
+			break
+		}
+		branch = append(branch, b)
+		hash = nil
+		if len(b.QC) > 0 {
+			hash = message.DeserializeQCBlock(b.QC).Hash
+		}
+	}
+	return branch
+}
+
+// The requests and the certificates of the proposals of a branch, by hash and key of the digest.
+func branchContent(branch []message.HotStuffMessage) ([]string, []message.BatchCert) {
+	var hashes []string
+	var certs []message.BatchCert
+	for _, b := range branch {
+		for i := range b.OPS {
+			hash, _ := mempool.HashOf(b.OPS[i].GetMsg())
+			hashes = append(hashes, hash)
+		}
+		certs = append(certs, b.Batches...)
+	}
+	return hashes, certs
+}
+
+// Check that a proposal respects the limits of a block, that its batches are available, and
+// that it does not repeat the requests or the batches of its uncommitted ancestors, before voting for it.
+func checkBlock(content message.HotStuffMessage) error {
+	requests := make([][]byte, len(content.OPS))
+	for i := range content.OPS {
+		requests[i] = content.OPS[i].GetMsg()
+	}
+	if err := mempool.CheckBlock(requests, blockLimits()); err != nil {
+		return err
+	}
+	for i := range content.Batches {
+		if err := da.VerifyCert(content.Batches[i], quorum.FSize()+1); err != nil {
+			return err
+		}
+	}
+	if len(content.QC) == 0 {
+		return nil
+	}
+	hashes, certs := branchContent(uncommittedBranch(message.DeserializeQCBlock(content.QC).Hash))
+	proposed := make(map[string]bool)
+	for _, hash := range hashes {
+		proposed[hash] = true
+	}
+	for _, cert := range certs {
+		proposed[da.Key(cert.Digest)] = true
+	}
+	for i := range requests {
+		if hash, _ := mempool.HashOf(requests[i]); proposed[hash] {
+			return fmt.Errorf("[Mempool Error] request %s is in an uncommitted ancestor", hash)
+		}
+	}
+	for i := range content.Batches {
+		if key := da.Key(content.Batches[i].Digest); proposed[key] {
+			return fmt.Errorf("[DA Error] batch %s is in an uncommitted ancestor", key)
+		}
+	}
+	return nil
+}
+
+// Remove the requests of the blocks committed since the last step.
+func evictCommitted() {
+	var heights []int
+	for h := range committedBlocks.GetAll() {
+		if h > evictedHeight {
+			heights = append(heights, h)
+		}
+	}
+	if len(heights) == 0 {
+		return
+	}
+	sort.Ints(heights)
+	now := eventTime
+	for _, h := range heights {
+		bser, _ := committedBlocks.Get(h)
+		b := message.DeserializeQCBlock(bser)
+		txs, complete := blockTxs(b)
+		if !complete {
+			break
+		}
+		var hashes []string
+		// the first transaction is the coinbase of the leader.
+		for i := 1; i < len(txs); i++ {
+			hashes = append(hashes, mempool.HashOfSigned(txs[i]))
+		}
+		pool.Commit(hashes, now)
+		batchStore.Commit(b.Batches, now)
+		evictedHeight = h
+	}
+	persist("mempool", &pool, db.PersistAll)
+	persist("batches", &batchStore, db.PersistAll)
+}
+
+// The blocks proposed in earlier views may never be committed: their requests are proposed again,
+// and forwarded to the new leaders. In data-availability mode, their certificates are proposed again.
+// The blocks of the branch of the highest QC are committed with its descendants, so their requests
+// and certificates stay proposed.
+func requeueRequests() {
+	pool.Reforward()
+	hashes, certs := branchContent(uncommittedBranch(curBlock.Hash))
+	if daMode() {
+		if num := batchStore.Requeue(certs); num > 0 {
+			p := fmt.Sprintf("[DA] %d uncommitted batches proposed again after the view change", num)
+			logging.PrintLog(verbose, logging.NormalLog, p)
+		}
+		return
+	}
+	if num := pool.Requeue(hashes); num > 0 {
+		p := fmt.Sprintf("[Mempool] %d uncommitted requests proposed again after the view change", num)
+		logging.PrintLog(verbose, logging.NormalLog, p)
+	}
+}
+
+// Remove the expired requests, at most once a second.
+func expireRequests() {
+	now := eventTime
+	if now.Sub(lastExpiry) < time.Second {
+		return
+	}
+	lastExpiry = now
+	batchStore.Expire(now)
+	if num := pool.Expire(now); num > 0 {
+		p := fmt.Sprintf("[Mempool] %d requests expired", num)
+		logging.PrintLog(verbose, logging.NormalLog, p)
+		persist("mempool", &pool, db.PersistAll)
+	}
+}
+
+// Schema version 1 to 2: the queue of client requests is replaced by the mempool.
+// The other values keep their layout.
+func migrateQueue(m *db.Migrator) error {
+	data, err := m.Get("queue")
+	if err == db.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var q Queue
+	if err := msgpack.Unmarshal(data, &q); err != nil {
+		return err
+	}
+	var p mempool.Mempool
+	p.Init(mempool.Limits{MaxTxs: len(q.Q) + 1, MaxBytes: len(data) + 1})
+	now := time.Now()
+	for i := range q.Q {
+		// the queue could hold a request twice; the mempool keeps it once.
+		if _, err := p.Add(q.Q[i].GetMsg(), now); err != nil && !errors.Is(err, mempool.ErrDuplicate) {
+			return fmt.Errorf("request %d of the queue: %v", i, err)
+		}
+	}
+	pser, err := p.Serialize()
+	if err != nil {
+		return err
+	}
+	m.Delete("queue")
+	return m.Put("mempool", pser)
+}
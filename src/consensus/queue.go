@@ -209,6 +209,6 @@ func (q *Queue) ClearFraction(size int) {
 
 func (q *Queue) PrintQueue() {
 	for i := 0; i < len(q.Q); i++ {
-		log.Println("Number %d: %s", i, q.Q[i].GetMsg())
+		log.Printf("Number %d: %s", i, q.Q[i].GetMsg())
 	}
 }
@@ -0,0 +1,85 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/app"
+	"sleepy-hotstuff/src/config"
+	"sync"
+	"time"
+)
+
+var proposedAt map[int]time.Time // when this replica proposed the block of each seq
+
+// The lease of the leader. Replicas that voted for a proposal stay in its view until their
+// rotating timer expires, so a leader whose proposal got a QC at most leaseTime ago is still
+// the only leader, given that clocks drift less than rotatingTime-leaseTime.
+var lease struct {
+	view  int
+	until time.Time
+	sync.Mutex
+}
+
+func recordProposal(seq int) {
+	if proposedAt == nil {
+		proposedAt = make(map[int]time.Time)
+	}
+	proposedAt[seq] = eventTime
+}
+
+// The proposal of seq got a QC in the current view: the lease runs from the time it was sent.
+func extendLease(seq int) {
+	at, exist := proposedAt[seq]
+	delete(proposedAt, seq)
+	if !exist || config.LeaseTime() <= 0 {
+		return
+	}
+	lease.Lock()
+	defer lease.Unlock()
+	until := at.Add(time.Duration(config.LeaseTime()) * time.Millisecond)
+	if lease.view != LocalView() || until.After(lease.until) {
+		lease.view = LocalView()
+		lease.until = until
+	}
+}
+
+// Whether the leader holds its lease at now. Reads are served outside the core, at the time
+// they arrive.
+func holdsLease(now time.Time) bool {
+	if curStatus.Get() == SLEEPING || curStatus.Get() == RECOVERING {
+		return false
+	}
+	lease.Lock()
+	defer lease.Unlock()
+	return lease.view == LocalView() && now.Before(lease.until)
+}
+
+// AwaitResponse waits until the request, a serialized MessageWithSignature, is executed, and
+// returns the result the application gives the client.
+func AwaitResponse(ctx context.Context, request []byte) ([]byte, error) {
+	responder, ok := app.Current().(app.Responder)
+	if !ok {
+		return nil, errors.New("[Read Error] the application gives no results to clients")
+	}
+	return responder.Response(txOf(request), ctx.Done())
+}
+
+// LocalRead serves a read request from the executed state of this replica. The state may be
+// stale; clients trust a value once f+1 replicas return it.
+func LocalRead(request []byte) ([]byte, error) {
+	a := app.Current()
+	if a == nil {
+		return nil, errors.New("[Read Error] no application")
+	}
+	return a.Query("read", txOf(request))
+}
+
+// LeaseRead serves a read request from the state of the leader, while it holds its lease.
+func LeaseRead(request []byte) ([]byte, error) {
+	if !holdsLease(time.Now()) {
+		v := LocalView()
+		return nil, fmt.Errorf("[Read Error] replica %d holds no lease in view %d, the leader is %d", id, v, LeaderID(v))
+	}
+	return LocalRead(request)
+}
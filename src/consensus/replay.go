@@ -0,0 +1,59 @@
+package consensus
+
+import (
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/journal"
+	"sleepy-hotstuff/src/utils"
+)
+
+// StartReplay sets up replica rid as StartHandler does, without network or timers, to replay
+// its journal. The configuration must be loaded and an empty database open. The blocks received
+// during the replay are written to etc/output/replay_receivedBlocks_[id].json, and the fork audit
+// to etc/output/replay_forkAudit_[id].json.
+func StartReplay(rid string) error {
+	var err error
+	id, err = utils.StringToInt64(rid)
+	if err != nil {
+		return err
+	}
+	iid, _ = utils.StringToInt(rid)
+	// the keys in etc/key verify the certificates; nothing is signed.
+	cryptolib.StartCrypto(id, config.CryptoOption())
+	consensus = ConsensusType(config.Consensus())
+	n = config.FetchNumReplicas()
+	verbose = config.FetchVerbose()
+	curStatus.Init()
+	epoch.Init()
+	midTime = make(map[int]int64)
+	initMempool()
+	initDA()
+	MsgQueue.Init()
+	receivedBlocksFile = "./etc/output/replay_receivedBlocks_%d.json"
+	forkAuditFile = "./etc/output/replay_forkAudit_%d.json"
+	startApplication()
+	InitHotStuff(id)
+	actions = nil
+	return nil
+}
+
+// Replay gives an input entry of the journal to Step at the time of the entry, and returns the
+// messages the replica sends in response, as journal entries.
+func Replay(e journal.Entry) ([]journal.Entry, error) {
+	ev, err := EventOf(e)
+	if err != nil {
+		return nil, err
+	}
+	var out []journal.Entry
+	for _, a := range Step(ev) {
+		if entry, ok := actionEntry(a); ok {
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
+
+// FinishReplay writes the blocks received during the replay.
+func FinishReplay() error {
+	return saveReceivedBlocksToFile()
+}
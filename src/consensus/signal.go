@@ -0,0 +1,61 @@
+/*
+Signals wake up the goroutines waiting for the state of consensus to change, instead of
+having them spin on it. A signal is a channel closed, and replaced, whenever the state it
+covers changes; a waiter takes the channel before checking its condition, so that a change
+in between is not missed.
+*/
+
+package consensus
+
+import (
+	"reflect"
+	"sync"
+)
+
+type signal struct {
+	ch chan struct{}
+	sync.Mutex
+}
+
+// The channel closed at the next change.
+func (s *signal) C() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+// Broadcast wakes up all the waiters.
+func (s *signal) Broadcast() {
+	s.Lock()
+	defer s.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+var statusSignal signal // curStatus changed
+var viewSignal signal   // the view changed
+var seqSignal signal    // the sequence number changed
+
+// Block until cond holds, checking it again whenever one of the signals is broadcast.
+func await(cond func() bool, signals ...*signal) {
+	cases := make([]reflect.SelectCase, len(signals))
+	for {
+		for i, s := range signals {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.C())}
+		}
+		if cond() {
+			return
+		}
+		reflect.Select(cases)
+	}
+}
+
+// Block until the local view is at least v.
+func awaitView(v int) {
+	await(func() bool { return LocalView() >= v }, &viewSignal)
+}
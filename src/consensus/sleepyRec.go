@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"sleepy-hotstuff/src/communication/sender"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/cryptolib"
 	"sleepy-hotstuff/src/db"
@@ -14,7 +13,6 @@ import (
 	pb "sleepy-hotstuff/src/proto/communication"
 	"sleepy-hotstuff/src/quorum"
 	"sleepy-hotstuff/src/utils"
-	"sync"
 	"time"
 )
 
@@ -23,8 +21,6 @@ var f int
 var gat bool
 var reqHash utils.ByteValue // record the latest recover request.
 var hView utils.IntValue
-var sleepLock sync.RWMutex
-var recLock sync.Mutex
 var recBuffer utils.StringIntMap
 
 func SleepyHotstuffConfig() (string, error) {
@@ -76,8 +72,6 @@ func RecoveryProcess(recMode config.RecModeType) error {
 		err := errors.New("[Recovery Error] The status before recovery is not SLEEPING!")
 		return err
 	}
-	recLock.Lock()
-	defer recLock.Unlock()
 	reqHash.Init()
 	hView.Set(-2) // init hView
 	curStatus.Set(RECOVERING)
@@ -88,14 +82,14 @@ func RecoveryProcess(recMode config.RecModeType) error {
 	case config.NoRec:
 		curStatus.Set(READY)
 		// queue.Append(utils.StringToBytes("empty tx"))
-		go RequestMonitor(0)
+		timerPending = true
 		log.Printf("recover to READY")
 		return nil
 	case config.RecKoala2:
 		msg := message.HotStuffMessage{
 			Mtype:  pb.MessageType_REC1,
 			Source: id,
-			TS:     utils.MakeTimestamp(),
+			TS:     timestamp(),
 		}
 		msgbyte, err := msg.Serialize()
 		if err != nil {
@@ -104,7 +98,7 @@ func RecoveryProcess(recMode config.RecModeType) error {
 
 		//request, _ := message.SerializeWithSignature(id, msgbyte)
 		reqHash.Set(cryptolib.GenHash(msgbyte))
-		sender.RBCByteBroadcast(msgbyte)
+		recBroadcast(msgbyte)
 		return nil
 	default:
 		log.Fatal("[Recovery Error] Unknown RecModeType!")
@@ -114,7 +108,6 @@ func RecoveryProcess(recMode config.RecModeType) error {
 
 func HandleRec1Msg(content message.HotStuffMessage) {
 	log.Printf("receive a REC1 msg from replica %v", content.Source)
-	viewMux.Lock()
 	contentByte, _ := content.Serialize()
 	msg := message.HotStuffMessage{
 		Mtype:  pb.MessageType_ECHO1,
@@ -123,20 +116,20 @@ func HandleRec1Msg(content message.HotStuffMessage) {
 		Hash:   cryptolib.GenHash(contentByte),
 		V:      timeoutBuffer.GetV(LocalView() - 1),
 	}
-	viewMux.Unlock()
+	if byzantine(ForgeRecovery) {
+		msg.View, msg.V = staleTQC(msg.View, msg.V)
+	}
 
 	msgbyte, err := msg.Serialize()
 	if err != nil {
 		logging.PrintLog(true, logging.ErrorLog, "[ECHO1Message Error] Not able to serialize the message")
 		return
 	}
-	go sender.SendToNode(msgbyte, content.Source, message.HotStuff)
+	send(msgbyte, content.Source)
 }
 
 func HandleEcho1Msg(content message.HotStuffMessage) {
 	log.Printf("receive a ECHO1 msg from replica %v", content.Source)
-	recLock.Lock()
-	defer recLock.Unlock()
 	if curStatus.Get() != RECOVERING {
 		return
 	}
@@ -145,17 +138,14 @@ func HandleEcho1Msg(content message.HotStuffMessage) {
 		return
 	}
 
-	bufferLock.Lock()
 	hashStr := utils.BytesToString(content.Hash)
 	out, _ := GetBufferContent("ECHO1"+hashStr, BUFFER)
 	if out == PREPARED {
-		bufferLock.Unlock()
 		return
 	}
 
 	if !VerifyTQC(content.View, content.V) {
 		log.Printf("TQC in the ECHO1 msg from replica %v is not verified.", content.Source)
-		bufferLock.Unlock()
 		return
 	}
 	if content.View > hView.Get() {
@@ -169,41 +159,33 @@ func HandleEcho1Msg(content message.HotStuffMessage) {
 	recBuffer.Insert(hashStr, num+1)
 	if num+1 >= quorum.RecQuorumSize() {
 		UpdateBufferContent("ECHO1"+hashStr, PREPARED, BUFFER)
-		bufferLock.Unlock()
 		// TQC received in ECHO1 msgs are not stored,
 		//since a recovering replica will receive a TQC for a higher view before becoming READY.
-		for LocalView() <= hView.Get()+2 {
-			// a little issue: when this `for` is running, recLock keeping locked,
-			// so the other receovery messages cannot be process.
-			// But this seems to be safe,
-			//as long as the local view inceasing process cannot be blocked by the recLock.
-		}
-
-		msg := message.HotStuffMessage{
-			Mtype:  pb.MessageType_REC2,
-			Source: id,
-			TS:     utils.MakeTimestamp(),
-			View:   LocalView(),
-		}
-		msgbyte, err := msg.Serialize()
-		if err != nil {
-			log.Fatal(err)
-		}
-		reqHash.Set(cryptolib.GenHash(msgbyte))
-		sender.RBCByteBroadcast(msgbyte)
-	} else {
-		bufferLock.Unlock()
+		afterView(hView.Get()+3, func() {
+			msg := message.HotStuffMessage{
+				Mtype:  pb.MessageType_REC2,
+				Source: id,
+				TS:     timestamp(),
+				View:   LocalView(),
+			}
+			msgbyte, err := msg.Serialize()
+			if err != nil {
+				log.Fatal(err)
+			}
+			reqHash.Set(cryptolib.GenHash(msgbyte))
+			recBroadcast(msgbyte)
+		})
 	}
 }
 
 func HandleRec2Msg(content message.HotStuffMessage) {
 	log.Printf("receive a REC2 msg from replica %v", content.Source)
-	for LocalView() < content.View {
-	}
+	afterView(content.View, func() { replyRec2(content) })
+}
+
+func replyRec2(content message.HotStuffMessage) {
 
-	cblock.Lock()
 	qcbyte, _ := curBlock.Serialize()
-	cblock.Unlock()
 	lqcbyte, _ := lockedBlock.Serialize()
 	contentByte, _ := content.Serialize()
 	comBlockSer, _ := committedBlocks.Serialize()
@@ -216,6 +198,9 @@ func HandleRec2Msg(content message.HotStuffMessage) {
 		LQC:       lqcbyte,
 		ComBlocks: comBlockSer,
 	}
+	if byzantine(ForgeRecovery) {
+		msg.ComBlocks = forgeCommittedBlocks()
+	}
 
 	msgbyte, err := msg.Serialize()
 	if err != nil {
@@ -223,26 +208,12 @@ func HandleRec2Msg(content message.HotStuffMessage) {
 		return
 	}
 	// time.Sleep(10 * time.Millisecond)
-	go sender.SendToNode(msgbyte, content.Source, message.HotStuff)
+	send(msgbyte, content.Source)
 }
 
 func HandleEcho2Msg(content message.HotStuffMessage) {
 	log.Printf("receive a ECHO2 msg from replica %v", content.Source)
 
-	// TODO: the code below might be executed by multiple goroutines currently.
-	// Thus, it is possible that a block is inserted when the block has existed in committedBlocks
-	var comBlock utils.IntByteMap
-	comBlock.Deserialize(content.ComBlocks)
-	m := comBlock.GetAll()
-	log.Printf("[Recovery] Update committedBlocks to that of replica %d", content.Source)
-	for key := range m {
-		if _, exist := committedBlocks.Get(key); !exist {
-			committedBlocks.Insert(key, m[key])
-		}
-	}
-
-	recLock.Lock()
-	defer recLock.Unlock()
 	if curStatus.Get() != RECOVERING {
 		return
 	}
@@ -252,8 +223,6 @@ func HandleEcho2Msg(content message.HotStuffMessage) {
 		return
 	}
 
-	bufferLock.Lock()
-	defer bufferLock.Unlock()
 	hashStr := utils.BytesToString(content.Hash)
 	out, _ := GetBufferContent("ECHO2"+hashStr, BUFFER)
 	if out == PREPARED {
@@ -268,18 +237,32 @@ func HandleEcho2Msg(content message.HotStuffMessage) {
 		return
 	}
 
-	cblock.Lock()
+	// a committed block of the reply is taken only with a valid QC for its height and hash,
+	// so that a replica cannot make the recovering one commit forged blocks.
+	var comBlock utils.IntByteMap
+	comBlock.Deserialize(content.ComBlocks)
+	m := comBlock.GetAll()
+	log.Printf("[Recovery] Update committedBlocks to that of replica %d", content.Source)
+	for key := range m {
+		if _, exist := committedBlocks.Get(key); exist {
+			continue
+		}
+		block := message.DeserializeQCBlock(m[key])
+		if block.Height != key || block.Hash == nil || !VerifyQC(block) {
+			log.Printf("[Recovery] committed block %d from replica %v is not certified.", key, content.Source)
+			continue
+		}
+		committedBlocks.Insert(key, m[key])
+	}
+
 	if qc.Hash != nil && qc.Height > curBlock.Height {
 		// log.Printf("cb(%v) from %v is no lower than curBlock(%v)", cb.Height, content.Source, curBlock.Height)
 		curBlock = qc
 		UpdateSeq(qc.Height)
 	}
-	cblock.Unlock()
-	lqcLock.Lock()
 	if lqc.Hash != nil && lqc.Height > lockedBlock.Height {
 		lockedBlock = lqc
 	}
-	lqcLock.Unlock()
 	//if comBlock.GetLen() > committedBlocks.GetLen() {
 	//	log.Printf("[Recovery] Update committedBlocks to that of replica %d", content.Source)
 	//	committedBlocks.InsertAll(comBlock.GetAll())
@@ -292,13 +275,19 @@ func HandleEcho2Msg(content message.HotStuffMessage) {
 		num = 0
 	}
 	recBuffer.Insert(hashStr, num+1)
-	if num+1 >= quorum.RecQuorumSize() {
+	if num+1 == quorum.RecQuorumSize() {
 		// wait for 100ms to collect more echo2 messages and update committedBlocks as much as possible
-		time.Sleep(100 * time.Millisecond)
-		UpdateBufferContent("ECHO2"+hashStr, PREPARED, BUFFER)
-		curStatus.Set(READY)
-		log.Printf("recover to READY")
+		startTimer(100*time.Millisecond, Event{Type: RecoveredEvent, Msg: message.HotStuffMessage{Hash: content.Hash}})
+	}
+}
+
+func recovered(hash []byte) {
+	if curStatus.Get() != RECOVERING || !bytes.Equal(hash, reqHash.Get()) {
+		return
 	}
+	UpdateBufferContent("ECHO2"+utils.BytesToString(hash), PREPARED, BUFFER)
+	curStatus.Set(READY)
+	log.Printf("recover to READY")
 }
 
 func recoverFromDisk() {
@@ -318,17 +307,45 @@ func recoverFromDisk() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		err = safetyRules.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		log.Printf("recover to the view %d", viewInt.Get()+1)
 		// will be set to READY after the view change.
-		// this implementation is not graceful, the viewMux should haven't been exposed to external modules, files or functions.
-		viewMux.Lock()
 		StartViewChange(viewInt.Get())
-		viewMux.Unlock()
 	} else if plevel == db.PersistAll {
-		// TODO
-		curStatus.Set(READY)
-		log.Printf("recover to READY")
+		viewInt := utils.IntValue{}
+		err := db.RecoverValue("view", &viewInt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recoverStoredValue("Sequence", &Sequence)
+		recoverStoredValue("votedBlocks", &votedBlocks)
+		recoverStoredValue("curHash", &curHash)
+		recoverStoredValue("awaitingBlocks", &awaitingBlocks)
+		recoverStoredValue("awaitingDecision", &awaitingDecision)
+		recoverStoredValue("awaitingDecisionCopy", &awaitingDecisionCopy)
+		recoverStoredValue("vcAwaitingVotes", &vcAwaitingVotes)
+		recoverStoredValue("mempool", &pool)
+		recoverStoredValue("batches", &batchStore)
+		recoverStoredValue("MsgQueue", &MsgQueue)
+		recoverStoredValue("committedBlocks", &committedBlocks)
+		recoverStoredValue("curBlock", &curBlock)
+		recoverStoredValue("lockedBlock", &lockedBlock)
+		err = safetyRules.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		SetView(viewInt.Get())
+		replayMsgQueue()
+
+		log.Printf("recover to the view %d at height %d", viewInt.Get()+1, GetSeq())
+		// the replica may have voted in the stored view before it crashed,
+		// so it continues in the next view. It will be set to READY after the view change.
+		StartViewChange(viewInt.Get())
 	} else {
 		// if NoPersist: do nothing
 		// else: not planned
@@ -343,3 +360,27 @@ func viewChangeInRecovery(v int) {
 	SetView(v + 1)
 	log.Printf("Starting view change to view %v", v+1)
 }
+
+// Read a value that is only written to the database once the replica has used it.
+// A value that has never been written keeps its initial value.
+func recoverStoredValue(key string, value db.DBValue) {
+	err := db.RecoverValue(key, value)
+	if err != nil && err != db.ErrNotFound {
+		log.Fatalf("[Recovery Error] cannot recover %s from the database: %v", key, err)
+	}
+}
+
+// Feed the stored consensus messages back to the replica, so that the proposals that had
+// been received but not yet processed before the crash are not lost.
+// Since the replica is RECOVERING, it does not vote for the replayed proposals.
+func replayMsgQueue() {
+	msgs := MsgQueue.Grab()
+	log.Printf("[Recovery] replaying %d stored messages", len(msgs))
+	for i := 0; i < len(msgs); i++ {
+		tmp := message.DeserializeMessageWithSignature(msgs[i].GetMsg())
+		content := message.DeserializeHotStuffMessage(tmp.Msg)
+		if content.Mtype == pb.MessageType_QC {
+			HandleNormalMsg(content)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/schedule"
 	"sleepy-hotstuff/src/utils"
 	"time"
 )
@@ -57,39 +58,84 @@ func ParamOfSleepyReplica(id int64) (bool, config.SleepyReplica) {
 	}
 }
 
+// Run the sleep schedule of replica input: the sleep of the test parameters, if any, then the
+// intervals of the schedule. Every interval is traced in var/log/[id]/schedule.jsonl.
 func TestSleepAndRecover(input int64) {
-	isSleepy, p := ParamOfSleepyReplica(input)
-	if !isSleepy {
+	startTime := time.Now()
+	_, p := config.FetchTestTypeAndParam()
+	// the sleeps of the test parameters of all replicas, which count for the bound as well
+	var sleeps []schedule.Interval
+	for _, nid := range replicaIDs() {
+		if isSleepy, sp := ParamOfSleepyReplica(nid); isSleepy {
+			sleeps = append(sleeps, schedule.Interval{Replica: nid, Trigger: config.TriggerHeight,
+				At: sp.SleepSeq, Duration: sp.SleepTime, RecMode: sp.RecMode})
+		}
+	}
+	plan, dropped := schedule.Plan(p.Schedule, sleeps, replicaIDs(), maxAsleep(p.Schedule))
+
+	intervals := schedule.Of(plan, input)
+	dropped = schedule.Of(dropped, input)
+	if len(intervals) == 0 && len(dropped) == 0 {
 		return
 	}
-	t, _ := config.FetchTestTypeAndParam()
-	switch t {
-	case config.Test_HotStuff_NoPersist_DoubleSpend,
-		config.Test_HotStuff_Persist_DoubleSpend,
-		config.Test_SleepyHotStuff_PartChurn,
-		config.Test_Koala2_DoubleSpend:
-		// Sleep
-		SleepProcess(p.SleepSeq, p.SleepTime)
-		// Recover
-		err := RecoveryProcess(p.RecMode)
-		if err != nil {
-			log.Fatal(err)
+
+	trace, err := schedule.OpenTrace(input)
+	if err != nil {
+		log.Printf("[Schedule Error] cannot open the event trace: %v", err)
+	}
+	defer trace.Close()
+	for i, iv := range intervals {
+		trace.Log(schedule.Planned, i, iv, LocalView(), GetSeq())
+	}
+	for i, iv := range dropped {
+		trace.Log(schedule.Dropped, i, iv, LocalView(), GetSeq())
+	}
+
+	for i, iv := range intervals {
+		switch iv.Trigger {
+		case config.TriggerView:
+			awaitView(iv.At)
+			log.Printf("Falling asleep in view %d...", iv.At)
+		case config.TriggerHeight:
+			await(func() bool { return GetSeq() >= iv.At }, &seqSignal)
+			log.Printf("Falling asleep in sequence %d...", iv.At)
+		default:
+			time.Sleep(time.Until(startTime.Add(time.Duration(iv.At) * time.Millisecond)))
+			log.Printf("Falling asleep %d ms after the start...", iv.At)
+		}
+		trace.Log(schedule.Sleep, i, iv, LocalView(), GetSeq())
+		fallAsleep(iv.Duration, iv.RecMode)
+		trace.Log(schedule.Wake, i, iv, LocalView(), GetSeq())
+		await(func() bool {
+			status := curStatus.Get()
+			return status != SLEEPING && status != RECOVERING
+		}, &statusSignal)
+		trace.Log(schedule.Ready, i, iv, LocalView(), GetSeq())
+	}
+}
+
+func replicaIDs() []int64 {
+	var result []int64
+	for _, nid := range config.FetchNodes() {
+		if v, err := utils.StringToInt64(nid); err == nil {
+			result = append(result, v)
 		}
 	}
+	return result
 }
 
-func SleepProcess(sleepSeq int, sleepTime int) {
-	for GetSeq() < sleepSeq {
-		time.Sleep(1 * time.Nanosecond)
-		// log.Printf("wait for seq: %d >= sleepSeq: %d", GetSeq(), sleepSeq)
-		// runtime.Gosched()
+func maxAsleep(s config.Schedule) int {
+	if s.MaxAsleep == 0 {
+		return config.FetchNumOfSleepy()
 	}
-	log.Printf("Falling asleep in sequence %d...", sleepSeq)
-	sleepLock.Lock()
-	curStatus.Set(SLEEPING)
-	sleepLock.Unlock()
+	return s.MaxAsleep
+}
+
+// Sleep for sleepTime ms, then wake up and recover with recMode.
+func fallAsleep(sleepTime int, recMode config.RecModeType) {
+	submit(Event{Type: SleepEvent})
 	log.Printf("sleepTime: %d ms", sleepTime)
 	time.Sleep(time.Duration(sleepTime) * time.Millisecond)
-	InitHotStuff(id)
 	log.Printf("Wake up...")
+	submit(Event{Type: WakeEvent, RecMode: recMode})
 }
@@ -0,0 +1,81 @@
+/*
+Vote verification pipeline.
+HandleQCByteMsg queues the votes, and a single goroutine takes the queued votes at once
+(at most maxVoteBatch), verifies their signatures in parallel with cryptolib.VerifyBatch,
+and gives the valid ones to the core as VoteEvents. Votes thus wait for no timer: under low load a batch has one vote, and
+when votes arrive faster than they are verified, the batches grow.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sync"
+)
+
+// largest number of votes verified together
+const maxVoteBatch = 256
+
+// Votes waiting for the verifier. submitVote never blocks the receiver.
+var pendingVotes []message.HotStuffMessage
+var pendingLock sync.Mutex
+var votesReady = make(chan struct{}, 1)
+var voteVerifier sync.Once
+
+func submitVote(content message.HotStuffMessage) {
+	voteVerifier.Do(func() {
+		go verifyVotes()
+	})
+	pendingLock.Lock()
+	pendingVotes = append(pendingVotes, content)
+	pendingLock.Unlock()
+	select {
+	case votesReady <- struct{}{}:
+	default:
+	}
+}
+
+// Take at most maxVoteBatch of the pending votes.
+func takeVotes() []message.HotStuffMessage {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	batch := pendingVotes
+	if len(batch) > maxVoteBatch {
+		batch = pendingVotes[:maxVoteBatch]
+		pendingVotes = append([]message.HotStuffMessage(nil), pendingVotes[maxVoteBatch:]...)
+		// more votes are left for the next batch
+		select {
+		case votesReady <- struct{}{}:
+		default:
+		}
+	} else {
+		pendingVotes = nil
+	}
+	return batch
+}
+
+func verifyVotes() {
+	for range votesReady {
+		batch := takeVotes()
+		if len(batch) == 0 {
+			continue
+		}
+
+		items := make([]cryptolib.SigItem, len(batch))
+		for i := 0; i < len(batch); i++ {
+			items[i] = cryptolib.SigItem{ID: batch[i].Source, Msg: cryptolib.VoteMsg(batch[i].View, batch[i].Seq, batch[i].Hash), Sig: batch[i].Sig}
+		}
+		ok := cryptolib.VerifyBatch(items)
+		for i := 0; i < len(batch); i++ {
+			if !ok[i] {
+				p := fmt.Sprintf("[QC] signature for QCRep with height %v from %v not verified", batch[i].Seq, batch[i].Source)
+				logging.PrintLog(true, logging.ErrorLog, p)
+				continue
+			}
+			submit(Event{Type: VoteEvent, Msg: batch[i]})
+		}
+	}
+}
@@ -3,10 +3,10 @@ package consensus
 import (
 	"fmt"
 	"log"
-	"sleepy-hotstuff/src/communication/sender"
 	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/cryptolib"
 	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
 	"sleepy-hotstuff/src/logging"
 	"sleepy-hotstuff/src/message"
 	pb "sleepy-hotstuff/src/proto/communication"
@@ -17,13 +17,21 @@ import (
 )
 
 var view int
-var viewMux sync.RWMutex
+var viewMux sync.RWMutex // the view is also read out of the core, by the sleep schedule
 var leader bool
 var endorser bool
 
 // var rotatingTimer *time.Timer  // comments: no use.
 var timeoutBuffer quorum.INTBUFFER
 
+// The last timeout message sent. The timer of a view can expire more than once, and the
+// timeout is sent again unchanged: two timeouts of a view reporting different QCs are
+// evidence of equivocation.
+var lastTimeout struct {
+	view int
+	msg  []byte
+}
+
 const UintSize = 32 << (^uint(0) >> 32 & 1)
 
 // ID of the leader according to the view number
@@ -58,19 +66,31 @@ func SetLeader(input bool) {
 
 // Return view number
 func LocalView() int {
+	viewMux.RLock()
+	defer viewMux.RUnlock()
 	return view
 }
 
 func InitView() {
+	viewMux.Lock()
 	view = 0
+	viewMux.Unlock()
+	viewSignal.Broadcast()
 }
 
 // Set view number
 func SetView(v int) {
+	viewMux.Lock()
+	changed := v > view
 	view = v
+	viewMux.Unlock()
+	viewSignal.Broadcast()
 	viewInt := utils.IntValue{}
 	viewInt.Set(view)
-	db.PersistValue("view", &viewInt, db.PersistCritical)
+	persist("view", &viewInt, db.PersistCritical)
+	if changed {
+		requeueRequests()
+	}
 
 	tmp, _ := utils.Int64ToInt(id)
 	if LeaderID(v) == tmp {
@@ -82,21 +102,14 @@ func SetView(v int) {
 
 func StartRotatingTimer(v int) {
 	rt := config.FetchRotatingTime()
-	time.AfterFunc(time.Duration(rt)*time.Second, func() {
-		// StartViewChange(vv)
-		TimeoutHandler(v)
-	})
+	startTimer(time.Duration(rt)*time.Second, Event{Type: TimeoutEvent, View: v})
 }
 
 // For hotstuff
 func TimeoutHandler(v int) {
-	sleepLock.RLock()
-	defer sleepLock.RUnlock()
 	if curStatus.Get() == SLEEPING || curStatus.Get() == RECOVERING {
 		return
 	}
-	viewMux.Lock()
-	defer viewMux.Unlock()
 	log.Printf("hotstuff handler rotating timer expires in view %v", v)
 	if db.PersistLevelType(config.PersistLevel()) != db.NoPersist {
 		// if view number is persisted, timeout messages are not needed.
@@ -112,46 +125,49 @@ func TimeoutHandler(v int) {
 	// log.Printf("v:%v, LocalView():%v", v, LocalView())
 	curStatus.Set(VIEWCHANGE)
 
+	if lastTimeout.msg != nil && lastTimeout.view == v {
+		deliver(lastTimeout.msg)
+		broadcast(lastTimeout.msg)
+		return
+	}
+
 	// if no persist, we need to send and collect timeout msgs and qcs.
+	// The timeout reports the high QC, so that two timeouts of a view can be told apart.
+	qcbyte, _ := curBlock.Serialize()
 	msg := message.HotStuffMessage{
 		Mtype:  pb.MessageType_TIMEOUT,
 		Source: id,
 		View:   v,
-		TS:     utils.MakeTimestamp(), // no use
-		Num:    quorum.NSize(),        // no use
+		TS:     timestamp(),    // no use
+		Num:    quorum.NSize(), // no use
+		QC:     qcbyte,
 	}
 	msgbyte, err := msg.Serialize()
 	if err != nil {
 		logging.PrintLog(true, logging.ErrorLog, "[QCVCMessage Error] Not able to serialize the message")
 		return
 	}
+	lastTimeout.view, lastTimeout.msg = v, msgbyte
 	p := fmt.Sprintf("sending a timout message of view %d", v)
 	logging.PrintLog(verbose, logging.NormalLog, p)
-	request, _ := message.SerializeWithSignature(id, msgbyte)
-	go HandleQCByteMsg(request)      // this message is first ``received'' by the node itself.
-	sender.RBCByteBroadcast(msgbyte) // This func only casts hotstuff message
+	deliver(msgbyte)
+	broadcast(msgbyte)
 }
 
 func HandleTimeoutMsg(content message.HotStuffMessage, vcm message.MessageWithSignature) { //For new leader to collect vc messages. Todo: double check VC rules @QC
 	log.Printf("receive a timeout msg from replica %v for view %v", content.Source, content.View)
-	viewMux.Lock()
 	if content.View < LocalView() {
-		viewMux.Unlock()
 		return
 	}
-	viewMux.Unlock()
 
-	bufferLock.Lock()
 	hash := utils.BytesToString(cryptolib.GenHash(utils.IntToBytes(content.View)))
 	out, _ := GetBufferContent("TQC"+hash, BUFFER)
 	if out == PREPARED {
-		bufferLock.Unlock()
 		return
 	}
 	timeoutBuffer.InsertValue(content.View, content.Source, vcm)
 	if timeoutBuffer.GetLen(content.View) >= quorum.QuorumSize() {
 		UpdateBufferContent("TQC"+hash, PREPARED, BUFFER)
-		bufferLock.Unlock()
 		msg := message.HotStuffMessage{
 			Mtype:  pb.MessageType_TQC,
 			View:   content.View,
@@ -165,11 +181,12 @@ func HandleTimeoutMsg(content message.HotStuffMessage, vcm message.MessageWithSi
 			logging.PrintLog(true, logging.ErrorLog, p)
 			return
 		}
-		request, _ := message.SerializeWithSignature(id, msgbyte)
-		go HandleQCByteMsg(request) // this message is first ``received'' by the node itself.
-		sender.RBCByteBroadcast(msgbyte)
-	} else {
-		bufferLock.Unlock()
+		deliver(msgbyte)
+		if byzantine(StaleTQCs) {
+			msg.View, msg.V = staleTQC(msg.View, msg.V)
+			msgbyte, _ = msg.Serialize()
+		}
+		broadcast(msgbyte)
 	}
 
 }
@@ -184,6 +201,7 @@ func VerifyTQC(v int, tqc []message.MessageWithSignature) bool {
 		return false
 	}
 	ids := utils.NewSet()
+	items := make([]cryptolib.SigItem, len(tqc))
 	for i := 0; i < len(tqc); i++ {
 		content := message.DeserializeHotStuffMessage(tqc[i].Msg)
 		if ids.HasItem(content.Source) || content.View != v || content.Mtype != pb.MessageType_TIMEOUT {
@@ -191,8 +209,12 @@ func VerifyTQC(v int, tqc []message.MessageWithSignature) bool {
 			return false
 		}
 		ids.AddItem(content.Source)
-		if !cryptolib.VerifySig(content.Source, tqc[i].Msg, tqc[i].Sig) {
-			p := fmt.Sprintf("signature not verified for timeout msg from %v", content.Source)
+		items[i] = cryptolib.SigItem{ID: content.Source, Msg: tqc[i].Msg, Sig: tqc[i].Sig}
+	}
+	ok := cryptolib.VerifyBatch(items)
+	for i := 0; i < len(items); i++ {
+		if !ok[i] {
+			p := fmt.Sprintf("signature not verified for timeout msg from %v", items[i].ID)
 			logging.PrintLog(true, logging.ErrorLog, p)
 			return false
 		}
@@ -209,8 +231,6 @@ func HandleTQCMsg(content message.HotStuffMessage) {
 		return
 	}
 
-	viewMux.Lock()
-	defer viewMux.Unlock()
 	if content.View < LocalView() {
 		return
 	}
@@ -220,8 +240,6 @@ func HandleTQCMsg(content message.HotStuffMessage) {
 		StartViewChange(content.View)
 	}
 
-	bufferLock.Lock()
-	defer bufferLock.Unlock()
 	hash := utils.BytesToString(cryptolib.GenHash(utils.IntToBytes(content.View)))
 	out, _ := GetBufferContent("TQC"+hash, BUFFER)
 	if out == PREPARED {
@@ -237,7 +255,7 @@ func HandleTQCMsg(content message.HotStuffMessage) {
 		return
 	}
 	// forward the TQC msg from another replica, so the Source of this msg is not 'me'.
-	sender.RBCByteBroadcast(msgbyte)
+	broadcast(msgbyte)
 }
 
 // Start view change by sending a VIEWCHANGE message
@@ -256,10 +274,11 @@ func StartViewChange(v int) {
 	curStatus.Set(VIEWCHANGE)
 
 	SetView(v + 1)
+	evidence.Prune(v + 1)
 	//view = view + 1
 	viewInt := utils.IntValue{}
 	viewInt.Set(v + 1)
-	db.PersistValue("view", &viewInt, db.PersistCritical)
+	persist("view", &viewInt, db.PersistCritical)
 	log.Printf("Starting view change to view %v", v+1)
 	HotStuffStartVC()
 	tmp, _ := utils.Int64ToInt(id)
@@ -274,7 +293,7 @@ func StartViewChange(v int) {
 // This func can only be invoked in func StartViewChange.
 func HotStuffStartVC() {
 	log.Printf("hostuff start view change to view %v", LocalView())
-	vcTime = utils.MakeTimestamp()
+	vcTime = timestamp()
 
 	curStatus.Set(VIEWCHANGE)
 
@@ -282,7 +301,7 @@ func HotStuffStartVC() {
 		Mtype:  pb.MessageType_VIEWCHANGE,
 		Source: id,
 		View:   LocalView(),
-		TS:     utils.MakeTimestamp(),
+		TS:     timestamp(),
 		Num:    quorum.NSize(),
 	}
 
@@ -291,13 +310,13 @@ func HotStuffStartVC() {
 	msg.PreHash = blockbyte // it seems that msg.QC = blockbyte is more suitable
 
 	awaitingBlocks.Init()
-	db.PersistValue("awaitingBlocks", &awaitingBlocks, db.PersistAll)
+	persist("awaitingBlocks", &awaitingBlocks, db.PersistAll)
 
 	/* comments: I think these block awaiting buffers should not be cleared at the start of a new view.
 	awaitingDecision.Init()
-	db.PersistValue("awaitingDecision", &awaitingDecision, db.PersistAll)
+	persist("awaitingDecision", &awaitingDecision, db.PersistAll)
 	awaitingDecisionCopy.Init()
-	db.PersistValue("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
+	persist("awaitingDecisionCopy", &awaitingDecisionCopy, db.PersistAll)
 	*/
 
 	msgbyte, err := msg.Serialize()
@@ -312,10 +331,10 @@ func HotStuffStartVC() {
 
 	log.Printf("sending a vc message...")
 	if cl == id {
-		request, _ := message.SerializeWithSignature(id, msgbyte)
-		go HandleQCByteMsg(request)
+		deliver(msgbyte)
+	} else {
+		send(msgbyte, cl)
 	}
-	sender.SendToNode(msgbyte, cl, message.HotStuff)
 }
 
 func VerifyQC(qc message.QCBlock) bool {
@@ -330,11 +349,16 @@ func VerifyQC(qc message.QCBlock) bool {
 	//log.Printf("--[%v] length of QC %v", qc.Height, len(qc.QC))
 
 	//t1 := utils.MakeTimestamp()
+	items := make([]cryptolib.SigItem, len(qc.QC))
 	for i := 0; i < len(qc.QC); i++ {
-		if !cryptolib.VerifySig(qc.IDs[i], qc.Hash, qc.QC[i]) {
+		items[i] = cryptolib.SigItem{ID: qc.IDs[i], Msg: cryptolib.VoteMsg(qc.View, qc.Height, qc.Hash), Sig: qc.QC[i]}
+	}
+	ok := cryptolib.VerifyBatch(items)
+	for i := 0; i < len(items); i++ {
+		if !ok[i] {
 			p := fmt.Sprintf("signature not verified for height %v, source %v", qc.Height, qc.IDs[i])
 			logging.PrintLog(true, logging.ErrorLog, p)
-			return true
+			return false
 		}
 	}
 	//t2 := utils.MakeTimestamp()
@@ -345,8 +369,6 @@ func VerifyQC(qc message.QCBlock) bool {
 
 func HandleQCVCMessage(content message.HotStuffMessage, vcm message.MessageWithSignature) { //For new leader to collect vc messages. Todo: double check VC rules @QC
 	log.Printf("receive a VCQC msg from replica %v for new view %v", content.Source, content.View)
-	viewMux.Lock()
-	defer viewMux.Unlock()
 	if content.View < LocalView() {
 		log.Printf("[VCQC Error]: current view is %v, while content.View is %v", LocalView(), content.View)
 		return
@@ -372,25 +394,21 @@ func HandleQCVCMessage(content message.HotStuffMessage, vcm message.MessageWithS
 	}
 
 	hash := utils.BytesToString(cryptolib.GenHash(utils.IntToBytes(content.View)))
-	bufferLock.Lock()
 	v, _ := GetBufferContent("VCQC"+hash, BUFFER)
 	if v == PREPARED {
 		log.Printf("[VCQC]: enough VCQC for view %v has been received", content.View)
-		bufferLock.Unlock()
 		return
 	}
 	quorum.AddToIntBuffer(content.View, content.Source, vcm, quorum.VC)
 	if cb.Hash != nil && cb.Height >= curBlock.Height {
 		// log.Printf("cb(%v) from %v is no lower than curBlock(%v)", cb.Height, content.Source, curBlock.Height)
-		cblock.Lock()
 		curBlock = cb
-		db.PersistValue("curBlock", &curBlock, db.PersistAll)
-		cblock.Unlock()
+		persist("curBlock", &curBlock, db.PersistAll)
 		UpdateSeq(cb.Height)
 		vs, _ := vcAwaitingVotes.Get(content.View)
 		if cb.Height > vs {
 			vcAwaitingVotes.Delete(content.View)
-			db.PersistValue("vcAwaitingVotes", &vcAwaitingVotes, db.PersistAll)
+			persist("vcAwaitingVotes", &vcAwaitingVotes, db.PersistAll)
 		}
 	}
 
@@ -398,7 +416,6 @@ func HandleQCVCMessage(content message.HotStuffMessage, vcm message.MessageWithS
 		// This is for the case, where the new leader is still in the last view
 		//and the timeout has not happened at it.
 		log.Printf("[VCQC]: receiving VCQC for view %v when READY", content.View)
-		bufferLock.Unlock()
 		return
 	}
 
@@ -409,9 +426,10 @@ func HandleQCVCMessage(content message.HotStuffMessage, vcm message.MessageWithS
 		curStatus.Set(READY)
 		//timer.Stop()
 		//HandleCachedMsg()
-		go RequestMonitor(LocalView())
+		if config.IsViewChangeMode() {
+			StartRotatingTimer(LocalView())
+		}
 	}
-	bufferLock.Unlock()
 }
 
 func StartQCNewView() {
@@ -433,9 +451,11 @@ func StartQCNewView() {
 		p := fmt.Sprintf("[View Change Error] Not able to serialize NEW-VIEW message: %v", err)
 		logging.PrintLog(true, logging.ErrorLog, p)
 	} else {
-		sender.RBCByteBroadcast(msgbyte)
+		broadcast(msgbyte)
+	}
+	if config.IsViewChangeMode() {
+		StartRotatingTimer(LocalView())
 	}
-	go RequestMonitor(LocalView())
 	//storage.ClearInMemoryStoreVC(lastSeq, Leader()) //Clear in memory data for view
 }
 
@@ -508,6 +528,7 @@ func HandleQCNewView(rawmsg []byte) {
 		p := fmt.Sprintf("[New View] handle PP from new view, seq = %d", k)
 		logging.PrintLog(verbose, logging.NormalLog, p)
 
-		go HandleQCByteMsg(msg)
+		tmp := message.DeserializeMessageWithSignature(msg)
+		handleMessage(message.DeserializeHotStuffMessage(tmp.Msg), tmp)
 	}
 }
@@ -0,0 +1,292 @@
+/*
+Batch signature verification.
+VerifyBatch splits the signatures among a bounded pool of goroutines. With batchVerify in
+conf.json, the Ed25519 signatures of each part are checked together with a single equation
+(see verifyEd25519Batch), and one by one only if the batch fails, to find the invalid ones.
+The batch equation multiplies by the cofactor, which ed25519.Verify does not, so with batchVerify
+every Ed25519 signature, also a single one, is checked with the cofactored equation: a signature
+is then accepted or rejected whether it is checked in a batch or alone. All the replicas must
+have the same batchVerify, so that they agree on the certificates.
+*/
+
+package cryptolib
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"math/big"
+	"runtime"
+	"sleepy-hotstuff/src/config"
+	"sync"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/group/edwards25519"
+)
+
+// SigItem is a signature of ID over Msg.
+type SigItem struct {
+	ID  int64
+	Msg []byte
+	Sig []byte
+}
+
+// a verifier that can give the public keys it uses, so that the signatures can be batched
+type keySource interface {
+	PublicKeyOf(id int64) crypto.PublicKey
+}
+
+func (keyFileVerifier) PublicKeyOf(id int64) crypto.PublicKey {
+	return FetchPublicKey(id)
+}
+
+func (k *Keyring) PublicKeyOf(id int64) crypto.PublicKey {
+	k.RLock()
+	defer k.RUnlock()
+	s, exist := k.signers[id]
+	if !exist {
+		return nil
+	}
+	return s.PublicKey()
+}
+
+// set by the tests and benchmarks; otherwise batchVerify in conf.json decides
+var batchEd25519 bool
+
+func batchEnabled() bool {
+	return batchEd25519 || config.BatchVerify()
+}
+
+var verifyPool struct {
+	jobs chan func()
+	size int
+	once sync.Once
+}
+
+// Start the goroutines of the pool on first use.
+func startVerifyPool() {
+	verifyPool.once.Do(func() {
+		size := config.VerifyWorkers()
+		if size <= 0 {
+			size = runtime.NumCPU()
+		}
+		verifyPool.size = size
+		verifyPool.jobs = make(chan func(), size)
+		for i := 0; i < size; i++ {
+			go func() {
+				for job := range verifyPool.jobs {
+					job()
+				}
+			}()
+		}
+	})
+}
+
+// VerifyBatch verifies the signatures in parallel. ok[i] reports whether items[i] is valid.
+func VerifyBatch(items []SigItem) []bool {
+	ok := make([]bool, len(items))
+	if len(items) == 0 {
+		return ok
+	}
+	startVerifyPool()
+
+	parts := verifyPool.size
+	if parts > len(items) {
+		parts = len(items)
+	}
+	size := (len(items) + parts - 1) / parts
+	var wg sync.WaitGroup
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		wg.Add(1)
+		part, partOK := items[start:end], ok[start:end]
+		verifyPool.jobs <- func() {
+			defer wg.Done()
+			verifyPart(part, partOK)
+		}
+	}
+	wg.Wait()
+	return ok
+}
+
+// VerifyAll reports whether every signature is valid.
+func VerifyAll(items []SigItem) bool {
+	for _, valid := range VerifyBatch(items) {
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+func verifyPart(items []SigItem, ok []bool) {
+	v := getVerifier()
+	keys, canBatch := v.(keySource)
+	if !canBatch || !batchEnabled() {
+		for i := range items {
+			ok[i] = v.Verify(items[i].ID, items[i].Msg, items[i].Sig)
+		}
+		return
+	}
+
+	var edKeys []ed25519.PublicKey
+	var edItems []int
+	for i := range items {
+		pubKey := keys.PublicKeyOf(items[i].ID)
+		if edKey, isEd := pubKey.(ed25519.PublicKey); isEd {
+			edKeys = append(edKeys, edKey)
+			edItems = append(edItems, i)
+			continue
+		}
+		ok[i] = pubKey != nil && verify(pubKey, items[i].Msg, items[i].Sig)
+	}
+	if len(edItems) == 0 {
+		return
+	}
+	msgs := make([][]byte, len(edItems))
+	sigs := make([][]byte, len(edItems))
+	for j, i := range edItems {
+		msgs[j], sigs[j] = items[i].Msg, items[i].Sig
+	}
+	if len(edItems) > 1 && verifyEd25519Batch(edKeys, msgs, sigs) {
+		for _, i := range edItems {
+			ok[i] = true
+		}
+		return
+	}
+	for j, i := range edItems {
+		ok[i] = verifyEd25519(edKeys[j], msgs[j], sigs[j])
+	}
+}
+
+// Check one Ed25519 signature, with the cofactored equation if the signatures are batched.
+func verifyEd25519(key ed25519.PublicKey, msg []byte, sig []byte) bool {
+	if batchEnabled() {
+		return verifyEd25519Batch([]ed25519.PublicKey{key}, [][]byte{msg}, [][]byte{sig})
+	}
+	return ed25519.Verify(key, msg, sig)
+}
+
+var ed25519Group = edwards25519.NewBlakeSHA256Ed25519()
+
+// order of the Ed25519 group
+var ed25519Order, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+// verifyEd25519Batch checks the signatures with one equation:
+// 8 * (sum z_i*s_i) * B = 8 * sum (z_i*R_i + z_i*k_i*A_i), with k_i = SHA-512(R_i || A_i || M_i)
+// and random 128-bit z_i, so that invalid signatures cannot cancel each other out.
+// The right side is computed with a single multi-scalar multiplication, which shares the
+// doublings among all the points; this is where the batch is faster than n verifications.
+// Like other batch verifiers, it multiplies by the cofactor, which ed25519.Verify does not:
+// a signature with a small-order component may pass here and fail there, see verifyEd25519.
+func verifyEd25519Batch(keys []ed25519.PublicKey, msgs [][]byte, sigs [][]byte) bool {
+	n := len(sigs)
+	points := make([]kyber.Point, 0, 2*n+1)
+	scalars := make([]*big.Int, 0, 2*n+1)
+	sumS := new(big.Int)
+	z := make([]byte, 16)
+	for i := 0; i < n; i++ {
+		if len(sigs[i]) != ed25519.SignatureSize || len(keys[i]) != ed25519.PublicKeySize {
+			return false
+		}
+		R := ed25519Group.Point()
+		if R.UnmarshalBinary(sigs[i][:32]) != nil {
+			return false
+		}
+		A := ed25519Group.Point()
+		if A.UnmarshalBinary(keys[i]) != nil {
+			return false
+		}
+		s := littleEndianInt(sigs[i][32:])
+		if s.Cmp(ed25519Order) >= 0 {
+			// rejected by ed25519.Verify as well
+			return false
+		}
+
+		h := sha512.New()
+		h.Write(sigs[i][:32])
+		h.Write(keys[i])
+		h.Write(msgs[i])
+		k := littleEndianInt(h.Sum(nil))
+
+		if _, err := rand.Read(z); err != nil {
+			return false
+		}
+		zi := littleEndianInt(z)
+
+		sumS.Add(sumS, s.Mul(s, zi))
+		points = append(points, R, A)
+		scalars = append(scalars, zi, k.Mul(k, zi).Mod(k, ed25519Order))
+	}
+	// B with -sum z_i*s_i, so that the sum of all the terms is the identity
+	sumS.Neg(sumS).Mod(sumS, ed25519Order)
+	points = append(points, ed25519Group.Point().Base())
+	scalars = append(scalars, sumS)
+
+	sum := multiScalarMult(scalars, points)
+	sum.Mul(ed25519Group.Scalar().SetInt64(8), sum)
+	return sum.Equal(ed25519Group.Point().Null())
+}
+
+func littleEndianInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// Radix-16 digits of a scalar below 2^255, each between -8 and 8, least significant first.
+func signedDigits(x *big.Int) [64]int8 {
+	var b [32]byte
+	x.FillBytes(b[:])
+	var e [64]int8
+	for i := 0; i < 32; i++ {
+		e[2*i] = int8(b[31-i] & 15)
+		e[2*i+1] = int8(b[31-i] >> 4)
+	}
+	var carry int8
+	for i := 0; i < 63; i++ {
+		e[i] += carry
+		carry = (e[i] + 8) >> 4
+		e[i] -= carry << 4
+	}
+	e[63] += carry
+	return e
+}
+
+// multiScalarMult computes sum scalars[i]*points[i] with Straus' method: one pass over the
+// digits of all the scalars at once, with the multiples 1..8 of every point precomputed.
+func multiScalarMult(scalars []*big.Int, points []kyber.Point) kyber.Point {
+	tables := make([][8]kyber.Point, len(points))
+	digits := make([][64]int8, len(points))
+	for i := range points {
+		tables[i][0] = points[i]
+		for m := 1; m < 8; m++ {
+			tables[i][m] = ed25519Group.Point().Add(tables[i][m-1], points[i])
+		}
+		digits[i] = signedDigits(scalars[i])
+	}
+
+	sum := ed25519Group.Point().Null()
+	for j := 63; j >= 0; j-- {
+		if j < 63 {
+			for d := 0; d < 4; d++ {
+				sum.Add(sum, sum)
+			}
+		}
+		for i := range points {
+			d := digits[i][j]
+			if d > 0 {
+				sum.Add(sum, tables[i][d-1])
+			} else if d < 0 {
+				sum.Sub(sum, tables[i][-d-1])
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,162 @@
+package cryptolib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+
+	"go.dedis.ch/kyber/v3/group/edwards25519"
+)
+
+// A quorum certificate of n nodes: every node signs the same block hash.
+func makeQC(test testing.TB, scheme SignatureScheme, n int) (*Keyring, []SigItem) {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	keyring, err := NewKeyring(scheme, ids...)
+	if err != nil {
+		test.Fatal(err)
+	}
+	hash := GenHash([]byte("block"))
+	items := make([]SigItem, n)
+	for i, id := range ids {
+		sig, err := keyring.Signer(id).Sign(hash)
+		if err != nil {
+			test.Fatal(err)
+		}
+		items[i] = SigItem{ID: id, Msg: hash, Sig: sig}
+	}
+	return keyring, items
+}
+
+func TestVerifyBatch(test *testing.T) {
+	defer SetVerifier(keyFileVerifier{})
+	for _, batch := range []bool{false, true} {
+		batchEd25519 = batch
+		for _, scheme := range []SignatureScheme{P256, Ed25519} {
+			keyring, items := makeQC(test, scheme, 7)
+			SetVerifier(keyring)
+			if !VerifyAll(items) {
+				test.Fatalf("%s (batch %v): valid signatures rejected", scheme, batch)
+			}
+
+			// a signature of another message, and a signature of another node
+			items[2].Msg = GenHash([]byte("another block"))
+			items[5].ID = 6
+			ok := VerifyBatch(items)
+			for i := range ok {
+				if ok[i] != (i != 2 && i != 5) {
+					test.Fatalf("%s (batch %v): signature %d verified: %v", scheme, batch, i, ok[i])
+				}
+			}
+		}
+	}
+	batchEd25519 = false
+}
+
+// A signature with s increased by the group order is rejected, as by ed25519.Verify.
+func TestBatchNonCanonical(test *testing.T) {
+	keyring, items := makeQC(test, Ed25519, 2)
+	keys := []ed25519.PublicKey{keyring.PublicKeyOf(0).(ed25519.PublicKey), keyring.PublicKeyOf(1).(ed25519.PublicKey)}
+	sig := append([]byte{}, items[1].Sig...)
+	var carry int
+	for i := 0; i < 32; i++ {
+		v := int(sig[32+i]) + int(ed25519Order.Bytes()[31-i]) + carry
+		sig[32+i] = byte(v)
+		carry = v >> 8
+	}
+	if verifyEd25519Batch(keys, [][]byte{items[0].Msg, items[1].Msg}, [][]byte{items[0].Sig, sig}) {
+		test.Fatal("non-canonical signature accepted")
+	}
+	if !verifyEd25519Batch(keys, [][]byte{items[0].Msg, items[1].Msg}, [][]byte{items[0].Sig, items[1].Sig}) {
+		test.Fatal("valid batch rejected")
+	}
+}
+
+// A node whose public key has a small-order component T signs with its private key: the
+// signature only holds up to k*T, which a cofactored batch equation ignores and ed25519.Verify
+// does not. Without batches, the parallel verification rejects it, as ed25519.Verify does; with
+// batches, it accepts it, alone or in a batch, so that the replicas agree either way.
+func TestVerifySmallOrder(test *testing.T) {
+	defer SetVerifier(keyFileVerifier{})
+	defer func() { batchEd25519 = false }()
+	keyring, items := makeQC(test, Ed25519, 4)
+	SetVerifier(keyring)
+
+	// the point (0, -1), of order 2
+	T := edwards25519.NewBlakeSHA256Ed25519().Point()
+	if err := T.UnmarshalBinary(append([]byte{0xec}, append(bytes.Repeat([]byte{0xff}, 30), 0x7f)...)); err != nil {
+		test.Fatal(err)
+	}
+	key := keyring.signers[3].key.(ed25519.PrivateKey)
+	A := edwards25519.NewBlakeSHA256Ed25519().Point()
+	if err := A.UnmarshalBinary(key[32:]); err != nil {
+		test.Fatal(err)
+	}
+	pubKey, _ := A.Add(A, T).MarshalBinary()
+	keyring.signers[3] = &KeySigner{key: ed25519.PrivateKey(append(append([]byte{}, key[:32]...), pubKey...))}
+
+	// k is odd for about half of the messages, and then k*T is not the identity
+	rejected := 0
+	for i := 0; i < 16; i++ {
+		items[3].Msg = GenHash([]byte(fmt.Sprintf("block %d", i)))
+		items[3].Sig, _ = keyring.Signer(3).Sign(items[3].Msg)
+		single := ed25519.Verify(ed25519.PublicKey(pubKey), items[3].Msg, items[3].Sig)
+		batchEd25519 = false
+		if ok := VerifyBatch(items); ok[3] != single || !ok[0] || !ok[1] || !ok[2] {
+			test.Fatalf("block %d: verified %v, ed25519.Verify gives %v", i, ok, single)
+		}
+		batchEd25519 = true
+		if ok := VerifyBatch(items); !ok[3] || !VerifySig(3, items[3].Msg, items[3].Sig) {
+			test.Fatalf("block %d: verified %v in a batch, alone %v", i, ok, VerifySig(3, items[3].Msg, items[3].Sig))
+		}
+		if !single {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		test.Fatal("no signature with a small-order component")
+	}
+}
+
+func benchmarkQC(b *testing.B, scheme SignatureScheme, n int, verifyQC func([]SigItem) bool) {
+	keyring, items := makeQC(b, scheme, n)
+	SetVerifier(keyring)
+	defer SetVerifier(keyFileVerifier{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !verifyQC(items) {
+			b.Fatal("QC not verified")
+		}
+	}
+}
+
+// The verification of a QC as before: one signature after the other.
+func verifySequential(items []SigItem) bool {
+	for i := range items {
+		if !VerifySig(items[i].ID, items[i].Msg, items[i].Sig) {
+			return false
+		}
+	}
+	return true
+}
+
+func BenchmarkVerifyQC(b *testing.B) {
+	for _, n := range []int{4, 16, 64} {
+		for _, scheme := range []SignatureScheme{P256, Ed25519} {
+			b.Run(fmt.Sprintf("%s/n=%d/sequential", scheme, n), func(b *testing.B) {
+				benchmarkQC(b, scheme, n, verifySequential)
+			})
+			b.Run(fmt.Sprintf("%s/n=%d/parallel", scheme, n), func(b *testing.B) {
+				benchmarkQC(b, scheme, n, VerifyAll)
+			})
+		}
+		b.Run(fmt.Sprintf("%s/n=%d/batch", Ed25519, n), func(b *testing.B) {
+			batchEd25519 = true
+			defer func() { batchEd25519 = false }()
+			benchmarkQC(b, Ed25519, n, VerifyAll)
+		})
+	}
+}
@@ -0,0 +1,215 @@
+/*
+TLS certificates of replicas and clients.
+The key tool creates a CA in etc/key/ca and, for every id, a P-256 key and a certificate
+signed by the CA in etc/key/<id>. The common name of a certificate is the id of the node,
+so that a receiver can bind a TLS connection to the Source of the messages.
+*/
+
+package cryptolib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const caCertFile = "ca.crt"
+const caKeyFile = "ca.key"
+const tlsCertFile = "tls.crt"
+const tlsKeyFile = "tls.key"
+
+// directory of the CA, next to the directories of the nodes
+const caID = "ca"
+
+// certificates are valid for ten years, which covers any experiment
+const certValidity = 10 * 365 * 24 * time.Hour
+
+func caPath() string {
+	return homepath + "/etc/key/" + caID + "/"
+}
+
+// CertServerName is the DNS name in the certificate of node id, used as the TLS server name.
+func CertServerName(id string) string {
+	return "node-" + id
+}
+
+func writePEM(file string, blockType string, der []byte) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
+
+func readPEM(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("[Cert Error] no PEM data in %s", file)
+	}
+	return block.Bytes, nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Load the CA, or create it if the key tool runs for the first time.
+func loadOrCreateCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	path := caPath()
+	if IsExist(path + caCertFile) {
+		certDER, err := readPEM(path + caCertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyDER, err := readPEM(path + caKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := x509.ParseECPrivateKey(keyDER)
+		return cert, key, err
+	}
+
+	err := CreateDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sleepy-hotstuff CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writePEM(path+caKeyFile, "EC PRIVATE KEY", keyDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writePEM(path+caCertFile, "CERTIFICATE", der)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// GenerateCert creates the TLS key and certificate of node id, signed by the CA.
+// The certificate is valid both for the server and the client side of a connection.
+func GenerateCert(id int64) error {
+	ca, caKey, err := loadOrCreateCA()
+	if err != nil {
+		return err
+	}
+	path := GenPath(id)
+	if !IsExist(path) {
+		err = CreateDir(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	nid := strconv.FormatInt(id, 10)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: nid},
+		DNSNames:     []string{CertServerName(nid)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = writePEM(path+tlsKeyFile, "EC PRIVATE KEY", keyDER)
+	if err != nil {
+		return err
+	}
+	return writePEM(path+tlsCertFile, "CERTIFICATE", der)
+}
+
+// LoadTLSConfig returns the TLS configuration of node id: its certificate, and the CA
+// as the only root for both servers and clients. Peers must present a certificate.
+func LoadTLSConfig(id string) (*tls.Config, error) {
+	nid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if homepath == "" {
+		SetHomeDir()
+	}
+	path := GenPath(nid)
+	cert, err := tls.LoadX509KeyPair(path+tlsCertFile, path+tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caDER, err := readPEM(caPath() + caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// CertID returns the node id bound to a verified peer certificate.
+func CertID(cert *x509.Certificate) (int64, error) {
+	if cert == nil {
+		return 0, errors.New("[Cert Error] no peer certificate")
+	}
+	return strconv.ParseInt(cert.Subject.CommonName, 10, 64)
+}
@@ -1,71 +1,128 @@
 package cryptolib
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
+	"sleepy-hotstuff/src/config"
 	"sleepy-hotstuff/src/logging"
-	"strings"
 	"sync"
 )
 
-var randSign = "22220316zafes20180lk7zafes20180619zafepikas"
+// SignatureScheme is the type of the key pair of a node.
+// The type is recorded in the key files, so nodes with different schemes can verify each other.
+type SignatureScheme string
 
-var randKey = "lk0f7279c18d439459435s714797c9680335a320"
+const (
+	P224    SignatureScheme = "P224" // keys generated by earlier versions of the key tool
+	P256    SignatureScheme = "P256"
+	Ed25519 SignatureScheme = "Ed25519"
+)
+
+// KeyScheme is the scheme of the keys created by GenerateKey, unless keyScheme is set in conf.json.
+var KeyScheme = P256
 
+// PriKey is the ECDSA key of this node. It is nil if the node has an Ed25519 key or signs
+// with a remote signer.
 var PriKey *ecdsa.PrivateKey
 var PubKey *ecdsa.PublicKey
 
 var MapOfKeys Int64KeyMap
 
+// StartECDSA installs the signer of node id: a remote signer if signerSocket is set
+// in conf.json, otherwise the private key in etc/key. A remote signer installed by an
+// earlier call is reused if it has the same socket, and closed otherwise.
 func StartECDSA(id int64) {
+	nid = id
 	SetHomeDir()
-	LoadPrivKeyFromFile(id)
 	MapOfKeys.Init()
+	if old, ok := getSigner().(*RemoteSigner); ok {
+		if old.path == config.SignerSocket() {
+			return
+		}
+		old.Close()
+	}
+	if config.SignerSocket() == "" {
+		LoadPrivKeyFromFile(id)
+		return
+	}
+	s, err := NewRemoteSigner(config.SignerSocket())
+	if err != nil {
+		p := fmt.Sprintf("[ecdsa.go]:StartECDSA cannot reach the signer %s! errorinfo:%v\n", config.SignerSocket(), err)
+		logging.PrintLog(true, logging.ErrorLog, p)
+		return
+	}
+	SetSigner(s)
 }
 
-func GenSig(id int64, msg []byte) []byte {
-
-	r, s, err := ecdsa.Sign(strings.NewReader(randSign), PriKey, msg)
+// An ECDSA signature is r||s, each padded to the byte length of the curve. The SHA-256 digest
+// of msg is signed: ECDSA only takes as many bytes of its input as the curve has, and the
+// messages are longer.
+func signECDSA(key *ecdsa.PrivateKey, msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+	size := ecdsaSize(&key.PublicKey)
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
 
-	// complement r and s to 28 bytes, since the prikey is 28 bytes (224 bits).
-	sigSize := PriKey.Params().BitSize / 8
-	rBytes := r.Bytes()
-	sBytes := s.Bytes()
-	if len(rBytes) < sigSize {
-		rBytes = append(make([]byte, sigSize-len(rBytes)), rBytes...)
-		logging.PrintLog(false, logging.NormalLog, "complement rBytes when genSig")
-	}
-	if len(sBytes) < sigSize {
-		sBytes = append(make([]byte, sigSize-len(sBytes)), sBytes...)
-		logging.PrintLog(false, logging.NormalLog, "complement sBytes when genSig")
-	}
+func ecdsaSize(pubKey *ecdsa.PublicKey) int {
+	return (pubKey.Curve.Params().BitSize + 7) / 8
+}
 
-	signature := rBytes
-	signature = append(signature, sBytes...)
-	return signature
+// SignatureSize returns the length of the signatures of a public key, or 0 for an unknown key type.
+func SignatureSize(pubKey crypto.PublicKey) int {
+	switch k := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		return 2 * ecdsaSize(k)
+	case ed25519.PublicKey:
+		return ed25519.SignatureSize
+	}
+	return 0
 }
 
-func VerifySig(id int64, msg []byte, sig []byte) bool {
-	result := false
+func verify(pubKey crypto.PublicKey, msg []byte, sig []byte) bool {
+	if len(sig) != SignatureSize(pubKey) {
+		return false
+	}
+	switch k := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		size := ecdsaSize(k)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		digest := sha256.Sum256(msg)
+		return ecdsa.Verify(k, digest[:], r, s)
+	case ed25519.PublicKey:
+		return verifyEd25519(k, msg, sig)
+	}
+	return false
+}
 
-	r1 := sig[:28]
-	s2 := sig[28:]
-	r := new(big.Int).SetBytes(r1)
-	s := new(big.Int).SetBytes(s2)
+// FetchPublicKey returns the public key of node id, loading it from etc/key the first time.
+func FetchPublicKey(id int64) crypto.PublicKey {
 	pubKey, exist := MapOfKeys.Get(id)
-	if !exist {
-		pubKey = LoadPubKeyFromFile(id)
+	if exist {
+		return pubKey
 	}
-	result = ecdsa.Verify(pubKey, msg, r, s)
-	return result
+	pubKey = LoadPubKeyFromFile(id)
+	if pubKey != nil {
+		MapOfKeys.Insert(id, pubKey)
+	}
+	return pubKey
 }
 
 func LoadNewPubKey() []byte {
@@ -82,35 +139,58 @@ func LoadNewPubKey() []byte {
 
 }
 
-func LoadKey(pubkB []byte) *ecdsa.PublicKey {
-	i, err := x509.ParsePKIXPublicKey(pubkB)
+func LoadKey(pubkB []byte) crypto.PublicKey {
+	pubKey, err := parsePublicKey(pubkB)
 	if err != nil {
-		log.Printf("error when parsing public key for id -1")
-		return nil
-	}
-
-	pubKey, ok := i.(*ecdsa.PublicKey)
-	if !ok {
-		log.Printf("public conversion error")
+		log.Printf("error when parsing public key for id -1: %v", err)
 		return nil
 	}
 	return pubKey
 }
 
 func VerifyNewServer(pk []byte, msg []byte, sig []byte) bool {
-	result := false
-
-	r1 := sig[:28]
-	s2 := sig[28:]
-	r := new(big.Int).SetBytes(r1)
-	s := new(big.Int).SetBytes(s2)
 	pubKey := LoadKey(pk)
+	if pubKey == nil {
+		return false
+	}
+	return verify(pubKey, msg, sig)
+}
 
-	result = ecdsa.Verify(pubKey, msg, r, s)
-	return result
+// Public keys are stored in PKIX form for every scheme.
+func parsePublicKey(pubkB []byte) (crypto.PublicKey, error) {
+	i, err := x509.ParsePKIXPublicKey(pubkB)
+	if err != nil {
+		return nil, err
+	}
+	switch k := i.(type) {
+	case *ecdsa.PublicKey:
+		return k, nil
+	case ed25519.PublicKey:
+		return k, nil
+	}
+	return nil, errors.New("public key type not supported")
 }
 
-func LoadPubKeyFromFile(id int64) *ecdsa.PublicKey {
+// ECDSA private keys are stored in SEC 1 form, as by earlier versions; Ed25519 keys in PKCS #8 form.
+func parsePrivateKey(privK []byte) (crypto.PrivateKey, error) {
+	ecKey, err := x509.ParseECPrivateKey(privK)
+	if err == nil {
+		return ecKey, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(privK)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, errors.New("private key type not supported")
+}
+
+func LoadPubKeyFromFile(id int64) crypto.PublicKey {
 	path := GenPath(id)
 	var pubfileName = fmt.Sprintf("pub.key")
 
@@ -121,37 +201,63 @@ func LoadPubKeyFromFile(id int64) *ecdsa.PublicKey {
 		return nil
 	}
 
-	i, err := x509.ParsePKIXPublicKey(pubkB)
+	pubKey, err := parsePublicKey(pubkB)
 	if err != nil {
-		log.Printf("error when parsing public key of %v", id)
-		return nil
-	}
-
-	pubKey, ok := i.(*ecdsa.PublicKey)
-	if !ok {
-		log.Printf("public conversion error")
+		log.Printf("error when parsing public key of %v: %v", id, err)
 		return nil
 	}
 	return pubKey
 }
 
+// LoadPrivKeyFromFile installs the private key of node id in etc/key as the signer of this node.
 func LoadPrivKeyFromFile(id int64) {
-	path := GenPath(id)
-	var privfileName = fmt.Sprintf("priv.key")
-
-	// log.Printf("path %s", path+privfileName)
-	privK, err := ioutil.ReadFile(path + privfileName)
+	s, err := NewFileSigner(id)
 	if err != nil {
-		p := fmt.Sprintf("[ecdsa.go]:LoadPrivKeyFromFiles open priv file error! errorinfo:%v\n", err)
+		p := fmt.Sprintf("[ecdsa.go]:LoadPrivKeyFromFiles load priv file error! errorinfo:%v\n", err)
 		logging.PrintLog(false, logging.ErrorLog, p)
 		return
 	}
-	var errr error
-	PriKey, errr = x509.ParseECPrivateKey(privK)
-	if errr != nil {
-		log.Printf("[ecdsa.go] conversion error %v", err)
-		return
+	PriKey, _ = s.key.(*ecdsa.PrivateKey)
+	PubKey = nil
+	if PriKey != nil {
+		PubKey = &PriKey.PublicKey
 	}
+	SetSigner(s)
+}
+
+// Generate a key pair of the scheme, serialized as stored in the key files.
+func generateKeyPair(scheme SignatureScheme) ([]byte, []byte, error) {
+	var curve elliptic.Curve
+	switch scheme {
+	case P224:
+		curve = elliptic.P224()
+	case P256:
+		curve = elliptic.P256()
+	case Ed25519:
+		pubKey, priKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		priBytes, err := x509.MarshalPKCS8PrivateKey(priKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		pubBytes, err := x509.MarshalPKIXPublicKey(pubKey)
+		return priBytes, pubBytes, err
+	default:
+		return nil, nil, fmt.Errorf("signature scheme %q not supported", scheme)
+	}
+
+	priKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	priBytes, err := x509.MarshalECPrivateKey(priKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priKey.PublicKey)
+	return priBytes, pubBytes, err
 }
 
 func GenerateKey(id int64) {
@@ -171,39 +277,14 @@ func GenerateKey(id int64) {
 		fmt.Println("creating " + path)
 	}
 
-	lenth := len(randKey)
-	if lenth < 224/8 {
-		log.Printf("[ecdsa error] length of randkey is too short")
-		return
-	}
-
-	// 根据随机密匙的长度创建私匙
-	var curve elliptic.Curve
-	if lenth > 521/8+8 {
-		curve = elliptic.P521()
-	} else if lenth > 384/8+8 {
-		curve = elliptic.P384()
-	} else if lenth > 256/8+8 {
-		curve = elliptic.P256()
-	} else if lenth > 224/8+8 {
-		curve = elliptic.P224()
+	scheme := KeyScheme
+	if config.KeyScheme() != "" {
+		scheme = SignatureScheme(config.KeyScheme())
 	}
-	// 生成私匙
-	priKey, err := ecdsa.GenerateKey(curve, strings.NewReader(randKey))
-	if err != nil {
-		return
-	}
-	// *****************保存私匙*******************
-	// 序列化私匙
-	priBytes, err := x509.MarshalECPrivateKey(priKey)
-	if err != nil {
-		return
-	}
-
-	// *****************保存公匙*******************
-	// 序列化公匙
-	pubBytes, err := x509.MarshalPKIXPublicKey(&priKey.PublicKey)
+	priBytes, pubBytes, err := generateKeyPair(scheme)
 	if err != nil {
+		p := fmt.Sprintf("[ecdsa.go]:GenerateKey generate key error! errorinfo:%v\n", err)
+		logging.PrintLog(false, logging.ErrorLog, p)
 		return
 	}
 
@@ -220,20 +301,27 @@ func GenerateKey(id int64) {
 		return
 	}
 
+	// TLS certificate used when commOption is TLS
+	err = GenerateCert(id)
+	if err != nil {
+		p := fmt.Sprintf("[ecdsa.go]:GenerateKey generate TLS certificate error! errorinfo:%v\n", err)
+		logging.PrintLog(false, logging.ErrorLog, p)
+		return
+	}
 }
 
 type Int64KeyMap struct {
-	m map[int64]*ecdsa.PublicKey
+	m map[int64]crypto.PublicKey
 	sync.RWMutex
 }
 
 func (s *Int64KeyMap) Init() {
 	s.Lock()
 	defer s.Unlock()
-	s.m = make(map[int64]*ecdsa.PublicKey)
+	s.m = make(map[int64]crypto.PublicKey)
 }
 
-func (s *Int64KeyMap) Get(key int64) (*ecdsa.PublicKey, bool) {
+func (s *Int64KeyMap) Get(key int64) (crypto.PublicKey, bool) {
 	s.RLock()
 	defer s.RUnlock()
 	_, exist := s.m[key]
@@ -243,13 +331,16 @@ func (s *Int64KeyMap) Get(key int64) (*ecdsa.PublicKey, bool) {
 	return nil, false
 }
 
-func (s *Int64KeyMap) GetAll() map[int64]*ecdsa.PublicKey {
+func (s *Int64KeyMap) GetAll() map[int64]crypto.PublicKey {
 	return s.m
 }
 
-func (s *Int64KeyMap) Insert(key int64, value *ecdsa.PublicKey) {
+func (s *Int64KeyMap) Insert(key int64, value crypto.PublicKey) {
 	s.Lock()
 	defer s.Unlock()
+	if s.m == nil {
+		s.m = make(map[int64]crypto.PublicKey)
+	}
 	s.m[key] = value
 }
 
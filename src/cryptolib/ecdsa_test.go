@@ -0,0 +1,68 @@
+package cryptolib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSignatureSchemes(test *testing.T) {
+	homepath = test.TempDir()
+	defer func() { homepath = "" }()
+	MapOfKeys.Init()
+
+	sizes := map[SignatureScheme]int{P224: 56, P256: 64, Ed25519: 64}
+	for i, scheme := range []SignatureScheme{P224, P256, Ed25519} {
+		id := int64(i)
+		KeyScheme = scheme
+		GenerateKey(id)
+		LoadPrivKeyFromFile(id)
+
+		msg := []byte("block hash")
+		sig := GenSig(msg)
+		if len(sig) != sizes[scheme] {
+			test.Fatalf("%s: signature of %d bytes", scheme, len(sig))
+		}
+		if !VerifySig(id, msg, sig) {
+			test.Fatalf("%s: signature not verified", scheme)
+		}
+		if VerifySig(id, []byte("another hash"), sig) {
+			test.Fatalf("%s: signature verified for another message", scheme)
+		}
+		if VerifySig(id, msg, sig[1:]) {
+			test.Fatalf("%s: truncated signature verified", scheme)
+		}
+		// a vote differs from another one of the same view and height in its last bytes only
+		vote := VoteMsg(3, 7, GenHash([]byte("A")))
+		if VerifySig(id, VoteMsg(3, 7, GenHash([]byte("B"))), GenSig(vote)) {
+			test.Fatalf("%s: vote signature verified for another block", scheme)
+		}
+	}
+	KeyScheme = P256
+}
+
+// Key files written by earlier versions of the key tool: a P-224 key in SEC 1 form.
+func TestLoadLegacyKey(test *testing.T) {
+	homepath = test.TempDir()
+	defer func() { homepath = "" }()
+	MapOfKeys.Init()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	priBytes, _ := x509.MarshalECPrivateKey(key)
+	pubBytes, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	CreateDir(GenPath(7))
+	ioutil.WriteFile(GenPath(7)+"priv.key", priBytes, 0644)
+	ioutil.WriteFile(GenPath(7)+"pub.key", pubBytes, 0644)
+
+	LoadPrivKeyFromFile(7)
+	if PriKey == nil || PriKey.D.Cmp(key.D) != 0 {
+		test.Fatal("legacy private key not loaded")
+	}
+	sig := GenSig([]byte("hash"))
+	if len(sig) != 56 || !VerifySig(7, []byte("hash"), sig) {
+		test.Fatal("legacy key does not sign")
+	}
+}
@@ -119,6 +119,26 @@ func ObtainMerklePath(input [][]byte) ([][][]byte, [][]int64) {
 	return result, indexresult
 }
 
+// VerifyMerklePath checks a path and indexes given by ObtainMerklePath for the leaf input.
+func VerifyMerklePath(root []byte, input []byte, path [][]byte, index []int64) bool {
+	if len(path) != len(index) {
+		return false
+	}
+	h := ObtainMerkleNodeHash(input)
+	for i := range path {
+		var node []byte
+		if index[i] == 1 {
+			// the sibling is the right child
+			node = append(append(node, h...), path[i]...)
+		} else {
+			node = append(append(node, path[i]...), h...)
+		}
+		sum := sha256.Sum256(node)
+		h = sum[:]
+	}
+	return bytes.Equal(h, root)
+}
+
 func GenBatchHash(batch []pb.RawMessage) []byte {
 	tmp := make([][]byte, len(batch), len(batch))
 	for i := 0; i < len(batch); i++ {
@@ -0,0 +1,220 @@
+/*
+Remote signer.
+A signing daemon holds the private key of a replica and answers signing requests on a
+Unix socket. Votes are sent with their view and height by a request of their own, and the
+daemon checks them before signing, so that even a compromised replica cannot make it sign
+two blocks at the same height. The message signed by a vote (VoteMsg) holds the checked view
+and height, and starts with a prefix that the daemon refuses in other requests.
+Each request and reply is a msgpack struct preceded by its length.
+*/
+
+package cryptolib
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	signOp      = "sign"
+	signVoteOp  = "vote"
+	publicKeyOp = "pubkey"
+)
+
+// limit on the size of a request or reply
+const maxSignerFrame = 1 << 24
+
+type signRequest struct {
+	Op     string
+	View   int
+	Height int
+	Msg    []byte
+}
+
+type signReply struct {
+	Sig    []byte
+	PubKey []byte
+	Err    string
+}
+
+func writeFrame(w io.Writer, v interface{}) error {
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxSignerFrame {
+		return signerError("frame of %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(data, v)
+}
+
+// RemoteSigner signs with a signing daemon listening on a Unix socket.
+type RemoteSigner struct {
+	path   string
+	conn   net.Conn
+	pubKey crypto.PublicKey
+	sync.Mutex
+}
+
+// NewRemoteSigner connects to the daemon at path and fetches its public key.
+func NewRemoteSigner(path string) (*RemoteSigner, error) {
+	r := &RemoteSigner{path: path}
+	reply, err := r.call(signRequest{Op: publicKeyOp})
+	if err != nil {
+		return nil, err
+	}
+	r.pubKey, err = parsePublicKey(reply.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Send a request, reconnecting once if the daemon closed the connection.
+func (r *RemoteSigner) call(req signRequest) (signReply, error) {
+	r.Lock()
+	defer r.Unlock()
+	var reply signReply
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if r.conn == nil {
+			r.conn, err = net.Dial("unix", r.path)
+			if err != nil {
+				return reply, err
+			}
+		}
+		err = writeFrame(r.conn, req)
+		if err == nil {
+			err = readFrame(r.conn, &reply)
+		}
+		if err == nil {
+			break
+		}
+		r.conn.Close()
+		r.conn = nil
+	}
+	if err != nil {
+		return reply, err
+	}
+	if reply.Err != "" {
+		return reply, errors.New(reply.Err)
+	}
+	return reply, nil
+}
+
+func (r *RemoteSigner) Sign(msg []byte) ([]byte, error) {
+	reply, err := r.call(signRequest{Op: signOp, Msg: msg})
+	return reply.Sig, err
+}
+
+func (r *RemoteSigner) SignVote(view int, height int, hash []byte) ([]byte, error) {
+	reply, err := r.call(signRequest{Op: signVoteOp, View: view, Height: height, Msg: hash})
+	return reply.Sig, err
+}
+
+func (r *RemoteSigner) PublicKey() crypto.PublicKey {
+	return r.pubKey
+}
+
+// Close closes the connection to the daemon.
+func (r *RemoteSigner) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// SignerServer is the daemon side of a RemoteSigner.
+// CheckVote, if set, is called before a vote is signed; the vote is refused if it returns an error.
+// It should record the vote durably before returning, e.g., with safety.Rules.Vote and a write of the rules.
+type SignerServer struct {
+	Signer    Signer
+	CheckVote func(view int, height int, hash []byte) error
+	lock      sync.Mutex
+}
+
+// Serve answers the requests of every connection accepted by lis, until lis is closed.
+func (s *SignerServer) Serve(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *SignerServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var req signRequest
+		if err := readFrame(conn, &req); err != nil {
+			return
+		}
+		reply := s.handle(req)
+		if err := writeFrame(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *SignerServer) handle(req signRequest) signReply {
+	// requests are handled one at a time, so that two votes cannot pass the check together
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var reply signReply
+	var err error
+	switch req.Op {
+	case publicKeyOp:
+		reply.PubKey, err = x509.MarshalPKIXPublicKey(s.Signer.PublicKey())
+	case signVoteOp:
+		if s.CheckVote != nil {
+			err = s.CheckVote(req.View, req.Height, req.Msg)
+		}
+		if err == nil {
+			reply.Sig, err = s.Signer.Sign(VoteMsg(req.View, req.Height, req.Msg))
+		}
+	case signOp:
+		// a vote must come with its view and height, so that it is checked
+		if IsVoteMsg(req.Msg) {
+			err = signerError("votes are signed with the %q operation", signVoteOp)
+			break
+		}
+		reply.Sig, err = s.Signer.Sign(req.Msg)
+	default:
+		err = signerError("unknown operation %q", req.Op)
+	}
+	if err != nil {
+		reply.Err = err.Error()
+	}
+	return reply
+}
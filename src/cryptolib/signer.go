@@ -0,0 +1,233 @@
+/*
+Signers and verifiers.
+A replica signs through the Signer installed with SetSigner and checks the signatures of
+other nodes through the Verifier installed with SetVerifier. By default the signer uses
+the private key in etc/key/<id> and the verifier the public keys in etc/key.
+A Keyring keeps the keys of several nodes in memory for tests, and a RemoteSigner
+asks a signing daemon, so that the private key never enters the replica process.
+*/
+
+package cryptolib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+// Signer signs messages with the key of this node.
+type Signer interface {
+	Sign(msg []byte) ([]byte, error)
+	PublicKey() crypto.PublicKey
+}
+
+// VoteSigner is a Signer that checks votes before signing them, e.g., against double signing.
+type VoteSigner interface {
+	Signer
+	SignVote(view int, height int, hash []byte) ([]byte, error)
+}
+
+// Verifier checks the signature of node id.
+type Verifier interface {
+	Verify(id int64, msg []byte, sig []byte) bool
+}
+
+var ErrNoSigner = errors.New("[Signer Error] no signer installed")
+
+var signerLock sync.RWMutex
+var signer Signer
+var verifier Verifier = keyFileVerifier{}
+
+// SetSigner installs the signer of this node.
+func SetSigner(s Signer) {
+	signerLock.Lock()
+	defer signerLock.Unlock()
+	signer = s
+}
+
+// SetVerifier installs the verifier of the signatures of other nodes.
+func SetVerifier(v Verifier) {
+	signerLock.Lock()
+	defer signerLock.Unlock()
+	verifier = v
+}
+
+func getSigner() Signer {
+	signerLock.RLock()
+	defer signerLock.RUnlock()
+	return signer
+}
+
+func getVerifier() Verifier {
+	signerLock.RLock()
+	defer signerLock.RUnlock()
+	return verifier
+}
+
+// KeySigner signs with a private key held in memory.
+type KeySigner struct {
+	key crypto.PrivateKey
+}
+
+// NewKeySigner returns a signer for an ECDSA or Ed25519 private key.
+func NewKeySigner(key crypto.PrivateKey) (*KeySigner, error) {
+	switch key.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return &KeySigner{key: key}, nil
+	}
+	return nil, errors.New("[Signer Error] private key type not supported")
+}
+
+// NewFileSigner returns a signer for the private key of node id in etc/key.
+func NewFileSigner(id int64) (*KeySigner, error) {
+	privK, err := ioutil.ReadFile(GenPath(id) + "priv.key")
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(privK)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(key)
+}
+
+// Sign signs msg. ECDSA nonces are drawn from crypto/rand.
+func (s *KeySigner) Sign(msg []byte) ([]byte, error) {
+	switch k := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		return signECDSA(k, msg)
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, msg), nil
+	}
+	return nil, errors.New("[Signer Error] private key type not supported")
+}
+
+func (s *KeySigner) PublicKey() crypto.PublicKey {
+	switch k := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return nil
+}
+
+// The default verifier, with the public keys in etc/key.
+type keyFileVerifier struct{}
+
+func (keyFileVerifier) Verify(id int64, msg []byte, sig []byte) bool {
+	pubKey := FetchPublicKey(id)
+	if pubKey == nil {
+		return false
+	}
+	return verify(pubKey, msg, sig)
+}
+
+// Keyring holds the keys of a set of nodes in memory. It is meant for tests,
+// where every node of a run shares the keyring instead of key files.
+type Keyring struct {
+	signers map[int64]*KeySigner
+	sync.RWMutex
+}
+
+// NewKeyring generates a key of the scheme for every id.
+func NewKeyring(scheme SignatureScheme, ids ...int64) (*Keyring, error) {
+	k := &Keyring{signers: make(map[int64]*KeySigner)}
+	for _, id := range ids {
+		priBytes, _, err := generateKeyPair(scheme)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKey(priBytes)
+		if err != nil {
+			return nil, err
+		}
+		k.signers[id], err = NewKeySigner(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Signer returns the signer of node id, or nil if the keyring has no key for id.
+func (k *Keyring) Signer(id int64) Signer {
+	k.RLock()
+	defer k.RUnlock()
+	s, exist := k.signers[id]
+	if !exist {
+		return nil
+	}
+	return s
+}
+
+func (k *Keyring) Verify(id int64, msg []byte, sig []byte) bool {
+	k.RLock()
+	s, exist := k.signers[id]
+	k.RUnlock()
+	if !exist {
+		return false
+	}
+	return verify(s.PublicKey(), msg, sig)
+}
+
+// GenSig signs msg with the signer of this node.
+func GenSig(msg []byte) []byte {
+	s := getSigner()
+	if s == nil {
+		log.Println(ErrNoSigner)
+		return nil
+	}
+	signature, err := s.Sign(msg)
+	if err != nil {
+		log.Println(err)
+	}
+	return signature
+}
+
+// the prefix of the messages signed by votes, which no other signed message starts with
+var votePrefix = []byte("sleepy-hotstuff vote ")
+
+// VoteMsg returns the message signed by a vote for the block hash at (view, height):
+// the prefix, the view and the height as 8-byte big-endian integers, then the hash.
+func VoteMsg(view int, height int, hash []byte) []byte {
+	msg := make([]byte, len(votePrefix)+16, len(votePrefix)+16+len(hash))
+	copy(msg, votePrefix)
+	binary.BigEndian.PutUint64(msg[len(votePrefix):], uint64(view))
+	binary.BigEndian.PutUint64(msg[len(votePrefix)+8:], uint64(height))
+	return append(msg, hash...)
+}
+
+// IsVoteMsg reports whether msg is the message of a vote.
+func IsVoteMsg(msg []byte) bool {
+	return bytes.HasPrefix(msg, votePrefix)
+}
+
+// SignVote signs the vote for the hash of a block voted at (view, height).
+// A VoteSigner may refuse the vote, e.g., if it conflicts with an earlier one.
+func SignVote(view int, height int, hash []byte) ([]byte, error) {
+	s := getSigner()
+	if s == nil {
+		return nil, ErrNoSigner
+	}
+	if vs, ok := s.(VoteSigner); ok {
+		return vs.SignVote(view, height, hash)
+	}
+	return s.Sign(VoteMsg(view, height, hash))
+}
+
+// VerifySig checks a signature of node id with the installed verifier.
+func VerifySig(id int64, msg []byte, sig []byte) bool {
+	return getVerifier().Verify(id, msg, sig)
+}
+
+func signerError(format string, a ...interface{}) error {
+	return fmt.Errorf("[Signer Error] "+format, a...)
+}
@@ -0,0 +1,89 @@
+package cryptolib
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyring(test *testing.T) {
+	keyring, err := NewKeyring(Ed25519, 1, 2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	SetVerifier(keyring)
+	defer SetVerifier(keyFileVerifier{})
+
+	SetSigner(keyring.Signer(1))
+	sig := GenSig([]byte("block"))
+	if !VerifySig(1, []byte("block"), sig) {
+		test.Fatal("signature of 1 not verified")
+	}
+	if VerifySig(2, []byte("block"), sig) || VerifySig(3, []byte("block"), sig) {
+		test.Fatal("signature of 1 verified for another node")
+	}
+	if keyring.Signer(3) != nil {
+		test.Fatal("signer for a node without key")
+	}
+}
+
+func TestRemoteSigner(test *testing.T) {
+	keyring, _ := NewKeyring(P256, 1)
+	SetVerifier(keyring)
+	defer SetVerifier(keyFileVerifier{})
+
+	// the daemon refuses a second vote at the same height
+	voted := make(map[int][]byte)
+	conflict := errors.New("conflicting vote")
+	server := &SignerServer{
+		Signer: keyring.Signer(1),
+		CheckVote: func(view int, height int, hash []byte) error {
+			if h, exist := voted[height]; exist && !bytes.Equal(h, hash) {
+				return conflict
+			}
+			voted[height] = hash
+			return nil
+		},
+	}
+	socket := filepath.Join(test.TempDir(), "signer.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer lis.Close()
+	go server.Serve(lis)
+
+	remote, err := NewRemoteSigner(socket)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer remote.Close()
+	SetSigner(remote)
+
+	hashA := GenHash([]byte("A"))
+	hashB := GenHash([]byte("B"))
+	sig, err := SignVote(1, 5, hashA)
+	if err != nil || !VerifySig(1, VoteMsg(1, 5, hashA), sig) {
+		test.Fatalf("vote not signed: %v", err)
+	}
+	if _, err := SignVote(1, 5, hashA); err != nil {
+		test.Fatalf("same vote refused: %v", err)
+	}
+	if _, err := SignVote(2, 5, hashB); err == nil || err.Error() != conflict.Error() {
+		test.Fatalf("conflicting vote signed: %v", err)
+	}
+	if _, err := remote.Sign(VoteMsg(2, 5, hashB)); err == nil {
+		test.Fatal("vote signed without the vote check")
+	}
+	// a block hash is not a vote
+	if sig, err := remote.Sign(hashB); err != nil || VerifySig(1, VoteMsg(2, 5, hashB), sig) {
+		test.Fatalf("hash not signed, or signed as a vote: %v", err)
+	}
+
+	msg := []byte("a timeout message")
+	if !VerifySig(1, msg, GenSig(msg)) {
+		test.Fatal("message not signed by the daemon")
+	}
+}
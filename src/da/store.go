@@ -0,0 +1,266 @@
+/*
+Data availability of the batches of client requests.
+In data-availability mode, every replica puts the requests it receives from clients into batches
+and broadcasts them itself. The replicas that store a batch acknowledge it with a signature, and
+f+1 acknowledgements make an availability certificate (message.BatchCert): at least one correct
+replica stores the batch. Proposals then carry certificates instead of requests, and the requests
+of a committed block are fetched by digest from the replicas that acknowledged its batches.
+
+The Store keeps the batches received, by digest, and the certificates known, until they are
+committed. A certificate is pending until it is proposed, and proposed until it is committed;
+after a view change, the proposed certificates that are not committed are pending again.
+The batches of committed blocks are kept as long as the committed blocks, so that a replica that
+slept or recovered for long can still fetch them.
+*/
+
+package da
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/utils"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Batches without certificate and committed certificates are kept for this time.
+const keepTime = 10 * time.Minute
+
+var ErrDigest = errors.New("[DA Error] the digest does not match the batch")
+
+// Batch is a batch of client requests broadcast by its author.
+type Batch struct {
+	Author    int64
+	Seq       int
+	Requests  [][]byte
+	Added     int64 // unix time in ms
+	Committed bool  // in a committed block, never expired
+}
+
+// Digest of the batch seq of author.
+func Digest(author int64, seq int, requests [][]byte) []byte {
+	input := append(utils.StringToBytes(utils.Int64ToString(author)), utils.IntToBytes(seq)...)
+	for _, request := range requests {
+		input = append(input, cryptolib.GenHash(request)...)
+	}
+	return cryptolib.GenHash(input)
+}
+
+func Key(digest []byte) string {
+	return hex.EncodeToString(digest)
+}
+
+// Ack is the message a replica signs to acknowledge that it stores the batch digest.
+func Ack(signer int64, seq int, digest []byte) message.HotStuffMessage {
+	return message.HotStuffMessage{Mtype: pb.MessageType_BROADCAST, Source: signer, Seq: seq, Hash: digest}
+}
+
+// VerifyCert checks that the certificate has need acknowledgements of distinct replicas.
+func VerifyCert(cert message.BatchCert, need int) error {
+	if len(cert.Acks) < need {
+		return fmt.Errorf("[DA Error] %d acknowledgements for batch %x, %d needed", len(cert.Acks), cert.Digest, need)
+	}
+	signers := make(map[int64]bool)
+	items := make([]cryptolib.SigItem, len(cert.Acks))
+	for i := range cert.Acks {
+		ack := message.DeserializeHotStuffMessage(cert.Acks[i].Msg)
+		if ack.Mtype != pb.MessageType_BROADCAST || len(ack.OPS) > 0 || string(ack.Hash) != string(cert.Digest) {
+			return fmt.Errorf("[DA Error] acknowledgement %d is not for batch %x", i, cert.Digest)
+		}
+		if signers[ack.Source] {
+			return fmt.Errorf("[DA Error] replica %d acknowledges batch %x twice", ack.Source, cert.Digest)
+		}
+		signers[ack.Source] = true
+		items[i] = cryptolib.SigItem{ID: ack.Source, Msg: cert.Acks[i].Msg, Sig: cert.Acks[i].Sig}
+	}
+	ok := cryptolib.VerifyBatch(items)
+	for i := range ok {
+		if !ok[i] {
+			return fmt.Errorf("[DA Error] signature of replica %d not verified for batch %x", items[i].ID, cert.Digest)
+		}
+	}
+	return nil
+}
+
+// Signers of the acknowledgements of a certificate, the replicas to fetch its batch from.
+func Signers(cert message.BatchCert) []int64 {
+	var signers []int64
+	for i := range cert.Acks {
+		signers = append(signers, message.DeserializeHotStuffMessage(cert.Acks[i].Msg).Source)
+	}
+	return signers
+}
+
+type CertState struct {
+	Cert      message.BatchCert
+	Proposed  bool
+	Committed int64 // unix time in ms of the commit, 0 if not committed
+}
+
+// Store is not safe for concurrent use; the consensus loop owns it.
+type Store struct {
+	Seq     int              // sequence number of the last batch of this replica
+	Batches map[string]Batch // by key of the digest
+	Certs   map[string]*CertState
+	Order   []string // keys of the certificates in arrival order, with the ones removed since
+}
+
+func (s *Store) Init() {
+	s.Seq = 0
+	s.Batches = make(map[string]Batch)
+	s.Certs = make(map[string]*CertState)
+	s.Order = nil
+}
+
+func (s *Store) Serialize() ([]byte, error) {
+	s.compact()
+	return msgpack.Marshal(s)
+}
+
+func (s *Store) Deserialize(input []byte) error {
+	s.Init()
+	if err := msgpack.Unmarshal(input, s); err != nil {
+		return err
+	}
+	if s.Batches == nil {
+		s.Batches = make(map[string]Batch)
+	}
+	if s.Certs == nil {
+		s.Certs = make(map[string]*CertState)
+	}
+	return nil
+}
+
+// Drop the removed keys from Order once they are the majority.
+func (s *Store) compact() {
+	if len(s.Order) <= 2*len(s.Certs) {
+		return
+	}
+	var order []string
+	for _, key := range s.Order {
+		if _, exist := s.Certs[key]; exist {
+			order = append(order, key)
+		}
+	}
+	s.Order = order
+}
+
+// AddBatch stores a batch whose digest was checked, and returns its key.
+func (s *Store) AddBatch(b Batch, digest []byte, now time.Time) (string, error) {
+	if string(Digest(b.Author, b.Seq, b.Requests)) != string(digest) {
+		return "", ErrDigest
+	}
+	key := Key(digest)
+	if _, exist := s.Batches[key]; !exist {
+		b.Added = now.UnixMilli()
+		s.Batches[key] = b
+	}
+	return key, nil
+}
+
+func (s *Store) Batch(digest []byte) (Batch, bool) {
+	b, exist := s.Batches[Key(digest)]
+	return b, exist
+}
+
+// AddCert adds a verified certificate, and tells whether it was new.
+func (s *Store) AddCert(cert message.BatchCert) bool {
+	key := Key(cert.Digest)
+	if _, exist := s.Certs[key]; exist {
+		return false
+	}
+	s.Certs[key] = &CertState{Cert: cert}
+	s.Order = append(s.Order, key)
+	return true
+}
+
+// HasPending tells whether a certificate waits to be proposed.
+func (s *Store) HasPending() bool {
+	for _, c := range s.Certs {
+		if !c.Proposed && c.Committed == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Take marks at most max pending certificates, the oldest first, as proposed, and returns them.
+func (s *Store) Take(max int) []message.BatchCert {
+	var certs []message.BatchCert
+	for _, key := range s.Order {
+		if max > 0 && len(certs) >= max {
+			break
+		}
+		c, exist := s.Certs[key]
+		if !exist || c.Proposed || c.Committed > 0 {
+			continue
+		}
+		c.Proposed = true
+		certs = append(certs, c.Cert)
+	}
+	return certs
+}
+
+// MarkProposed marks the known certificates of a block proposed by another leader. The block is
+// not voted for yet, so its other certificates are not added.
+func (s *Store) MarkProposed(certs []message.BatchCert) {
+	for _, cert := range certs {
+		if c, exist := s.Certs[Key(cert.Digest)]; exist {
+			c.Proposed = true
+		}
+	}
+}
+
+// Commit marks the certificates of a committed block, which are not proposed again, and keeps
+// their batches.
+func (s *Store) Commit(certs []message.BatchCert, now time.Time) {
+	for _, cert := range certs {
+		s.AddCert(cert)
+		key := Key(cert.Digest)
+		s.Certs[key].Committed = now.UnixMilli()
+		if b, exist := s.Batches[key]; exist {
+			b.Committed = true
+			s.Batches[key] = b
+		}
+	}
+}
+
+// Requeue makes the proposed certificates that are not committed pending again, except the ones
+// of keep, and returns their number.
+func (s *Store) Requeue(keep []message.BatchCert) int {
+	kept := make(map[string]bool)
+	for _, cert := range keep {
+		kept[Key(cert.Digest)] = true
+	}
+	num := 0
+	for key, c := range s.Certs {
+		if c.Proposed && c.Committed == 0 && !kept[key] {
+			c.Proposed = false
+			num++
+		}
+	}
+	return num
+}
+
+// Expire forgets the certificates committed before the keep time, and the batches received before
+// it without a certificate left, except the ones of committed blocks.
+func (s *Store) Expire(now time.Time) {
+	oldest := now.Add(-keepTime).UnixMilli()
+	for key, c := range s.Certs {
+		if c.Committed > 0 && c.Committed < oldest {
+			delete(s.Certs, key)
+		}
+	}
+	// the batch of a committed certificate is kept while the certificate is.
+	for key, b := range s.Batches {
+		if _, certified := s.Certs[key]; !certified && !b.Committed && b.Added < oldest {
+			delete(s.Batches, key)
+		}
+	}
+	s.compact()
+}
@@ -0,0 +1,61 @@
+package da
+
+import (
+	"sleepy-hotstuff/src/message"
+	"testing"
+	"time"
+)
+
+func TestStore(test *testing.T) {
+	var s Store
+	s.Init()
+	now := time.Now()
+	requests := [][]byte{[]byte("a"), []byte("b")}
+	digest := Digest(1, 1, requests)
+	if _, err := s.AddBatch(Batch{Author: 1, Seq: 2, Requests: requests}, digest, now); err != ErrDigest {
+		test.Fatalf("batch with another digest: %v", err)
+	}
+	if _, err := s.AddBatch(Batch{Author: 1, Seq: 1, Requests: requests}, digest, now); err != nil {
+		test.Fatal(err)
+	}
+	other := Digest(2, 1, nil)
+	s.AddCert(message.BatchCert{Author: 1, Seq: 1, Digest: digest})
+	s.AddCert(message.BatchCert{Author: 2, Seq: 1, Digest: other})
+	if s.AddCert(message.BatchCert{Author: 1, Seq: 1, Digest: digest}) {
+		test.Fatal("a certificate is added twice")
+	}
+
+	// certificates are proposed once, the oldest first, until a view change
+	if certs := s.Take(1); len(certs) != 1 || Key(certs[0].Digest) != Key(digest) {
+		test.Fatalf("proposed %+v", certs)
+	}
+	s.MarkProposed([]message.BatchCert{{Digest: other}, {Digest: Digest(3, 1, nil)}})
+	if s.HasPending() || len(s.Certs) != 2 {
+		test.Fatal("a certificate proposed by another leader is pending, or an unknown one was added")
+	}
+	s.Commit([]message.BatchCert{{Author: 1, Seq: 1, Digest: digest}}, now)
+	if num := s.Requeue([]message.BatchCert{{Digest: other}}); num != 0 {
+		test.Fatalf("%d certificates proposed again, with their block on the branch", num)
+	}
+	if num := s.Requeue(nil); num != 1 || len(s.Take(0)) != 1 {
+		test.Fatalf("%d certificates proposed again", num)
+	}
+
+	// the store survives a restart
+	ser, _ := s.Serialize()
+	var restored Store
+	if err := restored.Deserialize(ser); err != nil || len(restored.Certs) != 2 || len(restored.Batches) != 1 {
+		test.Fatalf("restored %d certificates, %d batches: %v", len(restored.Certs), len(restored.Batches), err)
+	}
+
+	// the committed certificate is forgotten after the keep time, its batch is kept
+	uncertified := Digest(3, 1, requests)
+	restored.AddBatch(Batch{Author: 3, Seq: 1, Requests: requests}, uncertified, now)
+	restored.Expire(now.Add(keepTime + time.Second))
+	if _, exist := restored.Batch(digest); !exist || len(restored.Certs) != 1 {
+		test.Fatalf("batch of a committed block forgotten, or %d certificates left", len(restored.Certs))
+	}
+	if _, exist := restored.Batch(uncertified); exist {
+		test.Fatal("batch without certificate kept")
+	}
+}
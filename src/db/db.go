@@ -12,6 +12,9 @@ var localDB *leveldb.DB
 
 var LocalDB *leveldb.DB
 
+// ErrNotFound is returned by ReadDB and RecoverValue when the key has never been written.
+var ErrNotFound = leveldb.ErrNotFound
+
 func StartDB(id string) error {
 	exepath, err := os.Executable()
 	if err != nil {
@@ -20,8 +23,7 @@ func StartDB(id string) error {
 	}
 
 	homepath := path.Dir(exepath)
-	localDB, err = leveldb.OpenFile(homepath+"/etc/DBFile/"+id, nil)
-	LocalDB = localDB
+	err = OpenDB(homepath + "/etc/DBFile/" + id)
 	if err != nil {
 		log.Fatal(err)
 		return err
@@ -29,35 +31,61 @@ func StartDB(id string) error {
 	return nil
 }
 
-func clearDB() {
+// OpenDB opens (or creates) the database in the directory dbpath
+// and migrates it to the current schema version.
+func OpenDB(dbpath string) error {
+	var err error
+	localDB, err = leveldb.OpenFile(dbpath, nil)
+	LocalDB = localDB
+	if err != nil {
+		return err
+	}
+	err = migrate()
+	if err != nil {
+		localDB.Close()
+	}
+	return err
+}
+
+// ClearDB removes every value of the local database.
+// A replica that does not restart from disk clears the stale state of the previous run.
+func ClearDB() {
 	batch := new(leveldb.Batch)
 	iter := localDB.NewIterator(nil, nil)
 	for iter.Next() {
 		key := iter.Key()
 		batch.Delete(key)
 	}
+	iter.Release()
+	writeSchemaVersion(batch, SchemaVersion)
 	err := localDB.Write(batch, nil)
 	if err != nil {
 		log.Fatalf("clear database failed: %v", err)
 	}
-	iter.Release()
 }
 
+// CloseDB closes the database without clearing it,
+// so that the persisted state survives for a restart from disk.
 func CloseDB() {
-	// clear the database
-	clearDB()
-	// close the database
 	err := localDB.Close()
 	if err != nil {
-		log.Println("Error closing the database: %v", err)
+		log.Printf("Error closing the database: %v", err)
 	}
 }
 
+func writeBatch(batch *leveldb.Batch) error {
+	return localDB.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
 func WriteDB(key string, value DBValue) error {
 	var valueSer, err = value.Serialize()
 	if err != nil {
 		return err
 	}
+	valueSer, err = wrap(SchemaVersion, valueSer)
+	if err != nil {
+		return err
+	}
 	wo := &opt.WriteOptions{
 		Sync: true,
 	}
@@ -68,11 +96,24 @@ func WriteDB(key string, value DBValue) error {
 	return nil
 }
 
+// HasValue checks whether the key has been written to the database.
+func HasValue(key string) bool {
+	exist, err := localDB.Has([]byte(key), nil)
+	if err != nil {
+		return false
+	}
+	return exist
+}
+
 func ReadDB(key string, value DBValue) error {
 	valueSer, err := localDB.Get([]byte(key), nil)
 	if err != nil {
 		return err
 	}
+	valueSer, err = unwrap(SchemaVersion, valueSer)
+	if err != nil {
+		return err
+	}
 	err = value.Deserialize(valueSer)
 	if err != nil {
 		return err
@@ -68,7 +68,7 @@ func RecoverValue(key string, value DBValue) error {
 			err := ReadDB(key, value)
 			return err
 		} else {
-			msg := fmt.Sprintf("The %s is not stored in the database for the persistLevel %", key, plevel)
+			msg := fmt.Sprintf("The %s is not stored in the database for the persistLevel %d", key, plevel)
 			return errors.New(msg)
 		}
 	}
@@ -76,7 +76,7 @@ func RecoverValue(key string, value DBValue) error {
 		err := ReadDB(key, value)
 		return err
 	} else {
-		msg := fmt.Sprintf("The PersistLevelType in config: %s is not planned.", plevel)
+		msg := fmt.Sprintf("The PersistLevelType in config: %d is not planned.", plevel)
 		return errors.New(msg)
 	}
 }
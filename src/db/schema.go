@@ -0,0 +1,198 @@
+/*
+Versioning of the database layout.
+Every value is stored in an envelope that records the schema version it was written with,
+and the schema version of the database is stored under SchemaKey.
+When a database written with an older layout is opened, the registered migrations
+upgrade it one version at a time before any value is read. A migration rewrites only the
+values whose layout changed; the others keep the version of their envelope, and are read
+as they are, since the layout of a value is the same in every version up to SchemaVersion
+unless a migration rewrote it.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// SchemaVersion is the layout written by this code.
+// Version 0: bare msgpack blobs of the Go values.
+// Version 1: every value is wrapped in an envelope.
+// Version 2: the client requests are stored as a mempool under "mempool" instead of "queue",
+// see the migration of the consensus package.
+const SchemaVersion = 2
+
+// SchemaKey is the key of the schema version. It is stored as a decimal string, without envelope.
+const SchemaKey = "schemaVersion"
+
+var ErrNewerSchema = errors.New("[DB Error] the database was written by a newer schema version")
+
+type envelope struct {
+	Version int    `msgpack:"v"`
+	Data    []byte `msgpack:"d"`
+}
+
+func wrap(version int, data []byte) ([]byte, error) {
+	return msgpack.Marshal(&envelope{Version: version, Data: data})
+}
+
+// Get the data of an envelope written with a schema version up to version.
+func unwrap(version int, raw []byte) ([]byte, error) {
+	var e envelope
+	err := msgpack.Unmarshal(raw, &e)
+	if err != nil {
+		return nil, fmt.Errorf("[DB Error] value is not in a schema envelope: %v", err)
+	}
+	if e.Version > version {
+		return nil, fmt.Errorf("[DB Error] value written with schema version %d, expected at most %d", e.Version, version)
+	}
+	return e.Data, nil
+}
+
+// Migration upgrades the database from schema version From to From+1, by rewriting the
+// values whose layout changed.
+type Migration struct {
+	From  int
+	Name  string
+	Apply func(m *Migrator) error
+}
+
+var migrations = map[int]Migration{
+	0: {From: 0, Name: "wrap values in a versioned envelope", Apply: wrapValues},
+}
+
+// RegisterMigration adds the migration from m.From to m.From+1.
+// Packages that change the layout of a stored value register their migration in init,
+// so it is in place before StartDB.
+func RegisterMigration(m Migration) {
+	if _, exist := migrations[m.From]; exist {
+		log.Fatalf("[DB Error] duplicated migration from schema version %d", m.From)
+	}
+	migrations[m.From] = m
+}
+
+// Migrator gives a migration access to the values in the layout of version from.
+// Reads see the database before the migration; writes are applied atomically with the new version.
+type Migrator struct {
+	from  int
+	db    *leveldb.DB
+	batch *leveldb.Batch
+}
+
+// Keys returns the keys of all stored values.
+func (m *Migrator) Keys() []string {
+	var keys []string
+	iter := m.db.NewIterator(nil, nil)
+	for iter.Next() {
+		if string(iter.Key()) != SchemaKey {
+			keys = append(keys, string(iter.Key()))
+		}
+	}
+	iter.Release()
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the serialized value of key, as written by the Serialize method of version from.
+func (m *Migrator) Get(key string) ([]byte, error) {
+	raw, err := m.db.Get([]byte(key), nil)
+	if err != nil || m.from == 0 {
+		return raw, err
+	}
+	return unwrap(m.from, raw)
+}
+
+// Put stores the serialized value of key in the layout of version from+1.
+func (m *Migrator) Put(key string, data []byte) error {
+	raw, err := wrap(m.from+1, data)
+	if err != nil {
+		return err
+	}
+	m.batch.Put([]byte(key), raw)
+	return nil
+}
+
+func (m *Migrator) Delete(key string) {
+	m.batch.Delete([]byte(key))
+}
+
+// Version 0 to 1: the values keep their content and get an envelope.
+func wrapValues(m *Migrator) error {
+	for _, key := range m.Keys() {
+		data, err := m.Get(key)
+		if err != nil {
+			return err
+		}
+		err = m.Put(key, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoredSchemaVersion returns the schema version of the open database.
+// A database with values but without version was written before versioning (version 0).
+func StoredSchemaVersion() (int, error) {
+	v, err := localDB.Get([]byte(SchemaKey), nil)
+	if err == nil {
+		return strconv.Atoi(string(v))
+	}
+	if err != leveldb.ErrNotFound {
+		return 0, err
+	}
+	iter := localDB.NewIterator(nil, nil)
+	empty := !iter.Next()
+	iter.Release()
+	if empty {
+		return SchemaVersion, nil
+	}
+	return 0, nil
+}
+
+func writeSchemaVersion(batch *leveldb.Batch, version int) {
+	batch.Put([]byte(SchemaKey), []byte(strconv.Itoa(version)))
+}
+
+// migrate upgrades the open database to SchemaVersion.
+func migrate() error {
+	version, err := StoredSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return ErrNewerSchema
+	}
+	for version < SchemaVersion {
+		mig, exist := migrations[version]
+		if !exist {
+			return fmt.Errorf("[DB Error] no migration from schema version %d", version)
+		}
+		log.Printf("[DB] migrating from schema version %d: %s", version, mig.Name)
+		m := &Migrator{from: version, db: localDB, batch: new(leveldb.Batch)}
+		err = mig.Apply(m)
+		if err != nil {
+			return fmt.Errorf("[DB Error] migration from schema version %d failed: %v", version, err)
+		}
+		version++
+		writeSchemaVersion(m.batch, version)
+		err = writeBatch(m.batch)
+		if err != nil {
+			return err
+		}
+	}
+	// a new database records its version, so that it is not taken for version 0 later
+	if !HasValue(SchemaKey) {
+		batch := new(leveldb.Batch)
+		writeSchemaVersion(batch, SchemaVersion)
+		return writeBatch(batch)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package db_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sleepy-hotstuff/src/consensus"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/mempool"
+	"sleepy-hotstuff/src/message"
+	"sleepy-hotstuff/src/utils"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Write the raw key/values of a fixture to a new database and open it.
+func openFixture(test *testing.T, fixture string) {
+	f, err := os.ReadFile("testdata/" + fixture)
+	if err != nil {
+		test.Fatal(err)
+	}
+	var kv map[string]string
+	err = json.Unmarshal(f, &kv)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dir := test.TempDir()
+	raw, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for k, v := range kv {
+		value, err := hex.DecodeString(v)
+		if err != nil {
+			test.Fatal(err)
+		}
+		raw.Put([]byte(k), value, nil)
+	}
+	raw.Close()
+
+	err = db.OpenDB(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+}
+
+// The values stored in the fixtures.
+func checkFixtureValues(test *testing.T) {
+	var seq, view utils.IntValue
+	// Sequence is not rewritten by the migration, and is read in its envelope of version 1
+	if err := db.ReadDB("Sequence", &seq); err != nil || seq.Get() != 7 {
+		test.Fatalf("Sequence: %v %v", seq.Get(), err)
+	}
+	if err := db.ReadDB("view", &view); err != nil || view.Get() != 2 {
+		test.Fatalf("view: %v %v", view.Get(), err)
+	}
+
+	var ch utils.ByteValue
+	if err := db.ReadDB("curHash", &ch); err != nil || string(ch.Get()) != "hash6" {
+		test.Fatalf("curHash: %s %v", ch.Get(), err)
+	}
+
+	var cur message.QCBlock
+	if err := db.ReadDB("curBlock", &cur); err != nil {
+		test.Fatal(err)
+	}
+	if cur.View != 1 || cur.Height != 6 || string(cur.Hash) != "hash6" || string(cur.PreHash) != "hash5" ||
+		string(cur.PrePreHash) != "hash4" || len(cur.QC) != 2 || !bytes.Equal(cur.QC[1], []byte("sig1")) ||
+		len(cur.IDs) != 2 || cur.IDs[1] != 1 {
+		test.Fatalf("curBlock: %+v", cur)
+	}
+
+	var cb utils.IntByteMap
+	cb.Init()
+	if err := db.ReadDB("committedBlocks", &cb); err != nil || cb.GetLen() != 2 {
+		test.Fatalf("committedBlocks: %v %v", cb.GetLen(), err)
+	}
+	bser, _ := cb.Get(2)
+	if b := message.DeserializeQCBlock(bser); b.Height != 2 || string(b.Hash) != "hash2" {
+		test.Fatalf("committed block 2: %+v", b)
+	}
+
+	var q consensus.Queue
+	q.Init()
+	if err := db.ReadDB("MsgQueue", &q); err != nil || q.Length() != 2 {
+		test.Fatalf("MsgQueue: %v %v", q.Length(), err)
+	}
+	if msgs := q.Grab(); string(msgs[1].GetMsg()) != "m2" {
+		test.Fatalf("MsgQueue: %s", msgs[1].GetMsg())
+	}
+
+	var vc utils.IntIntMap
+	vc.Init()
+	if err := db.ReadDB("vcAwaitingVotes", &vc); err != nil {
+		test.Fatal(err)
+	}
+	if v, _ := vc.Get(3); v != 2 {
+		test.Fatalf("vcAwaitingVotes: %v", vc.GetAll())
+	}
+}
+
+// v0.json was written before the values had an envelope.
+func TestMigrateV0(test *testing.T) {
+	openFixture(test, "v0.json")
+	defer db.CloseDB()
+	version, err := db.StoredSchemaVersion()
+	if err != nil || version != db.SchemaVersion {
+		test.Fatalf("schema version %d after migration: %v", version, err)
+	}
+	checkFixtureValues(test)
+}
+
+func TestLoadV1(test *testing.T) {
+	openFixture(test, "v1.json")
+	defer db.CloseDB()
+	checkFixtureValues(test)
+}
+
+func TestNewerSchema(test *testing.T) {
+	dir := test.TempDir()
+	raw, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	raw.Put([]byte(db.SchemaKey), []byte("99"), nil)
+	raw.Close()
+
+	if err := db.OpenDB(dir); err != db.ErrNewerSchema {
+		test.Fatalf("opening a database of a newer schema: %v", err)
+	}
+}
+
+func TestNewDB(test *testing.T) {
+	dir := test.TempDir()
+	if err := db.OpenDB(dir); err != nil {
+		test.Fatal(err)
+	}
+	var seq utils.IntValue
+	seq.Set(3)
+	db.WriteDB("Sequence", &seq)
+	db.ClearDB()
+	if db.HasValue("Sequence") {
+		test.Fatal("value left after ClearDB")
+	}
+	db.CloseDB()
+
+	// the version is kept by ClearDB, so the database is not taken for version 0
+	if err := db.OpenDB(dir); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	version, err := db.StoredSchemaVersion()
+	if err != nil || version != db.SchemaVersion {
+		test.Fatalf("schema version %d of a new database: %v", version, err)
+	}
+}
+
+// The queue of client requests of schema version 1 becomes the mempool, with each request once.
+func TestMigrateQueue(test *testing.T) {
+	var q consensus.Queue
+	q.Init()
+	for _, op := range []string{"a", "b", "a"} {
+		cr := message.ClientRequest{ID: 5, OP: []byte(op)}
+		crser, _ := cr.Serialize()
+		r, _ := message.SerializeWithSignature(5, crser)
+		q.Append(r)
+	}
+	qser, _ := q.Serialize()
+	var seq utils.IntValue
+	seq.Set(7)
+	sser, _ := seq.Serialize()
+
+	dir := test.TempDir()
+	raw, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for key, data := range map[string][]byte{"queue": qser, "Sequence": sser} {
+		value, _ := msgpack.Marshal(map[string]interface{}{"v": 1, "d": data})
+		raw.Put([]byte(key), value, nil)
+	}
+	raw.Put([]byte(db.SchemaKey), []byte("1"), nil)
+	raw.Close()
+
+	if err := db.OpenDB(dir); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	var pool mempool.Mempool
+	pool.Init(mempool.Limits{})
+	if err := db.ReadDB("mempool", &pool); err != nil || pool.Len() != 2 || db.HasValue("queue") {
+		test.Fatalf("mempool of %d requests: %v", pool.Len(), err)
+	}
+	if err := db.ReadDB("Sequence", &seq); err != nil || seq.Get() != 7 {
+		test.Fatalf("Sequence: %v %v", seq.Get(), err)
+	}
+}
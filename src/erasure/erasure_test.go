@@ -0,0 +1,72 @@
+package erasure
+
+import (
+	"bytes"
+	"sleepy-hotstuff/src/cryptolib"
+	"testing"
+)
+
+func TestCode(test *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for _, params := range [][2]int{{1, 1}, {2, 4}, {3, 7}, {5, 16}} {
+		k, n := params[0], params[1]
+		shards, err := Encode(data, k, n)
+		if err != nil {
+			test.Fatal(err)
+		}
+		// any k shards decode the data: here the last k, so that parity shards are used
+		missing := make([][]byte, n)
+		copy(missing[n-k:], shards[n-k:])
+		decoded, err := Decode(missing, k, len(data))
+		if err != nil || !bytes.Equal(decoded, data) {
+			test.Fatalf("k=%d n=%d: decoded %d bytes, %v", k, n, len(decoded), err)
+		}
+		if k > 1 {
+			missing[n-1] = nil
+			if _, err := Decode(missing, k, len(data)); err != ErrShards {
+				test.Fatalf("k=%d n=%d: decoded from %d shards", k, n, k-1)
+			}
+		}
+	}
+	if _, err := Encode(data, 3, MaxShards+1); err == nil {
+		test.Fatal("encoded into too many shards")
+	}
+}
+
+func TestFragments(test *testing.T) {
+	data := []byte("a proposal broadcast with erasure coding")
+	k, n := 2, 4
+	root, frags, err := Split(data, k, n)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, f := range frags {
+		if !f.Verify(root) {
+			test.Fatalf("fragment %d not verified", f.Index)
+		}
+	}
+	moved := frags[1]
+	moved.Index = 2
+	corrupted := frags[3]
+	corrupted.Data = append([]byte{corrupted.Data[0] ^ 1}, corrupted.Data[1:]...)
+	if moved.Verify(root) || corrupted.Verify(root) {
+		test.Fatal("a fragment of another index or with other data is verified")
+	}
+	decoded, err := Reconstruct([]Fragment{frags[3], frags[1]}, k, n, len(data), root)
+	if err != nil || !bytes.Equal(decoded, data) {
+		test.Fatalf("reconstructed %q, %v", decoded, err)
+	}
+
+	// a broadcaster mixing the shards of two messages under one root
+	other, _ := Encode(bytes.ToUpper(data), k, n)
+	mixed, _ := Encode(data, k, n)
+	mixed[0] = other[0]
+	root = cryptolib.GenMerkleTreeRoot(leaves(mixed))
+	picked := []Fragment{{Index: 0, Data: mixed[0]}, {Index: 2, Data: mixed[2]}}
+	if _, err := Reconstruct(picked, k, n, len(data), root); err != ErrRoot {
+		test.Fatalf("inconsistent shards reconstructed: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/utils"
+)
+
+var ErrRoot = errors.New("[Erasure Error] the shards do not match the Merkle root")
+
+// Fragment is the shard Index of a message, with its Merkle proof.
+type Fragment struct {
+	Index   int
+	Data    []byte
+	Branch  [][]byte
+	Indexes []int64
+}
+
+// The leaves of the Merkle tree hold the index with the shard, so that equal shards differ.
+func leaf(index int, data []byte) []byte {
+	return append(utils.IntToBytes(index), data...)
+}
+
+func leaves(shards [][]byte) [][]byte {
+	result := make([][]byte, len(shards))
+	for i := range shards {
+		result[i] = leaf(i, shards[i])
+	}
+	return result
+}
+
+// Split encodes data into n fragments, any k of which give it back, and returns the Merkle root
+// of the fragments.
+func Split(data []byte, k int, n int) ([]byte, []Fragment, error) {
+	shards, err := Encode(data, k, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	input := leaves(shards)
+	root := cryptolib.GenMerkleTreeRoot(input)
+	branches, indexes := cryptolib.ObtainMerklePath(input)
+	frags := make([]Fragment, n)
+	for i := range shards {
+		frags[i] = Fragment{Index: i, Data: shards[i], Branch: branches[i], Indexes: indexes[i]}
+	}
+	return root, frags, nil
+}
+
+// Verify checks that the fragment is the shard Index of the message with the Merkle root.
+func (f Fragment) Verify(root []byte) bool {
+	return cryptolib.VerifyMerklePath(root, leaf(f.Index, f.Data), f.Branch, f.Indexes)
+}
+
+// Reconstruct gives back the message of size bytes from k verified fragments of the n. The
+// message is encoded again and checked against the root: a broadcaster that sent shards of no
+// single message is detected, and every correct replica then refuses the message.
+func Reconstruct(frags []Fragment, k int, n int, size int, root []byte) ([]byte, error) {
+	shards := make([][]byte, n)
+	for _, f := range frags {
+		if f.Index >= 0 && f.Index < n {
+			shards[f.Index] = f.Data
+		}
+	}
+	data, err := Decode(shards, k, size)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := Encode(data, k, n)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cryptolib.GenMerkleTreeRoot(leaves(encoded)), root) {
+		return nil, ErrRoot
+	}
+	return data, nil
+}
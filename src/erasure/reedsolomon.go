@@ -0,0 +1,149 @@
+/*
+Erasure coding of messages.
+A message is split into k data shards and extended to n shards with a systematic Reed-Solomon
+code over GF(2^8): the shard of index i holds the values at the point i of the polynomials of
+degree k-1 that take the bytes of the data shards at the points 0..k-1. Any k shards give the
+polynomials back, and so the message. A Fragment is a shard with the Merkle proof that it belongs
+to the root of all the shards, so that a replica can check it alone.
+*/
+
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The points are the indexes of the shards, so there are at most 256 shards.
+const MaxShards = 256
+
+var ErrShards = errors.New("[Erasure Error] not enough shards to decode")
+
+// GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1.
+var expTable [510]byte
+var logTable [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[logTable[a]+logTable[b]]
+}
+
+func div(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[logTable[a]+255-logTable[b]]
+}
+
+// The coefficients of the values at the points xs in the value at the point x, by Lagrange
+// interpolation. Subtraction is addition in GF(2^8).
+func lagrange(xs []byte, x byte) []byte {
+	coefs := make([]byte, len(xs))
+	for m := range xs {
+		c := byte(1)
+		for l := range xs {
+			if l != m {
+				c = mul(c, div(x^xs[l], xs[m]^xs[l]))
+			}
+		}
+		coefs[m] = c
+	}
+	return coefs
+}
+
+// The shards of the points out, from the shards of the distinct points xs.
+func interpolate(xs []byte, shards [][]byte, out []int) [][]byte {
+	result := make([][]byte, len(out))
+	for j, x := range out {
+		shard := make([]byte, len(shards[0]))
+		for m, c := range lagrange(xs, byte(x)) {
+			if c == 0 {
+				continue
+			}
+			for b, v := range shards[m] {
+				shard[b] ^= mul(c, v)
+			}
+		}
+		result[j] = shard
+	}
+	return result
+}
+
+func checkParams(k int, n int) error {
+	if k < 1 || n < k || n > MaxShards {
+		return fmt.Errorf("[Erasure Error] cannot code %d data shards into %d shards", k, n)
+	}
+	return nil
+}
+
+// Encode splits data into n shards of equal size, the first k being the data padded with zeros.
+func Encode(data []byte, k int, n int) ([][]byte, error) {
+	if err := checkParams(k, n); err != nil {
+		return nil, err
+	}
+	size := (len(data) + k - 1) / k
+	if size == 0 {
+		size = 1
+	}
+	padded := make([]byte, size*k)
+	copy(padded, data)
+	shards := make([][]byte, n)
+	xs := make([]byte, k)
+	for i := 0; i < k; i++ {
+		shards[i] = padded[i*size : (i+1)*size]
+		xs[i] = byte(i)
+	}
+	var parity []int
+	for i := k; i < n; i++ {
+		parity = append(parity, i)
+	}
+	copy(shards[k:], interpolate(xs, shards[:k], parity))
+	return shards, nil
+}
+
+// Decode gives back the first size bytes of the data from the shards by index, nil when missing.
+// Any k shards of the same size are enough.
+func Decode(shards [][]byte, k int, size int) ([]byte, error) {
+	if err := checkParams(k, len(shards)); err != nil {
+		return nil, err
+	}
+	var xs []byte
+	var known [][]byte
+	for i, shard := range shards {
+		if shard == nil || (len(known) > 0 && len(shard) != len(known[0])) {
+			continue
+		}
+		xs = append(xs, byte(i))
+		known = append(known, shard)
+		if len(known) == k {
+			break
+		}
+	}
+	if len(known) < k || size > k*len(known[0]) {
+		return nil, ErrShards
+	}
+	data := make([]byte, 0, k*len(known[0]))
+	for i := 0; i < k; i++ {
+		if shards[i] != nil && len(shards[i]) == len(known[0]) {
+			data = append(data, shards[i]...)
+			continue
+		}
+		data = append(data, interpolate(xs, known, []int{i})[0]...)
+	}
+	return data[:size], nil
+}
@@ -0,0 +1,162 @@
+/*
+Evidence of equivocation.
+A replica equivocates if it signs two conflicting messages: two proposals or two votes for
+different blocks at the same view and height, or two timeouts of the same view that report
+different QCs. The detector keeps the first signed message of every (type, source, view,
+height), and when a conflicting one arrives, the two signed messages form an Evidence that
+anyone holding the public keys can verify on its own.
+*/
+
+package evidence
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Kind int
+
+const (
+	DoubleProposal Kind = iota
+	DoubleVote
+	DoubleTimeout
+)
+
+func (k Kind) String() string {
+	switch k {
+	case DoubleProposal:
+		return "DoubleProposal"
+	case DoubleVote:
+		return "DoubleVote"
+	case DoubleTimeout:
+		return "DoubleTimeout"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+func (k Kind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+var ErrInvalid = errors.New("[Evidence Error] the messages are not a proof of equivocation")
+
+// Evidence holds two signed messages of Culprit that conflict.
+type Evidence struct {
+	Kind    Kind
+	Culprit int64
+	View    int
+	Height  int
+	First   message.MessageWithSignature
+	Second  message.MessageWithSignature
+}
+
+func (e *Evidence) Serialize() ([]byte, error) {
+	return msgpack.Marshal(e)
+}
+
+func Deserialize(input []byte) (Evidence, error) {
+	var e Evidence
+	err := msgpack.Unmarshal(input, &e)
+	return e, err
+}
+
+// ID identifies the equivocation, whatever the order of the two messages.
+func (e *Evidence) ID() string {
+	return fmt.Sprintf("%s/%d/%d/%d", e.Kind, e.Culprit, e.View, e.Height)
+}
+
+// The kind of equivocation a message type can be part of.
+func kindOf(mtype pb.MessageType) (Kind, bool) {
+	switch mtype {
+	case pb.MessageType_QC:
+		return DoubleProposal, true
+	case pb.MessageType_QCREP:
+		return DoubleVote, true
+	case pb.MessageType_TIMEOUT:
+		return DoubleTimeout, true
+	}
+	return 0, false
+}
+
+// Reports whether two messages of the same kind, source, view and height conflict.
+func conflict(kind Kind, a message.HotStuffMessage, b message.HotStuffMessage) bool {
+	if kind == DoubleTimeout {
+		return !bytes.Equal(a.QC, b.QC)
+	}
+	return !bytes.Equal(a.Hash, b.Hash)
+}
+
+func height(kind Kind, content message.HotStuffMessage) int {
+	if kind == DoubleTimeout {
+		return 0
+	}
+	return content.Seq
+}
+
+// Verify checks that the evidence proves an equivocation of Culprit.
+func (e *Evidence) Verify() error {
+	a := message.DeserializeHotStuffMessage(e.First.Msg)
+	b := message.DeserializeHotStuffMessage(e.Second.Msg)
+	kind, ok := kindOf(a.Mtype)
+	if !ok || kind != e.Kind || a.Mtype != b.Mtype {
+		return ErrInvalid
+	}
+	if a.Source != e.Culprit || b.Source != e.Culprit || a.View != e.View || b.View != e.View {
+		return ErrInvalid
+	}
+	if height(kind, a) != e.Height || height(kind, b) != e.Height || !conflict(kind, a, b) {
+		return ErrInvalid
+	}
+	if !e.signed(e.First) || !e.signed(e.Second) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Reports whether the message is signed by Culprit. The signature of a vote must also be
+// valid for its view and height, which is what a remote signer checks before signing.
+func (e *Evidence) signed(m message.MessageWithSignature) bool {
+	if !cryptolib.VerifySig(e.Culprit, m.Msg, m.Sig) {
+		return false
+	}
+	if e.Kind != DoubleVote {
+		return true
+	}
+	vote := message.DeserializeHotStuffMessage(m.Msg)
+	return cryptolib.VerifySig(e.Culprit, cryptolib.VoteMsg(vote.View, vote.Seq, vote.Hash), vote.Sig)
+}
+
+func (e *Evidence) firstSigned() bool {
+	return e.signed(e.First)
+}
+
+// Report is the JSON form of an evidence, as given by the admin API.
+type Report struct {
+	ID      string
+	Kind    Kind
+	Culprit int64
+	View    int
+	Height  int
+	Hashes  [2]string // hashes of the conflicting blocks, or of the QCs for timeouts
+	First   message.MessageWithSignature
+	Second  message.MessageWithSignature
+}
+
+func (e *Evidence) Report() Report {
+	a := message.DeserializeHotStuffMessage(e.First.Msg)
+	b := message.DeserializeHotStuffMessage(e.Second.Msg)
+	r := Report{ID: e.ID(), Kind: e.Kind, Culprit: e.Culprit, View: e.View, Height: e.Height, First: e.First, Second: e.Second}
+	if e.Kind == DoubleTimeout {
+		r.Hashes = [2]string{fmt.Sprintf("%x", cryptolib.GenHash(a.QC)), fmt.Sprintf("%x", cryptolib.GenHash(b.QC))}
+	} else {
+		r.Hashes = [2]string{fmt.Sprintf("%x", a.Hash), fmt.Sprintf("%x", b.Hash)}
+	}
+	return r
+}
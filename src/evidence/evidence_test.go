@@ -0,0 +1,117 @@
+package evidence
+
+import (
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"testing"
+)
+
+// Sign a message of node source with the keyring.
+func sign(test *testing.T, keyring *cryptolib.Keyring, content message.HotStuffMessage) message.MessageWithSignature {
+	msg, _ := content.Serialize()
+	sig, err := keyring.Signer(content.Source).Sign(msg)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return message.MessageWithSignature{Msg: msg, Sig: sig}
+}
+
+// Sign the vote of node source for its view, height and hash.
+func vote(test *testing.T, keyring *cryptolib.Keyring, content message.HotStuffMessage) message.HotStuffMessage {
+	sig, err := keyring.Signer(content.Source).Sign(cryptolib.VoteMsg(content.View, content.Seq, content.Hash))
+	if err != nil {
+		test.Fatal(err)
+	}
+	content.Sig = sig
+	return content
+}
+
+func TestDoubleVote(test *testing.T) {
+	if err := db.OpenDB(test.TempDir()); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	keyring, _ := cryptolib.NewKeyring(cryptolib.P256, 1, 2)
+	cryptolib.SetVerifier(keyring)
+
+	voteA := vote(test, keyring, message.HotStuffMessage{Mtype: pb.MessageType_QCREP, Source: 1, View: 3, Seq: 7, Hash: cryptolib.GenHash([]byte("A"))})
+	voteB := voteA
+	voteB.Hash = cryptolib.GenHash([]byte("B"))
+	voteB = vote(test, keyring, voteB)
+	otherView := voteB
+	otherView.View = 4
+	otherView = vote(test, keyring, otherView)
+
+	if Check(voteA, sign(test, keyring, voteA)) != nil {
+		test.Fatal("evidence from a single vote")
+	}
+	if Check(voteA, sign(test, keyring, voteA)) != nil || Check(otherView, sign(test, keyring, otherView)) != nil {
+		test.Fatal("evidence from votes that do not conflict")
+	}
+	// a vote of 1 signed by 2 is no proof against 1
+	forged := sign(test, keyring, voteB)
+	forged.Sig, _ = keyring.Signer(2).Sign(forged.Msg)
+	if Check(voteB, forged) != nil {
+		test.Fatal("evidence from a forged vote")
+	}
+	// a vote signature for another view does not bind the vote to this one
+	unbound := voteB
+	unbound.Sig = otherView.Sig
+	if Check(unbound, sign(test, keyring, unbound)) != nil {
+		test.Fatal("evidence from a vote signed for another view")
+	}
+
+	ev := Check(voteB, sign(test, keyring, voteB))
+	if ev == nil || ev.Kind != DoubleVote || ev.Culprit != 1 || ev.View != 3 || ev.Height != 7 {
+		test.Fatalf("double vote not detected: %+v", ev)
+	}
+
+	// the evidence is self-contained, and tampering with it breaks it
+	data, _ := ev.Serialize()
+	received, err := Deserialize(data)
+	if err != nil || received.Verify() != nil {
+		test.Fatalf("evidence not verified: %v", err)
+	}
+	received.Culprit = 2
+	if received.Verify() == nil {
+		test.Fatal("evidence verified against another replica")
+	}
+
+	var stored List
+	if err := db.ReadDB(DBKey, &stored); err != nil || len(stored.Evidence) != 1 {
+		test.Fatalf("evidence not stored: %v", err)
+	}
+	if reports := Reports(); len(reports) != 1 || reports[0].Hashes[0] == reports[0].Hashes[1] {
+		test.Fatalf("report: %+v", reports)
+	}
+}
+
+func TestDoubleTimeout(test *testing.T) {
+	if err := db.OpenDB(test.TempDir()); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	keyring, _ := cryptolib.NewKeyring(cryptolib.P256, 1, 2)
+	cryptolib.SetVerifier(keyring)
+
+	low := message.QCBlock{View: 2, Height: 5, Hash: cryptolib.GenHash([]byte("A"))}
+	high := message.QCBlock{View: 2, Height: 6, Hash: cryptolib.GenHash([]byte("B"))}
+	lowser, _ := low.Serialize()
+	highser, _ := high.Serialize()
+	timeoutA := message.HotStuffMessage{Mtype: pb.MessageType_TIMEOUT, Source: 1, View: 4, QC: lowser}
+	timeoutB := timeoutA
+	timeoutB.QC = highser
+
+	if Check(timeoutA, sign(test, keyring, timeoutA)) != nil || Check(timeoutA, sign(test, keyring, timeoutA)) != nil {
+		test.Fatal("evidence from the same timeout")
+	}
+	ev := Check(timeoutB, sign(test, keyring, timeoutB))
+	if ev == nil || ev.Kind != DoubleTimeout || ev.Culprit != 1 || ev.View != 4 || ev.Verify() != nil {
+		test.Fatalf("double timeout not detected: %+v", ev)
+	}
+	if report := ev.Report(); report.Hashes[0] == report.Hashes[1] {
+		test.Fatalf("report: %+v", report)
+	}
+}
@@ -0,0 +1,185 @@
+package evidence
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sleepy-hotstuff/src/communication/sender"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/logging"
+	"sleepy-hotstuff/src/message"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// DBKey is the key of the evidence in the database.
+const DBKey = "evidence"
+
+// signed messages are kept for this number of views below the current one
+const keptViews = 10
+
+type msgKey struct {
+	kind   Kind
+	source int64
+	view   int
+	height int
+}
+
+// List is the evidence found so far, in the order it was found.
+type List struct {
+	Evidence []Evidence
+}
+
+func (l *List) Serialize() ([]byte, error) {
+	return msgpack.Marshal(l)
+}
+
+func (l *List) Deserialize(input []byte) error {
+	return msgpack.Unmarshal(input, l)
+}
+
+var lock sync.Mutex
+var seen = make(map[msgKey]message.MessageWithSignature)
+var found List
+var foundIDs = make(map[string]bool)
+
+// Evidence is rare and is what names a faulty replica, so it is stored whatever the persist level:
+// by default it is written at once, see SetStore.
+var store = func(l *List) error { return db.WriteDB(DBKey, l) }
+
+// SetStore sets the function storing the evidence, given a copy of the evidence found so far.
+// A replica sets it to write the evidence with the other persisted values of its event loop.
+func SetStore(f func(l *List) error) {
+	lock.Lock()
+	defer lock.Unlock()
+	store = f
+}
+
+// Check records a signed message received from a replica. If the source already signed a
+// conflicting message, the evidence is stored, gossiped to the other replicas and returned.
+func Check(content message.HotStuffMessage, signed message.MessageWithSignature) *Evidence {
+	kind, ok := kindOf(content.Mtype)
+	if !ok {
+		return nil
+	}
+	k := msgKey{kind: kind, source: content.Source, view: content.View, height: height(kind, content)}
+
+	lock.Lock()
+	first, exist := seen[k]
+	if !exist {
+		seen[k] = signed
+		lock.Unlock()
+		return nil
+	}
+	if bytes.Equal(first.Msg, signed.Msg) || !conflict(kind, message.DeserializeHotStuffMessage(first.Msg), content) {
+		lock.Unlock()
+		return nil
+	}
+	ev := Evidence{Kind: kind, Culprit: k.source, View: k.view, Height: k.height, First: first, Second: signed}
+	err := ev.Verify()
+	if err != nil {
+		// Messages are stored before their signature is checked; a forged first message
+		// must not hide a real one of the same source.
+		if !ev.firstSigned() {
+			seen[k] = signed
+		}
+		lock.Unlock()
+		return nil
+	}
+	lock.Unlock()
+
+	record(ev)
+	return &ev
+}
+
+// HandleEvidenceMsg handles evidence gossiped by another replica.
+func HandleEvidenceMsg(input []byte) {
+	ev, err := Deserialize(input)
+	if err != nil {
+		logging.PrintLog(true, logging.ErrorLog, fmt.Sprintf("[Evidence Error] cannot deserialize evidence: %v", err))
+		return
+	}
+	if err := ev.Verify(); err != nil {
+		logging.PrintLog(true, logging.ErrorLog, fmt.Sprintf("[Evidence Error] evidence %s not verified", ev.ID()))
+		return
+	}
+	record(ev)
+}
+
+// Store and gossip a verified evidence, unless it is known already.
+func record(ev Evidence) {
+	lock.Lock()
+	if foundIDs[ev.ID()] {
+		lock.Unlock()
+		return
+	}
+	foundIDs[ev.ID()] = true
+	found.Evidence = append(found.Evidence, ev)
+	stored := List{Evidence: append([]Evidence(nil), found.Evidence...)}
+	save := store
+	lock.Unlock()
+	if err := save(&stored); err != nil {
+		log.Printf("[Evidence Error] cannot store evidence %s: %v", ev.ID(), err)
+	}
+
+	log.Printf("[Evidence] replica %d equivocated: %s", ev.Culprit, ev.ID())
+	msg, err := ev.Serialize()
+	if err != nil {
+		return
+	}
+	sender.EvidenceBroadcast(msg)
+}
+
+// Prune forgets the signed messages of the views that are too old to matter.
+func Prune(view int) {
+	lock.Lock()
+	defer lock.Unlock()
+	for k := range seen {
+		if k.view < view-keptViews {
+			delete(seen, k)
+		}
+	}
+}
+
+// Load reads the evidence stored by a previous run.
+func Load() error {
+	lock.Lock()
+	defer lock.Unlock()
+	var stored List
+	err := db.ReadDB(DBKey, &stored)
+	if err == db.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	found = stored
+	foundIDs = make(map[string]bool)
+	for i := 0; i < len(found.Evidence); i++ {
+		foundIDs[found.Evidence[i].ID()] = true
+	}
+	return nil
+}
+
+// Stored returns a copy of the evidence found so far, as it is stored.
+func Stored() *List {
+	return &List{Evidence: All()}
+}
+
+// All returns the evidence found so far.
+func All() []Evidence {
+	lock.Lock()
+	defer lock.Unlock()
+	return append([]Evidence(nil), found.Evidence...)
+}
+
+// Reports returns the evidence found so far in its JSON form.
+func Reports() []Report {
+	evs := All()
+	reports := make([]Report, len(evs))
+	for i := 0; i < len(evs); i++ {
+		reports[i] = evs[i].Report()
+	}
+	return reports
+}
@@ -0,0 +1,201 @@
+/*
+Message journal of a replica, for post-mortem debugging.
+The journal is a directory of files of JSON lines, journal.000001.jsonl, journal.000002.jsonl...
+Entries are only appended; when a file exceeds the size limit, the journal goes on in the next
+file and the oldest files beyond the limit of files are removed. A replica that restarts starts
+a new file instead of overwriting the previous ones.
+*/
+
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	In  Direction = "in"  // an event taken by the consensus core
+	Out Direction = "out" // a message sent by the replica
+)
+
+// All the replicas, as the peer of a broadcast.
+const AllPeers int64 = -1
+
+type Entry struct {
+	Time    int64     `json:"time"` // Unix time in milliseconds
+	Dir     Direction `json:"dir"`
+	Peer    int64     `json:"peer"`           // source of an input, destination of an output
+	Event   string    `json:"event"`          // kind of event of the consensus core
+	Type    string    `json:"type,omitempty"` // MessageType of the message
+	View    int       `json:"view"`
+	Seq     int       `json:"seq"`
+	Hash    string    `json:"hash,omitempty"` // hex of the hash of the payload
+	RecMode int       `json:"recMode,omitempty"`
+	Payload []byte    `json:"payload,omitempty"` // only recorded with payloads enabled
+}
+
+type Journal struct {
+	dir      string
+	payloads bool
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	index    int
+	sync.Mutex
+}
+
+// Open a journal in dir. Files rotate at maxSize bytes, and at most maxFiles are kept (all if 0).
+func Open(dir string, payloads bool, maxSize int64, maxFiles int) (*Journal, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	files, err := list(dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, payloads: payloads, maxSize: maxSize, maxFiles: maxFiles}
+	if len(files) > 0 {
+		j.index = indexOf(files[len(files)-1])
+	}
+	return j, j.rotate()
+}
+
+// Payloads tells whether full messages are recorded.
+func (j *Journal) Payloads() bool {
+	return j != nil && j.payloads
+}
+
+func (j *Journal) Write(e Entry) error {
+	if j == nil {
+		return nil
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if !j.payloads {
+		e.Payload = nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.Lock()
+	defer j.Unlock()
+	if j.size > 0 && j.size+int64(len(line)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.w.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	// the journal is read after crashes, so it is not left in the buffer.
+	return j.w.Flush()
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.w.Flush()
+	return j.f.Close()
+}
+
+// Go on in a new file and remove the files beyond maxFiles.
+func (j *Journal) rotate() error {
+	if j.f != nil {
+		j.w.Flush()
+		j.f.Close()
+	}
+	j.index++
+	f, err := os.OpenFile(filepath.Join(j.dir, fmt.Sprintf("journal.%06d.jsonl", j.index)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	j.w = bufio.NewWriter(f)
+	j.size = 0
+
+	if j.maxFiles <= 0 {
+		return nil
+	}
+	files, err := list(j.dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files)-j.maxFiles; i++ {
+		os.Remove(files[i])
+	}
+	return nil
+}
+
+// The journal files in dir, oldest first.
+func list(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "journal.*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(a, b int) bool { return indexOf(files[a]) < indexOf(files[b]) })
+	return files, nil
+}
+
+func indexOf(file string) int {
+	var index int
+	fmt.Sscanf(filepath.Base(file), "journal.%d.jsonl", &index)
+	return index
+}
+
+// Read the entries of a journal directory, or of a single journal file, in the order written.
+func Read(path string) ([]Entry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files, err = list(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 64<<20)
+		for line := 1; scanner.Scan(); line++ {
+			var e Entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+			}
+			entries = append(entries, e)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
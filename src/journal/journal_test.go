@@ -0,0 +1,46 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRotate(test *testing.T) {
+	dir := test.TempDir()
+	j, err := Open(dir, true, 400, 0)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		j.Write(Entry{Dir: In, Peer: 2, Event: "message", Type: "QC", Seq: i, Payload: []byte(fmt.Sprint(i))})
+	}
+	j.Close()
+
+	files, _ := list(dir)
+	if len(files) < 3 {
+		test.Fatalf("%d files for 20 entries", len(files))
+	}
+	entries, err := Read(dir)
+	if err != nil || len(entries) != 20 {
+		test.Fatalf("read %d entries: %v", len(entries), err)
+	}
+	for i, e := range entries {
+		if e.Seq != i || !bytes.Equal(e.Payload, []byte(fmt.Sprint(i))) || e.Time == 0 {
+			test.Fatalf("entry %d: %+v", i, e)
+		}
+	}
+
+	// a restart goes on in a new file, and only the last files are kept
+	j, _ = Open(dir, false, 400, 2)
+	j.Write(Entry{Dir: Out, Peer: AllPeers, Event: "message", Seq: 20, Payload: []byte("20")})
+	j.Close()
+	files, _ = list(dir)
+	if len(files) != 2 {
+		test.Fatalf("%d files kept", len(files))
+	}
+	entries, _ = Read(files[1])
+	if len(entries) != 1 || entries[0].Seq != 20 || entries[0].Payload != nil {
+		test.Fatalf("entries after the restart: %+v", entries)
+	}
+}
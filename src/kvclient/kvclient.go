@@ -0,0 +1,119 @@
+/*
+Client of the replicated key-value store, the "kv" application.
+Operations are sent to every replica with a consistency level (message.LevelConsensus, ...)
+in the version field of the request:
+
+	Put, Delete, CAS          ordered by consensus; the result is taken once f+1 replicas return it
+	Get(key, LevelConsensus)  ordered by consensus like a write, linearizable
+	Get(key, LevelLease)      served by the leader from its state while it holds its lease
+	Get(key, LevelQuorum)     served by every replica from its state; the value is taken once f+1
+	                          replicas return it, so it was committed, but it may be stale
+*/
+
+package kvclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/app"
+	"sleepy-hotstuff/src/communication/clientsender"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/message"
+	pb "sleepy-hotstuff/src/proto/communication"
+	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/utils"
+	"sync"
+)
+
+var ErrNoQuorum = errors.New("[KV Client Error] no f+1 matching replies")
+
+type Client struct {
+	id  int64
+	seq int
+	sync.Mutex
+}
+
+// New starts the client sender of client cid; the configuration is loaded from conf.json.
+func New(cid string) *Client {
+	clientsender.StartClientSender(cid, true)
+	quorum.StartQuorum(config.FetchNumReplicas())
+	return &Client{id: clientsender.ID()}
+}
+
+// The request of an operation, a serialized MessageWithSignature of a ClientRequest.
+func (c *Client) request(op app.KVOp) ([]byte, error) {
+	c.Lock()
+	c.seq++
+	op.Client = c.id
+	op.Seq = c.seq
+	c.Unlock()
+
+	opser, err := op.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	cr := message.ClientRequest{
+		Type: pb.MessageType_WRITE,
+		ID:   c.id,
+		OP:   opser,
+		TS:   utils.MakeTimestamp(),
+	}
+	crser, err := cr.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return message.SerializeWithSignature(c.id, crser)
+}
+
+// The result returned by at least need replicas. Local reads are served at different heights,
+// so replies match on the code, the value and the version.
+func matching(replies map[string][]byte, need int) (app.KVResult, error) {
+	count := make(map[string]int)
+	for nid, reply := range replies {
+		var r app.KVResult
+		if err := json.Unmarshal(reply, &r); err != nil {
+			return r, fmt.Errorf("[KV Client Error] invalid reply from replica %s: %v", nid, err)
+		}
+		key := fmt.Sprintf("%d/%x/%d", r.Code, r.Value, r.Version)
+		count[key]++
+		if count[key] >= need {
+			return r, nil
+		}
+	}
+	return app.KVResult{}, ErrNoQuorum
+}
+
+func (c *Client) do(op app.KVOp, level string) (app.KVResult, error) {
+	request, err := c.request(op)
+	if err != nil {
+		return app.KVResult{}, err
+	}
+	replies := clientsender.BroadcastRequestWithLevel(pb.MessageType_WRITE, request, level)
+	if level == message.LevelLease {
+		// only the leader holding the lease replies
+		return matching(replies, 1)
+	}
+	return matching(replies, quorum.FSize()+1)
+}
+
+func (c *Client) Put(key string, value []byte) (app.KVResult, error) {
+	return c.do(app.KVOp{Type: app.KVPut, Key: key, Value: value}, message.LevelConsensus)
+}
+
+func (c *Client) Delete(key string) (app.KVResult, error) {
+	return c.do(app.KVOp{Type: app.KVDelete, Key: key}, message.LevelConsensus)
+}
+
+// CAS writes value if the version of key is version, 0 if the key has no value. Otherwise the
+// result has the code app.CodeRefused and the current value and version.
+func (c *Client) CAS(key string, version int, value []byte) (app.KVResult, error) {
+	return c.do(app.KVOp{Type: app.KVCAS, Key: key, Value: value, Version: version}, message.LevelConsensus)
+}
+
+func (c *Client) Get(key string, level string) (app.KVResult, error) {
+	if level == message.LevelAsync {
+		return app.KVResult{}, errors.New("[KV Client Error] reads need a consistency level")
+	}
+	return c.do(app.KVOp{Type: app.KVGet, Key: key}, level)
+}
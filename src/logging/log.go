@@ -214,3 +214,23 @@ func IsExist(f string) bool {
 	return err == nil || os.IsExist(err)
 }
 
+
+// The log directory of the replica, var/log/[id]/, created if needed.
+func LogDir() (string, error) {
+	fpath := fmt.Sprintf(homepath+"/var/log/%s/", id)
+	if !IsExist(fpath) {
+		if err := CreateDir(fpath); err != nil {
+			return "", err
+		}
+	}
+	return fpath, nil
+}
+
+// Create (or truncate) a file of the given name in the log directory of the replica.
+func CreateLogFile(name string) (*os.File, error) {
+	fpath, err := LogDir()
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(fpath + name)
+}
@@ -0,0 +1,441 @@
+/*
+Inspection and repair of the database of a replica (etc/DBFile/<id>).
+The replica must not be running, since leveldb locks the database.
+
+Usage:
+
+	./dbtool dump [id]              print the stored state as JSON
+	./dbtool verify [id]            check the hash chain and the QCs of the stored blocks
+	./dbtool truncate [id] [height] drop the state above height
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/consensus"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/da"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/evidence"
+	"sleepy-hotstuff/src/mempool"
+	"sleepy-hotstuff/src/message"
+	"sleepy-hotstuff/src/quorum"
+	"sleepy-hotstuff/src/safety"
+	"sleepy-hotstuff/src/utils"
+	"sort"
+	"strconv"
+)
+
+// State stored by the consensus package. The keys are the ones used by db.PersistValue.
+type storedState struct {
+	view                 utils.IntValue
+	sequence             utils.IntValue
+	curHash              utils.ByteValue
+	curBlock             message.QCBlock
+	lockedBlock          message.QCBlock
+	committedBlocks      utils.IntByteMap
+	votedBlocks          utils.IntByteMap
+	awaitingBlocks       utils.IntByteMap
+	awaitingDecision     utils.IntByteMap
+	awaitingDecisionCopy utils.IntByteMap
+	vcAwaitingVotes      utils.IntIntMap
+	mempool              mempool.Mempool
+	batches              da.Store
+	msgQueue             consensus.Queue
+	safetyRules          safety.Rules
+	evidence             evidence.List
+	found                map[string]bool
+}
+
+func (s *storedState) values() map[string]db.DBValue {
+	return map[string]db.DBValue{
+		"view":                 &s.view,
+		"Sequence":             &s.sequence,
+		"curHash":              &s.curHash,
+		"curBlock":             &s.curBlock,
+		"lockedBlock":          &s.lockedBlock,
+		"committedBlocks":      &s.committedBlocks,
+		"votedBlocks":          &s.votedBlocks,
+		"awaitingBlocks":       &s.awaitingBlocks,
+		"awaitingDecision":     &s.awaitingDecision,
+		"awaitingDecisionCopy": &s.awaitingDecisionCopy,
+		"vcAwaitingVotes":      &s.vcAwaitingVotes,
+		"mempool":              &s.mempool,
+		"batches":              &s.batches,
+		"MsgQueue":             &s.msgQueue,
+		safety.DBKey:           &s.safetyRules,
+		evidence.DBKey:         &s.evidence,
+	}
+}
+
+func loadState() *storedState {
+	s := &storedState{found: make(map[string]bool)}
+	s.committedBlocks.Init()
+	s.votedBlocks.Init()
+	s.awaitingBlocks.Init()
+	s.awaitingDecision.Init()
+	s.awaitingDecisionCopy.Init()
+	s.vcAwaitingVotes.Init()
+	s.mempool.Init(mempool.Limits{})
+	s.batches.Init()
+	s.msgQueue.Init()
+	s.safetyRules.Init()
+	for key, value := range s.values() {
+		err := db.ReadDB(key, value)
+		if err == db.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("[DBTool Error] cannot decode %s: %v", key, err)
+		}
+		s.found[key] = true
+	}
+	return s
+}
+
+func (s *storedState) write(keys ...string) {
+	values := s.values()
+	for _, key := range keys {
+		err := db.WriteDB(key, values[key])
+		if err != nil {
+			log.Fatalf("[DBTool Error] cannot write %s: %v", key, err)
+		}
+	}
+}
+
+type blockJSON struct {
+	View       int     `json:"view"`
+	Height     int     `json:"height"`
+	Hash       string  `json:"hash"`
+	PreHash    string  `json:"prehash"`
+	PrePreHash string  `json:"preprehash"`
+	Signers    []int64 `json:"signers"`
+	NumTXS     int     `json:"numTXS"`
+	NumBatches int     `json:"numBatches,omitempty"` // in data-availability mode, the requests follow the coinbase in batches
+}
+
+type messageJSON struct {
+	Type   string `json:"type"`
+	Source int64  `json:"source"`
+	View   int    `json:"view"`
+	Seq    int    `json:"seq"`
+	Hash   string `json:"hash"`
+}
+
+type safetyJSON struct {
+	VotedView    int    `json:"votedView"`
+	VotedHeight  int    `json:"votedHeight"`
+	VotedHash    string `json:"votedHash"`
+	LockedView   int    `json:"lockedView"`
+	LockedHeight int    `json:"lockedHeight"`
+	LockedHash   string `json:"lockedHash"`
+}
+
+type stateJSON struct {
+	SchemaVersion        int               `json:"schemaVersion"`
+	Keys                 []string          `json:"keys"`
+	View                 int               `json:"view"`
+	Sequence             int               `json:"sequence"`
+	CurHash              string            `json:"curHash"`
+	CurBlock             blockJSON         `json:"curBlock"`
+	LockedBlock          blockJSON         `json:"lockedBlock"`
+	CommittedBlocks      []blockJSON       `json:"committedBlocks"`
+	VotedBlocks          map[int]string    `json:"votedBlocks"`
+	AwaitingBlocks       map[int]string    `json:"awaitingBlocks"`
+	AwaitingDecision     map[int]string    `json:"awaitingDecision"`
+	AwaitingDecisionCopy map[int]string    `json:"awaitingDecisionCopy"`
+	VCAwaitingVotes      map[int]int       `json:"vcAwaitingVotes"`
+	Mempool              int               `json:"mempool"`
+	Batches              int               `json:"batches"` // batches stored in data-availability mode
+	MsgQueue             []messageJSON     `json:"msgQueue"`
+	SafetyRules          *safetyJSON       `json:"safetyRules,omitempty"`
+	Evidence             []evidence.Report `json:"evidence,omitempty"`
+}
+
+func toBlockJSON(b message.QCBlock) blockJSON {
+	return blockJSON{
+		View:       b.View,
+		Height:     b.Height,
+		Hash:       hex.EncodeToString(b.Hash),
+		PreHash:    hex.EncodeToString(b.PreHash),
+		PrePreHash: hex.EncodeToString(b.PrePreHash),
+		Signers:    b.IDs,
+		NumTXS:     len(b.TXS),
+		NumBatches: len(b.Batches),
+	}
+}
+
+func toHexMap(m *utils.IntByteMap) map[int]string {
+	output := make(map[int]string)
+	for k, v := range m.GetAll() {
+		output[k] = hex.EncodeToString(v)
+	}
+	return output
+}
+
+// Heights of the committed blocks in increasing order.
+func committedHeights(s *storedState) []int {
+	var heights []int
+	for k := range s.committedBlocks.GetAll() {
+		heights = append(heights, k)
+	}
+	sort.Ints(heights)
+	return heights
+}
+
+func committedBlock(s *storedState, height int) (message.QCBlock, bool) {
+	bser, exist := s.committedBlocks.Get(height)
+	if !exist {
+		return message.QCBlock{}, false
+	}
+	return message.DeserializeQCBlock(bser), true
+}
+
+func dump(s *storedState) {
+	out := stateJSON{
+		View:                 s.view.Get(),
+		Sequence:             s.sequence.Get(),
+		CurHash:              hex.EncodeToString(s.curHash.Get()),
+		CurBlock:             toBlockJSON(s.curBlock),
+		LockedBlock:          toBlockJSON(s.lockedBlock),
+		CommittedBlocks:      []blockJSON{},
+		VotedBlocks:          toHexMap(&s.votedBlocks),
+		AwaitingBlocks:       toHexMap(&s.awaitingBlocks),
+		AwaitingDecision:     toHexMap(&s.awaitingDecision),
+		AwaitingDecisionCopy: toHexMap(&s.awaitingDecisionCopy),
+		VCAwaitingVotes:      s.vcAwaitingVotes.GetAll(),
+		Mempool:              s.mempool.Len(),
+		Batches:              len(s.batches.Batches),
+		MsgQueue:             []messageJSON{},
+	}
+	out.SchemaVersion, _ = db.StoredSchemaVersion()
+	for key := range s.found {
+		out.Keys = append(out.Keys, key)
+	}
+	sort.Strings(out.Keys)
+	for _, h := range committedHeights(s) {
+		b, _ := committedBlock(s, h)
+		out.CommittedBlocks = append(out.CommittedBlocks, toBlockJSON(b))
+	}
+	msgs := s.msgQueue.Grab()
+	for i := 0; i < len(msgs); i++ {
+		tmp := message.DeserializeMessageWithSignature(msgs[i].GetMsg())
+		content := message.DeserializeHotStuffMessage(tmp.Msg)
+		out.MsgQueue = append(out.MsgQueue, messageJSON{
+			Type:   content.Mtype.String(),
+			Source: content.Source,
+			View:   content.View,
+			Seq:    content.Seq,
+			Hash:   hex.EncodeToString(content.Hash),
+		})
+	}
+	if s.found[safety.DBKey] {
+		r := &s.safetyRules
+		out.SafetyRules = &safetyJSON{
+			VotedView:    r.VotedView,
+			VotedHeight:  r.VotedHeight,
+			VotedHash:    hex.EncodeToString(r.VotedHash),
+			LockedView:   r.LockedView,
+			LockedHeight: r.LockedHeight,
+			LockedHash:   hex.EncodeToString(r.LockedHash),
+		}
+	}
+
+	for i := 0; i < len(s.evidence.Evidence); i++ {
+		out.Evidence = append(out.Evidence, s.evidence.Evidence[i].Report())
+	}
+
+	jsonData, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		log.Fatalf("[DBTool Error] cannot encode the state: %v", err)
+	}
+	fmt.Println(string(jsonData))
+}
+
+// Check the signatures of the QC of a block. The public keys are read from etc/key.
+func verifyQC(b message.QCBlock) error {
+	if b.Hash == nil {
+		return nil
+	}
+	if len(b.QC) != len(b.IDs) {
+		return fmt.Errorf("%d signatures for %d signers", len(b.QC), len(b.IDs))
+	}
+	if len(b.QC) < quorum.QuorumSize() {
+		return fmt.Errorf("%d signatures, quorum is %d", len(b.QC), quorum.QuorumSize())
+	}
+	signers := make(map[int64]bool)
+	for i := 0; i < len(b.QC); i++ {
+		if signers[b.IDs[i]] {
+			return fmt.Errorf("duplicated signer %d", b.IDs[i])
+		}
+		signers[b.IDs[i]] = true
+		if cryptolib.FetchPublicKey(b.IDs[i]) == nil {
+			return fmt.Errorf("no public key for signer %d", b.IDs[i])
+		}
+		if !cryptolib.VerifySig(b.IDs[i], cryptolib.VoteMsg(b.View, b.Height, b.Hash), b.QC[i]) {
+			return fmt.Errorf("invalid signature of signer %d", b.IDs[i])
+		}
+	}
+	return nil
+}
+
+// verify reports every inconsistency of the stored blocks and returns the number of problems.
+func verify(s *storedState) int {
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Printf("[FAIL] "+format+"\n", a...)
+	}
+
+	heights := committedHeights(s)
+	for _, h := range heights {
+		b, _ := committedBlock(s, h)
+		if b.Height != h {
+			report("committed block stored at height %d has height %d", h, b.Height)
+		}
+		if parent, exist := committedBlock(s, h-1); exist && b.PreHash != nil && !bytes.Equal(b.PreHash, parent.Hash) {
+			report("committed block %d does not extend committed block %d", h, h-1)
+		}
+		if grand, exist := committedBlock(s, h-2); exist && b.PrePreHash != nil && !bytes.Equal(b.PrePreHash, grand.Hash) {
+			report("committed block %d does not extend committed block %d", h, h-2)
+		}
+		if err := verifyQC(b); err != nil {
+			report("QC of committed block %d: %v", h, err)
+		}
+	}
+
+	if len(heights) > 0 && s.lockedBlock.Height < heights[len(heights)-1] {
+		report("locked block %d is below committed block %d", s.lockedBlock.Height, heights[len(heights)-1])
+	}
+	if b, exist := committedBlock(s, s.lockedBlock.Height); exist && !bytes.Equal(b.Hash, s.lockedBlock.Hash) {
+		report("locked block %d differs from the committed block at the same height", s.lockedBlock.Height)
+	}
+	if err := verifyQC(s.lockedBlock); err != nil {
+		report("QC of locked block %d: %v", s.lockedBlock.Height, err)
+	}
+	if s.found["curBlock"] {
+		if s.curBlock.Height < s.lockedBlock.Height {
+			report("current block %d is below locked block %d", s.curBlock.Height, s.lockedBlock.Height)
+		}
+		if err := verifyQC(s.curBlock); err != nil {
+			report("QC of current block %d: %v", s.curBlock.Height, err)
+		}
+	}
+	if s.found["curHash"] && s.found["curBlock"] && !bytes.Equal(s.curHash.Get(), s.curBlock.Hash) {
+		report("curHash does not match the hash of the current block %d", s.curBlock.Height)
+	}
+	if s.found[safety.DBKey] && s.safetyRules.LockedHeight > s.lockedBlock.Height {
+		report("safety rules are locked at height %d, above locked block %d", s.safetyRules.LockedHeight, s.lockedBlock.Height)
+	}
+
+	fmt.Printf("checked %d committed blocks, %d problems\n", len(heights), problems)
+	return problems
+}
+
+func truncateMap(m *utils.IntByteMap, height int) {
+	for k := range m.GetAll() {
+		if k > height {
+			m.Delete(k)
+		}
+	}
+}
+
+// truncate drops the blocks above height, so that the replica restarts from the block at height.
+// The votes recorded by the safety rules are kept, so the replica never votes twice for a view.
+func truncate(s *storedState, height int) {
+	truncateMap(&s.committedBlocks, height)
+	truncateMap(&s.votedBlocks, height)
+	truncateMap(&s.awaitingBlocks, height)
+	truncateMap(&s.awaitingDecision, height)
+	truncateMap(&s.awaitingDecisionCopy, height)
+
+	if s.lockedBlock.Height > height {
+		s.lockedBlock = message.QCBlock{}
+		heights := committedHeights(s)
+		if len(heights) > 0 {
+			s.lockedBlock, _ = committedBlock(s, heights[len(heights)-1])
+		}
+	}
+	if s.curBlock.Height > height {
+		s.curBlock = s.lockedBlock
+	}
+	s.curHash.Set(s.curBlock.Hash)
+	if s.sequence.Get() > height {
+		s.sequence.Set(height)
+	}
+
+	// stored proposals above height would bring the blocks back when they are replayed
+	q := s.msgQueue.Grab()
+	s.msgQueue.Init()
+	for i := 0; i < len(q); i++ {
+		tmp := message.DeserializeMessageWithSignature(q[i].GetMsg())
+		content := message.DeserializeHotStuffMessage(tmp.Msg)
+		if content.Seq <= height {
+			s.msgQueue.Append(q[i].GetMsg())
+		}
+	}
+
+	s.safetyRules.LockedView = s.lockedBlock.View
+	s.safetyRules.LockedHeight = s.lockedBlock.Height
+	s.safetyRules.LockedHash = s.lockedBlock.Hash
+
+	s.write("committedBlocks", "votedBlocks", "awaitingBlocks", "awaitingDecision", "awaitingDecisionCopy",
+		"lockedBlock", "curBlock", "curHash", "Sequence", "MsgQueue", safety.DBKey)
+	fmt.Printf("truncated to height %d: locked block %d, current block %d, %d committed blocks, %d stored messages\n",
+		height, s.lockedBlock.Height, s.curBlock.Height, s.committedBlocks.GetLen(), s.msgQueue.Length())
+}
+
+func usage() {
+	fmt.Println("usage: dbtool dump [id] | dbtool verify [id] | dbtool truncate [id] [height]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+	cmd := os.Args[1]
+	id := os.Args[2]
+	if _, err := strconv.Atoi(id); err != nil {
+		usage()
+	}
+
+	err := db.StartDB(id)
+	if err != nil {
+		log.Fatalf("[DBTool Error] cannot open the database of replica %s: %v", id, err)
+	}
+	defer db.CloseDB()
+	s := loadState()
+
+	switch cmd {
+	case "dump":
+		dump(s)
+	case "verify":
+		config.LoadConfig()
+		quorum.SetQuorumSizes(config.FetchNumReplicas())
+		cryptolib.SetHomeDir()
+		cryptolib.MapOfKeys.Init()
+		if verify(s) > 0 {
+			db.CloseDB()
+			os.Exit(1)
+		}
+	case "truncate":
+		if len(os.Args) < 4 {
+			usage()
+		}
+		height, err := strconv.Atoi(os.Args[3])
+		if err != nil || height < 0 {
+			usage()
+		}
+		truncate(s, height)
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Command-line client of the replicated key-value store (application "kv" in conf.json).
+The result of the operation is printed as JSON.
+
+Usage:
+
+	./kv [cid] put [key] [value]
+	./kv [cid] delete [key]
+	./kv [cid] cas [key] [version] [value]    version 0 if the key has no value
+	./kv [cid] get [key] [level]              level: consensus (default), lease or quorum
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sleepy-hotstuff/src/app"
+	"sleepy-hotstuff/src/kvclient"
+	"sleepy-hotstuff/src/message"
+	"strconv"
+)
+
+func usage() {
+	fmt.Println("usage: kv [cid] put [key] [value] | delete [key] | cas [key] [version] [value] | get [key] [consensus|lease|quorum]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 4 {
+		usage()
+	}
+	args := os.Args[3:]
+	c := kvclient.New(os.Args[1])
+
+	var result app.KVResult
+	var err error
+	switch os.Args[2] {
+	case "put":
+		if len(args) < 2 {
+			usage()
+		}
+		result, err = c.Put(args[0], []byte(args[1]))
+	case "delete":
+		result, err = c.Delete(args[0])
+	case "cas":
+		if len(args) < 3 {
+			usage()
+		}
+		version, verr := strconv.Atoi(args[1])
+		if verr != nil {
+			usage()
+		}
+		result, err = c.CAS(args[0], version, []byte(args[2]))
+	case "get":
+		level := message.LevelConsensus
+		if len(args) > 1 {
+			level = args[1]
+		}
+		result, err = c.Get(args[0], level)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, _ := json.Marshal(struct {
+		app.KVResult
+		Value string `json:"value,omitempty"`
+	}{result, string(result.Value)})
+	fmt.Println(string(out))
+	if result.Code != app.CodeOK {
+		os.Exit(1)
+	}
+}
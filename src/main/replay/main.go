@@ -0,0 +1,106 @@
+/*
+Offline replay of the journal of a replica (var/log/<id>/journal, recorded with journal and
+journalPayloads in conf.json).
+The events of the journal are given, in order, to the consensus core of a fresh replica with
+a temporary database. The messages it sends are printed as journal entries and compared with
+the recorded ones; the blocks it receives are written to etc/output/replay_receivedBlocks_<id>.json,
+to compare with receivedBlocks_<id>.json.
+
+Usage:
+
+	./replay [id] [journal]   journal is a directory or a single journal file
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/consensus"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/journal"
+	"sleepy-hotstuff/src/logging"
+	"strconv"
+)
+
+func usage() {
+	fmt.Println("usage: replay [id] [journal]")
+	os.Exit(2)
+}
+
+// Two sent messages match if they have the same destination, type, view and height;
+// the hashes differ when messages carry timestamps.
+func match(a journal.Entry, b journal.Entry) bool {
+	return a.Peer == b.Peer && a.Event == b.Event && a.Type == b.Type && a.View == b.View && a.Seq == b.Seq
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+	id := os.Args[1]
+	if _, err := strconv.Atoi(id); err != nil {
+		usage()
+	}
+	entries, err := journal.Read(os.Args[2])
+	if err != nil {
+		log.Fatalf("[Replay Error] cannot read the journal: %v", err)
+	}
+
+	config.LoadConfig()
+	logging.SetID(id)
+	dir, err := os.MkdirTemp("", "replay")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := db.OpenDB(dir); err != nil {
+		log.Fatalf("[Replay Error] cannot open a database: %v", err)
+	}
+	defer db.CloseDB()
+	if err := consensus.StartReplay(id); err != nil {
+		log.Fatalf("[Replay Error] %v", err)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	events, sent, diverged := 0, 0, -1
+	for i := 0; i < len(entries); i++ {
+		if entries[i].Dir != journal.In {
+			continue
+		}
+		replayed, err := consensus.Replay(entries[i])
+		if err != nil {
+			log.Fatalf("[Replay Error] entry %d: %v", i, err)
+		}
+		events++
+		// the messages recorded for this event follow it in the journal.
+		var recorded []journal.Entry
+		for j := i + 1; j < len(entries) && entries[j].Dir == journal.Out; j++ {
+			recorded = append(recorded, entries[j])
+		}
+		for k, e := range replayed {
+			out.Encode(e)
+			if diverged < 0 && (k >= len(recorded) || !match(e, recorded[k])) {
+				diverged = i
+			}
+		}
+		if diverged < 0 && len(replayed) < len(recorded) {
+			diverged = i
+		}
+		sent += len(replayed)
+	}
+	if err := consensus.FinishReplay(); err != nil {
+		log.Printf("[Replay Error] cannot write the received blocks: %v", err)
+	}
+
+	log.Printf("replayed %d events, %d messages sent", events, sent)
+	if diverged >= 0 {
+		log.Printf("the replay diverges from the journal at entry %d: %+v", diverged, entries[diverged])
+		db.CloseDB()
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+}
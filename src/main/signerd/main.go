@@ -0,0 +1,119 @@
+/*
+Signing daemon of a replica.
+It holds the private key of replica id (etc/key/<id>) and signs for the replica over a
+Unix socket. Before signing a vote, it checks the vote against the highest vote it has
+signed, which is stored in etc/DBFile/signer-<id>, so the replica cannot sign two blocks
+at the same height even after a restart of either process.
+
+Usage:
+
+	./signerd [id] [socket]
+
+The replica uses the daemon if signerSocket in conf.json is the path of the socket.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	_ "sleepy-hotstuff/src/consensus" // registers the migrations of older databases
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/db"
+	"sleepy-hotstuff/src/safety"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Fatalf("usage: %s [id] [socket]", os.Args[0])
+	}
+	id, err := strconv.ParseInt(os.Args[1], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid id %s: %v", os.Args[1], err)
+	}
+	socket := os.Args[2]
+
+	cryptolib.SetHomeDir()
+	signer, err := cryptolib.NewFileSigner(id)
+	if err != nil {
+		log.Fatalf("cannot load the key of %d: %v", id, err)
+	}
+
+	err = db.StartDB("signer-" + os.Args[1])
+	if err != nil {
+		log.Fatalf("cannot open the database: %v", err)
+	}
+	err = serve(id, signer, socket)
+	db.CloseDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Answer the requests on socket until the daemon gets SIGINT or SIGTERM.
+func serve(id int64, signer cryptolib.Signer, socket string) error {
+	var rules safety.Rules
+	rules.Init()
+	err := rules.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load the signed votes: %v", err)
+	}
+	log.Printf("signer of %d starts with %s", id, rules.String())
+
+	// a socket left by a previous run
+	os.Remove(socket)
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("cannot listen to %s: %v", socket, err)
+	}
+	// only the user of the replica may ask for signatures
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		lis.Close()
+		return fmt.Errorf("cannot restrict %s: %v", socket, err)
+	}
+
+	var lock sync.Mutex
+	stopped := false
+	checkVote := func(view int, height int, hash []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return errors.New("the signer is stopping")
+		}
+		err := rules.Vote(view, height, hash)
+		if err != nil {
+			return err
+		}
+		// the daemon has no persist level: the vote is always written before it is signed
+		return db.WriteDB(safety.DBKey, &rules)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Printf("stopping on %v", <-signals)
+		lock.Lock()
+		stopped = true
+		lock.Unlock()
+		lis.Close()
+	}()
+
+	server := &cryptolib.SignerServer{Signer: signer, CheckVote: checkVote}
+	log.Printf("listening to %s", socket)
+	err = server.Serve(lis)
+	lock.Lock()
+	defer lock.Unlock()
+	if stopped {
+		return nil
+	}
+	stopped = true
+	return err
+}
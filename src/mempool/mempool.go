@@ -0,0 +1,312 @@
+/*
+Mempool of the client requests waiting to be committed.
+Requests are kept by the hash of their ClientRequest, so a request sent twice, to the same
+replica or after it was committed, is only kept once. The pool is bounded in number of requests
+and bytes, a client cannot hold more than its quota, and requests expire after a TTL.
+
+A request is pending until it is proposed, by this replica as leader (Reap) or by another leader
+(MarkProposed), then proposed until it is committed (Commit). After a view change, the
+proposed requests that are not committed are pending again (Requeue), since their blocks may
+never be committed.
+
+A replica forwards the pending requests it received from clients to the leaders (Forward), and
+again to the leaders of a new view (Reforward), so that a client can send a request to a single
+replica.
+
+The requests of a block are chosen by a Policy (policy.go): the oldest first, the highest fee
+first, or one request of each client in turn, within the limits of a block.
+*/
+
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/cryptolib"
+	"sleepy-hotstuff/src/message"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Committed requests are remembered for the TTL, or for this time without TTL.
+const committedMemory = 10 * time.Minute
+
+var (
+	ErrDuplicate = errors.New("request already in the mempool")
+	ErrCommitted = errors.New("request already committed")
+	ErrFull      = errors.New("mempool full")
+	ErrQuota     = errors.New("client over its quota")
+	ErrTooLarge  = errors.New("transaction too large")
+)
+
+type Limits struct {
+	MaxTxs      int
+	MaxBytes    int
+	ClientQuota int // 0 for no quota
+	TTL         time.Duration
+	MaxTxSize   int // bytes of the transaction of a request, 0 for no limit
+}
+
+type Tx struct {
+	Request  []byte // serialized MessageWithSignature of a ClientRequest
+	Hash     string
+	Client   int64
+	Fee      int64
+	Added    int64 // unix time in ms
+	Proposed bool
+	Local    bool // received from the client, not forwarded by another replica
+	Sent     bool // forwarded to the leaders
+}
+
+// HashOf gives the key of a request, the hex of the hash of its ClientRequest, and its client.
+func HashOf(request []byte) (string, int64) {
+	signed := message.DeserializeMessageWithSignature(request)
+	cr := message.DeserializeClientRequest(signed.Msg)
+	return hex.EncodeToString(cryptolib.GenHash(signed.Msg)), cr.ID
+}
+
+// HashOfSigned gives the key of a request of a block.
+func HashOfSigned(signed message.MessageWithSignature) string {
+	return hex.EncodeToString(cryptolib.GenHash(signed.Msg))
+}
+
+// Mempool is not safe for concurrent use; the consensus loop owns it.
+type Mempool struct {
+	Txs       map[string]*Tx
+	Order     []string         // hashes in arrival order, with the ones removed since
+	Committed map[string]int64 // hashes of the committed requests, with the time of the commit
+	limits    Limits
+	bytes     int
+	pending   int // requests not proposed
+	perClient map[int64]int
+}
+
+func (p *Mempool) Init(limits Limits) {
+	p.Txs = make(map[string]*Tx)
+	p.Order = nil
+	p.Committed = make(map[string]int64)
+	p.limits = limits
+	p.bytes = 0
+	p.pending = 0
+	p.perClient = make(map[int64]int)
+}
+
+func (p *Mempool) Serialize() ([]byte, error) {
+	p.compact()
+	return msgpack.Marshal(p)
+}
+
+// Deserialize keeps the limits of the pool.
+func (p *Mempool) Deserialize(input []byte) error {
+	var stored Mempool
+	if err := msgpack.Unmarshal(input, &stored); err != nil {
+		return err
+	}
+	p.Init(p.limits)
+	for _, hash := range stored.Order {
+		if tx, exist := stored.Txs[hash]; exist && p.Txs[hash] == nil {
+			p.insert(hash, tx)
+		}
+	}
+	for hash, t := range stored.Committed {
+		p.Committed[hash] = t
+	}
+	return nil
+}
+
+func (p *Mempool) insert(hash string, tx *Tx) {
+	tx.Hash = hash
+	p.Txs[hash] = tx
+	p.Order = append(p.Order, hash)
+	p.bytes += len(tx.Request)
+	if !tx.Proposed {
+		p.pending++
+	}
+	p.perClient[tx.Client]++
+}
+
+func (p *Mempool) remove(hash string) {
+	tx, exist := p.Txs[hash]
+	if !exist {
+		return
+	}
+	delete(p.Txs, hash)
+	p.bytes -= len(tx.Request)
+	if !tx.Proposed {
+		p.pending--
+	}
+	p.perClient[tx.Client]--
+	if p.perClient[tx.Client] == 0 {
+		delete(p.perClient, tx.Client)
+	}
+}
+
+// Drop the removed hashes from Order once they are the majority.
+func (p *Mempool) compact() {
+	if len(p.Order) <= 2*len(p.Txs) {
+		return
+	}
+	var order []string
+	for _, hash := range p.Order {
+		if _, exist := p.Txs[hash]; exist {
+			order = append(order, hash)
+		}
+	}
+	p.Order = order
+}
+
+// Add a request received at now. The request is refused if it is known, or over the limits.
+func (p *Mempool) Add(request []byte, now time.Time) (string, error) {
+	signed := message.DeserializeMessageWithSignature(request)
+	cr := message.DeserializeClientRequest(signed.Msg)
+	hash := hex.EncodeToString(cryptolib.GenHash(signed.Msg))
+	client := cr.ID
+	if p.limits.MaxTxSize > 0 && len(cr.OP) > p.limits.MaxTxSize {
+		return hash, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(cr.OP))
+	}
+	if _, exist := p.Txs[hash]; exist {
+		return hash, ErrDuplicate
+	}
+	if _, exist := p.Committed[hash]; exist {
+		return hash, ErrCommitted
+	}
+	if len(p.Txs) >= p.limits.MaxTxs || p.bytes+len(request) > p.limits.MaxBytes {
+		return hash, fmt.Errorf("%w: %d requests, %d bytes", ErrFull, len(p.Txs), p.bytes)
+	}
+	if p.limits.ClientQuota > 0 && p.perClient[client] >= p.limits.ClientQuota {
+		return hash, fmt.Errorf("%w: client %d has %d requests", ErrQuota, client, p.perClient[client])
+	}
+	p.insert(hash, &Tx{Request: request, Client: client, Fee: cr.Fee, Added: now.UnixMilli()})
+	return hash, nil
+}
+
+// AddLocal adds a request received from its client.
+func (p *Mempool) AddLocal(request []byte, now time.Time) (string, error) {
+	hash, err := p.Add(request, now)
+	if err == nil {
+		p.Txs[hash].Local = true
+	}
+	return hash, err
+}
+
+// Reap marks the pending requests chosen by the policy for the next block as proposed, and
+// returns them in the order of the block.
+func (p *Mempool) Reap(policy Policy, limits BlockLimits) [][]byte {
+	var pending []*Tx
+	for _, hash := range p.Order {
+		if tx, exist := p.Txs[hash]; exist && !tx.Proposed {
+			pending = append(pending, tx)
+		}
+	}
+	var batch [][]byte
+	for _, tx := range policy.Select(pending, limits) {
+		if tx.Proposed {
+			continue
+		}
+		tx.Proposed = true
+		p.pending--
+		batch = append(batch, tx.Request)
+	}
+	return batch
+}
+
+// MarkProposed marks the requests of a block proposed by another leader.
+func (p *Mempool) MarkProposed(hashes []string) {
+	for _, hash := range hashes {
+		if tx, exist := p.Txs[hash]; exist && !tx.Proposed {
+			tx.Proposed = true
+			p.pending--
+		}
+	}
+}
+
+// Commit removes the requests of a committed block, and remembers them.
+func (p *Mempool) Commit(hashes []string, now time.Time) {
+	for _, hash := range hashes {
+		p.remove(hash)
+		p.Committed[hash] = now.UnixMilli()
+	}
+	p.compact()
+}
+
+// Requeue makes the proposed requests pending again, except the ones of keep, and returns their number.
+func (p *Mempool) Requeue(keep []string) int {
+	kept := make(map[string]bool)
+	for _, hash := range keep {
+		kept[hash] = true
+	}
+	num := 0
+	for hash, tx := range p.Txs {
+		if tx.Proposed && !kept[hash] {
+			tx.Proposed = false
+			p.pending++
+			num++
+		}
+	}
+	return num
+}
+
+// Forward marks the pending requests received from clients and not forwarded yet as
+// forwarded, and returns them in arrival order.
+func (p *Mempool) Forward() [][]byte {
+	var requests [][]byte
+	for _, hash := range p.Order {
+		tx, exist := p.Txs[hash]
+		if !exist || !tx.Local || tx.Proposed || tx.Sent {
+			continue
+		}
+		tx.Sent = true
+		requests = append(requests, tx.Request)
+	}
+	return requests
+}
+
+// Reforward makes the requests received from clients to be forwarded again, to new leaders.
+func (p *Mempool) Reforward() {
+	for _, tx := range p.Txs {
+		tx.Sent = false
+	}
+}
+
+// Expire removes the pending requests older than the TTL, and forgets old commits.
+// It returns the number of requests removed.
+func (p *Mempool) Expire(now time.Time) int {
+	num := 0
+	if p.limits.TTL > 0 {
+		oldest := now.Add(-p.limits.TTL).UnixMilli()
+		for hash, tx := range p.Txs {
+			if !tx.Proposed && tx.Added < oldest {
+				p.remove(hash)
+				num++
+			}
+		}
+		p.compact()
+	}
+	memory := committedMemory
+	if p.limits.TTL > 0 {
+		memory = p.limits.TTL
+	}
+	forget := now.Add(-memory).UnixMilli()
+	for hash, t := range p.Committed {
+		if t < forget {
+			delete(p.Committed, hash)
+		}
+	}
+	return num
+}
+
+// Number of requests in the pool.
+func (p *Mempool) Len() int {
+	return len(p.Txs)
+}
+
+func (p *Mempool) Bytes() int {
+	return p.bytes
+}
+
+// HasPending tells whether a request waits to be proposed.
+func (p *Mempool) HasPending() bool {
+	return p.pending > 0
+}
@@ -0,0 +1,82 @@
+package mempool
+
+import (
+	"errors"
+	"sleepy-hotstuff/src/message"
+	"testing"
+	"time"
+)
+
+func request(client int64, op string) []byte {
+	return requestWithFee(client, op, 0)
+}
+
+func requestWithFee(client int64, op string, fee int64) []byte {
+	cr := message.ClientRequest{ID: client, OP: []byte(op), Fee: fee}
+	crser, _ := cr.Serialize()
+	r, _ := message.SerializeWithSignature(client, crser)
+	return r
+}
+
+func TestMempool(test *testing.T) {
+	var p Mempool
+	p.Init(Limits{MaxTxs: 4, MaxBytes: 1 << 20, ClientQuota: 2, TTL: time.Minute})
+	now := time.Now()
+
+	a, err := p.Add(request(1, "a"), now)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := p.Add(request(1, "a"), now); err != ErrDuplicate {
+		test.Fatalf("duplicate: %v", err)
+	}
+	p.Add(request(1, "b"), now)
+	if _, err := p.Add(request(1, "c"), now); !errors.Is(err, ErrQuota) {
+		test.Fatalf("quota: %v", err)
+	}
+	p.Add(request(2, "d"), now.Add(time.Second))
+	p.Add(request(3, "e"), now.Add(2*time.Minute))
+	if _, err := p.Add(request(4, "f"), now); !errors.Is(err, ErrFull) {
+		test.Fatalf("full: %v", err)
+	}
+
+	// the oldest requests are proposed first, and only once
+	batch := p.Reap(FIFO{}, BlockLimits{MaxTxs: 2})
+	if len(batch) != 2 || string(batch[0]) != string(request(1, "a")) || string(batch[1]) != string(request(1, "b")) {
+		test.Fatalf("batch %q", batch)
+	}
+	d, _ := HashOf(request(2, "d"))
+	p.MarkProposed([]string{d})
+	if batch := p.Reap(FIFO{}, BlockLimits{MaxTxs: 2}); len(batch) != 1 || string(batch[0]) != string(request(3, "e")) || p.HasPending() {
+		test.Fatalf("second batch %q", batch)
+	}
+
+	// a is committed and cannot come back; the other requests are proposed again after a view change
+	p.Commit([]string{a}, now)
+	if _, err := p.Add(request(1, "a"), now); err != ErrCommitted {
+		test.Fatalf("committed: %v", err)
+	}
+	// d stays proposed while its block may still be committed
+	if num := p.Requeue([]string{d}); num != 2 || p.Len() != 3 {
+		test.Fatalf("requeued %d of %d", num, p.Len())
+	}
+	if num := p.Requeue(nil); num != 1 {
+		test.Fatalf("requeued %d", num)
+	}
+
+	// the pool survives a restart
+	ser, _ := p.Serialize()
+	var restored Mempool
+	restored.Init(Limits{MaxTxs: 4, MaxBytes: 1 << 20, TTL: time.Minute})
+	if err := restored.Deserialize(ser); err != nil || restored.Len() != 3 || restored.Bytes() != p.Bytes() {
+		test.Fatalf("restored %d requests: %v", restored.Len(), err)
+	}
+
+	// b and d expire, e was received later
+	if num := restored.Expire(now.Add(90 * time.Second)); num != 2 || restored.Len() != 1 {
+		test.Fatalf("expired %d, left %d", num, restored.Len())
+	}
+	if batch := restored.Reap(FIFO{}, BlockLimits{MaxTxs: 4}); len(batch) != 1 || string(batch[0]) != string(request(3, "e")) {
+		test.Fatalf("batch after expiry %q", batch)
+	}
+}
@@ -0,0 +1,136 @@
+package mempool
+
+import (
+	"fmt"
+	"sleepy-hotstuff/src/message"
+	"sort"
+)
+
+// BlockLimits bound the requests of a block. A limit of 0 is no limit.
+type BlockLimits struct {
+	MaxTxs    int // number of requests
+	MaxBytes  int // bytes of the requests, as carried by the proposal
+	MaxTxSize int // bytes of the transaction of a request
+}
+
+// A Policy chooses the requests of the next block among the pending ones, given in arrival order.
+type Policy interface {
+	Select(pending []*Tx, limits BlockLimits) []*Tx
+}
+
+var policies = map[string]Policy{}
+
+// RegisterPolicy makes a block-building policy available under name.
+func RegisterPolicy(name string, policy Policy) {
+	policies[name] = policy
+}
+
+func PolicyNames() []string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPolicy gives the policy registered under name, FIFO if name is empty.
+func NewPolicy(name string) (Policy, error) {
+	if name == "" {
+		name = "fifo"
+	}
+	policy, exist := policies[name]
+	if !exist {
+		return nil, fmt.Errorf("[Mempool Error] no block policy %q, registered ones are %v", name, PolicyNames())
+	}
+	return policy, nil
+}
+
+func init() {
+	RegisterPolicy("fifo", FIFO{})
+	RegisterPolicy("priority", Priority{})
+	RegisterPolicy("fair", Fair{})
+}
+
+// Take the requests in order while they fit in the block. A request over the bytes left is
+// skipped, so that a smaller one after it can still fill the block.
+func fill(ordered []*Tx, limits BlockLimits) []*Tx {
+	var block []*Tx
+	bytes := 0
+	for _, tx := range ordered {
+		if limits.MaxTxs > 0 && len(block) >= limits.MaxTxs {
+			break
+		}
+		if limits.MaxBytes > 0 && bytes+len(tx.Request) > limits.MaxBytes {
+			continue
+		}
+		block = append(block, tx)
+		bytes += len(tx.Request)
+	}
+	return block
+}
+
+// FIFO proposes the oldest requests first.
+type FIFO struct{}
+
+func (FIFO) Select(pending []*Tx, limits BlockLimits) []*Tx {
+	return fill(pending, limits)
+}
+
+// Priority proposes the requests with the highest fee first, the oldest first among equal fees.
+type Priority struct{}
+
+func (Priority) Select(pending []*Tx, limits BlockLimits) []*Tx {
+	ordered := append([]*Tx(nil), pending...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Fee > ordered[j].Fee
+	})
+	return fill(ordered, limits)
+}
+
+// Fair takes one request of each client in turn, the oldest of each, so that a client sending
+// many requests does not fill the blocks. Clients take their turn by their oldest request.
+type Fair struct{}
+
+func (Fair) Select(pending []*Tx, limits BlockLimits) []*Tx {
+	var clients []int64
+	perClient := make(map[int64][]*Tx)
+	for _, tx := range pending {
+		if _, exist := perClient[tx.Client]; !exist {
+			clients = append(clients, tx.Client)
+		}
+		perClient[tx.Client] = append(perClient[tx.Client], tx)
+	}
+	var ordered []*Tx
+	for round := 0; len(ordered) < len(pending); round++ {
+		for _, client := range clients {
+			if round < len(perClient[client]) {
+				ordered = append(ordered, perClient[client][round])
+			}
+		}
+	}
+	return fill(ordered, limits)
+}
+
+// CheckBlock checks that the requests of a proposal respect the limits.
+func CheckBlock(requests [][]byte, limits BlockLimits) error {
+	if limits.MaxTxs > 0 && len(requests) > limits.MaxTxs {
+		return fmt.Errorf("[Mempool Error] %d requests in the block, the limit is %d", len(requests), limits.MaxTxs)
+	}
+	bytes := 0
+	for i, request := range requests {
+		bytes += len(request)
+		if limits.MaxTxSize == 0 {
+			continue
+		}
+		signed := message.DeserializeMessageWithSignature(request)
+		op := message.DeserializeClientRequest(signed.Msg).OP
+		if len(op) > limits.MaxTxSize {
+			return fmt.Errorf("[Mempool Error] transaction %d of the block has %d bytes, the limit is %d", i, len(op), limits.MaxTxSize)
+		}
+	}
+	if limits.MaxBytes > 0 && bytes > limits.MaxBytes {
+		return fmt.Errorf("[Mempool Error] the block has %d bytes, the limit is %d", bytes, limits.MaxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package mempool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicies(test *testing.T) {
+	fill := func() *Mempool {
+		var p Mempool
+		p.Init(Limits{MaxTxs: 10, MaxBytes: 1 << 20, MaxTxSize: 8})
+		now := time.Now()
+		p.Add(requestWithFee(1, "a", 1), now)
+		p.Add(requestWithFee(1, "b", 5), now)
+		p.Add(requestWithFee(1, "c", 3), now)
+		p.Add(requestWithFee(2, "d", 0), now)
+		p.Add(requestWithFee(3, "e", 5), now)
+		if _, err := p.Add(request(4, "too large"), now); !errors.Is(err, ErrTooLarge) {
+			test.Fatalf("large transaction: %v", err)
+		}
+		return &p
+	}
+	expect := func(name string, batch [][]byte, want ...[]byte) {
+		if len(batch) != len(want) {
+			test.Fatalf("%s: %d requests, want %d", name, len(batch), len(want))
+		}
+		for i := range want {
+			if string(batch[i]) != string(want[i]) {
+				test.Fatalf("%s: request %d differs", name, i)
+			}
+		}
+	}
+
+	limits := BlockLimits{MaxTxs: 4}
+	expect("fifo", fill().Reap(FIFO{}, limits),
+		requestWithFee(1, "a", 1), requestWithFee(1, "b", 5), requestWithFee(1, "c", 3), requestWithFee(2, "d", 0))
+	expect("priority", fill().Reap(Priority{}, limits),
+		requestWithFee(1, "b", 5), requestWithFee(3, "e", 5), requestWithFee(1, "c", 3), requestWithFee(1, "a", 1))
+	expect("fair", fill().Reap(Fair{}, limits),
+		requestWithFee(1, "a", 1), requestWithFee(2, "d", 0), requestWithFee(3, "e", 5), requestWithFee(1, "b", 5))
+
+	// a request over the bytes left is skipped for a smaller one
+	size := len(requestWithFee(1, "a", 1))
+	p := fill()
+	p.Add(requestWithFee(5, "ffffffff", 0), time.Now())
+	bytes := BlockLimits{MaxBytes: 2*size + 1}
+	batch := p.Reap(Priority{}, bytes)
+	if len(batch) != 2 || CheckBlock(batch, bytes) != nil {
+		test.Fatalf("%d requests over %d bytes", len(batch), bytes.MaxBytes)
+	}
+
+	if err := CheckBlock([][]byte{request(1, "a"), request(2, "b")}, BlockLimits{MaxTxs: 1}); err == nil {
+		test.Fatal("block over the number of requests accepted")
+	}
+	if err := CheckBlock([][]byte{request(1, "too large")}, BlockLimits{MaxTxSize: 8}); err == nil {
+		test.Fatal("block with a large transaction accepted")
+	}
+	if _, err := NewPolicy("lottery"); err == nil {
+		test.Fatal("unknown policy")
+	}
+}
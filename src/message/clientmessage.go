@@ -5,11 +5,20 @@ import (
 	pb "sleepy-hotstuff/src/proto/communication"
 )
 
+// Consistency levels of a request, carried in the version field of pb.Request.
+const (
+	LevelAsync     = ""          // the replica replies once the request is queued
+	LevelConsensus = "consensus" // the replica replies with the result once the request is executed
+	LevelLease     = "lease"     // the leader replies from its state while it holds a lease
+	LevelQuorum    = "quorum"    // every replica replies from its state; clients wait for f+1 matching replies
+)
+
 type ClientRequest struct {
 	Type pb.MessageType
 	ID   int64
 	OP   []byte // Message payload. Opt for contract.
 	TS   int64  // Timestamp
+	Fee  int64  // Priority of the request for the "priority" block policy, higher first
 }
 
 func (r *ClientRequest) Serialize() ([]byte, error) {
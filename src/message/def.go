@@ -15,6 +15,10 @@ const (
 	HACSS_RECONSTRUCT
 	HotStuff_Msg
 	Rondo_Msg
+	Evidence_Msg
+	RBC_Msg
+	CBC_Msg
+	ECRBC_Msg
 )
 
 type ProtocolType int
@@ -40,6 +40,9 @@ type HotStuffMessage struct {
 	Epoch     int
 	Count     int
 	V         []MessageWithSignature
+	AppHeight int         // height of the last block executed by the application of the leader
+	AppHash   []byte      // state hash of the application after AppHeight
+	Batches   []BatchCert // availability certificates of the batches of a block, in data-availability mode
 }
 
 // MembershipInfo Used for dynamic membership only
@@ -132,6 +135,13 @@ func (r *MembershipInfo) GetIdentifier() []byte {
 Get hash of the entire batch
 */
 func (r *HotStuffMessage) GetMsgHash() []byte {
+	if len(r.OPS) == 0 && len(r.Batches) > 0 {
+		var digests []byte
+		for i := range r.Batches {
+			digests = append(digests, r.Batches[i].Digest...)
+		}
+		return cryptolib.GenHash(digests)
+	}
 	if len(r.OPS) == 0 {
 		return []byte("")
 	}
@@ -181,12 +191,23 @@ type QCBlock struct {
 	AuxQC      []byte
 	IDs        []int64
 	TXS        []MessageWithSignature
+	Batches    []BatchCert // the requests of the block after the coinbase in TXS, in data-availability mode
+}
+
+// BatchCert certifies that f+1 replicas store the batch of requests Digest of Author: Acks are
+// their signed BROADCAST messages for Digest, so at least one correct replica can give the batch.
+type BatchCert struct {
+	Author int64
+	Seq    int
+	Digest []byte
+	Acks   []MessageWithSignature
 }
 
 type Transaction struct {
 	From  string `json:from`
 	To    string `json:to`
 	Value int    `json:value`
+	Nonce int    `json:"Nonce,omitempty"` // number of earlier transactions from the account, for the ledger
 	// Timestamp int64  `json:timestamp`
 }
 
@@ -240,7 +261,7 @@ func CreateMessageWithSig(tmpmsg HotStuffMessage) MessageWithSignature {
 
 	op := MessageWithSignature{
 		Msg: tmpmsgSer,
-		Sig: cryptolib.GenSig(tmpmsg.Source, tmpmsgSer),
+		Sig: cryptolib.GenSig(tmpmsgSer),
 	}
 	return op
 }
@@ -395,21 +416,7 @@ func DeserializeReplicaMessage(input []byte) ReplicaMessage {
 func SerializeWithSignature(id int64, msg []byte) ([]byte, error) {
 	request := MessageWithSignature{
 		Msg: msg,
-		Sig: cryptolib.GenSig(id, msg),
-	}
-
-	requestSer, err := request.Serialize()
-	if err != nil {
-		return []byte(""), err
-	}
-	return requestSer, err
-}
-
-func SerializeWithMAC(id int64, dest int64, msg []byte) ([]byte, error) {
-	CBCEncryptor := cryptolib.CBCEncrypterAES(msg)
-	request := MessageWithSignature{
-		Msg: CBCEncryptor,
-		Sig: cryptolib.GenMAC(id, CBCEncryptor),
+		Sig: cryptolib.GenSig(msg),
 	}
 
 	requestSer, err := request.Serialize()
@@ -0,0 +1,119 @@
+/*
+Safety rules of a replica.
+A replica records the highest view/height it has voted for and its locked QC
+before any vote leaves the node, and refuses votes that conflict with them.
+The rules do not write to the database: the caller stores them under DBKey with the
+critical parameters, before the vote is signed, so that they survive a restart.
+*/
+
+package safety
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sleepy-hotstuff/src/db"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// DBKey is the key of the safety rules in the database.
+const DBKey = "safetyRules"
+
+var ErrConflictingVote = errors.New("[Safety Error] a different block has been voted at the same view and height")
+var ErrStaleVote = errors.New("[Safety Error] a higher view or height has been voted")
+var ErrLocked = errors.New("[Safety Error] the block does not extend the locked QC")
+
+type Rules struct {
+	VotedView    int
+	VotedHeight  int
+	VotedHash    []byte
+	LockedView   int
+	LockedHeight int
+	LockedHash   []byte
+	sync.Mutex   `msgpack:"-"`
+}
+
+func (r *Rules) Serialize() ([]byte, error) {
+	return msgpack.Marshal(r)
+}
+
+func (r *Rules) Deserialize(input []byte) error {
+	return msgpack.Unmarshal(input, r)
+}
+
+// Init resets the rules of a replica that has never voted.
+func (r *Rules) Init() {
+	r.Lock()
+	defer r.Unlock()
+	r.VotedView = -1
+	r.VotedHeight = 0
+	r.VotedHash = nil
+	r.LockedView = -1
+	r.LockedHeight = 0
+	r.LockedHash = nil
+}
+
+// Load reads the rules from the database. The rules are left unchanged if nothing was stored.
+func (r *Rules) Load() error {
+	r.Lock()
+	defer r.Unlock()
+	err := db.ReadDB(DBKey, r)
+	if err == db.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Vote checks whether a vote for the block (view, height, hash) is safe, and records it if it is.
+// The caller must store the rules before it signs and sends the vote.
+// Voting again for the same block is allowed.
+func (r *Rules) Vote(view int, height int, hash []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	if view == r.VotedView && height == r.VotedHeight {
+		if bytes.Equal(hash, r.VotedHash) {
+			return nil
+		}
+		return ErrConflictingVote
+	}
+	if view < r.VotedView || (view == r.VotedView && height < r.VotedHeight) {
+		return ErrStaleVote
+	}
+
+	r.VotedView = view
+	r.VotedHeight = height
+	r.VotedHash = hash
+	return nil
+}
+
+// SafeToExtend checks that a proposal justified by a QC at justifyHeight extends the locked QC.
+func (r *Rules) SafeToExtend(justifyHeight int) error {
+	r.Lock()
+	defer r.Unlock()
+	if justifyHeight < r.LockedHeight {
+		return ErrLocked
+	}
+	return nil
+}
+
+// UpdateLock records a new locked QC, and reports whether the lock moved. The lock only moves forward.
+func (r *Rules) UpdateLock(view int, height int, hash []byte) bool {
+	r.Lock()
+	defer r.Unlock()
+	if height <= r.LockedHeight {
+		return false
+	}
+	r.LockedView = view
+	r.LockedHeight = height
+	r.LockedHash = hash
+	return true
+}
+
+func (r *Rules) String() string {
+	r.Lock()
+	defer r.Unlock()
+	return fmt.Sprintf("voted (view %d, height %d), locked (view %d, height %d)",
+		r.VotedView, r.VotedHeight, r.LockedView, r.LockedHeight)
+}
@@ -0,0 +1,102 @@
+package safety
+
+import (
+	"sleepy-hotstuff/src/db"
+	"testing"
+)
+
+func TestVote(test *testing.T) {
+	if err := db.OpenDB(test.TempDir()); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	var r Rules
+	r.Init()
+
+	if err := r.Vote(1, 5, []byte("a")); err != nil {
+		test.Fatalf("first vote refused: %v", err)
+	}
+	if err := r.Vote(1, 5, []byte("a")); err != nil {
+		test.Fatalf("voting again for the same block refused: %v", err)
+	}
+	if err := r.Vote(1, 5, []byte("b")); err != ErrConflictingVote {
+		test.Fatalf("conflicting vote at the same view and height: %v", err)
+	}
+	if err := r.Vote(1, 4, []byte("c")); err != ErrStaleVote {
+		test.Fatalf("vote for a lower height in the same view: %v", err)
+	}
+	if err := r.Vote(0, 9, []byte("c")); err != ErrStaleVote {
+		test.Fatalf("vote in a lower view: %v", err)
+	}
+	if err := r.Vote(2, 5, []byte("b")); err != nil {
+		test.Fatalf("vote in a higher view refused: %v", err)
+	}
+
+	r.UpdateLock(1, 4, []byte("d"))
+	if err := r.SafeToExtend(3); err != ErrLocked {
+		test.Fatalf("proposal below the locked QC: %v", err)
+	}
+	if err := r.SafeToExtend(4); err != nil {
+		test.Fatalf("proposal extending the locked QC refused: %v", err)
+	}
+}
+
+// A replica restarts in the middle of a view, after voting at height 10 and locking at height 8.
+func TestRestartMidView(test *testing.T) {
+	dir := test.TempDir()
+	if err := db.OpenDB(dir); err != nil {
+		test.Fatal(err)
+	}
+	var r Rules
+	r.Init()
+	if err := r.Vote(3, 10, []byte("a")); err != nil {
+		test.Fatal(err)
+	}
+	r.UpdateLock(3, 8, []byte("l"))
+	// as the replica does before it signs the vote
+	if err := db.WriteDB(DBKey, &r); err != nil {
+		test.Fatal(err)
+	}
+	db.CloseDB()
+
+	if err := db.OpenDB(dir); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	var restarted Rules
+	restarted.Init()
+	if err := restarted.Load(); err != nil {
+		test.Fatal(err)
+	}
+	if restarted.VotedView != 3 || restarted.VotedHeight != 10 || restarted.LockedHeight != 8 {
+		test.Fatalf("rules not recovered: %v", restarted.String())
+	}
+
+	if err := restarted.Vote(3, 10, []byte("b")); err != ErrConflictingVote {
+		test.Fatalf("double vote after restart: %v", err)
+	}
+	if err := restarted.Vote(3, 9, []byte("b")); err != ErrStaleVote {
+		test.Fatalf("vote for a lower height after restart: %v", err)
+	}
+	if err := restarted.SafeToExtend(7); err != ErrLocked {
+		test.Fatalf("lock lost after restart: %v", err)
+	}
+	if err := restarted.Vote(3, 11, []byte("c")); err != nil {
+		test.Fatalf("vote for the next height refused: %v", err)
+	}
+}
+
+func TestLoadEmptyDB(test *testing.T) {
+	if err := db.OpenDB(test.TempDir()); err != nil {
+		test.Fatal(err)
+	}
+	defer db.CloseDB()
+	var r Rules
+	r.Init()
+	if err := r.Load(); err != nil {
+		test.Fatal(err)
+	}
+	if err := r.Vote(0, 1, []byte("a")); err != nil {
+		test.Fatal(err)
+	}
+}
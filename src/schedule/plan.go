@@ -0,0 +1,146 @@
+/*
+Sleep schedule of churn experiments.
+Every replica computes the same plan from conf.json: the single sleeps of the test parameters,
+the planned intervals and the random churn drawn from the seed, minus the intervals that could
+put more than maxAsleep replicas to sleep at once. A replica counts as asleep until it has
+recovered, recovery milliseconds after it wakes up. The time at which a view or a height is
+reached is not known in advance, so an interval triggered by a view or a height may overlap any
+interval of another replica.
+*/
+
+package schedule
+
+import (
+	"math/rand"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/utils"
+	"sort"
+)
+
+// An Interval is a sleep of a replica.
+type Interval struct {
+	Replica  int64
+	Trigger  config.TriggerType
+	At       int
+	Duration int // milliseconds
+	RecMode  config.RecModeType
+	Churn    bool // drawn by the random churn
+}
+
+func (iv Interval) end() int {
+	return iv.At + iv.Duration
+}
+
+func (iv Interval) timed() bool {
+	return iv.Trigger == config.TriggerTime
+}
+
+// Whether the replicas of iv and other may be asleep or recovering at the same time.
+func (iv Interval) overlaps(other Interval, recovery int) bool {
+	if !iv.timed() || !other.timed() {
+		return true
+	}
+	return iv.At < other.end()+recovery && other.At < iv.end()+recovery
+}
+
+// Plan returns the intervals of all replicas admitted by the bound on sleeping replicas, and
+// the dropped ones. The single sleeps, given by first, are admitted first, then the intervals
+// triggered by time, by start time, then the others in the order of conf.json. A replica goes
+// through its intervals in the order of the plan.
+func Plan(s config.Schedule, first []Interval, replicas []int64, maxAsleep int) ([]Interval, []Interval) {
+	var timed, others []Interval
+	for _, p := range s.Intervals {
+		nid, err := utils.StringToInt64(p.Id)
+		if err != nil {
+			continue
+		}
+		iv := Interval{Replica: nid, Trigger: p.Trigger, At: p.At, Duration: p.Duration, RecMode: p.RecMode}
+		if iv.Trigger == "" {
+			iv.Trigger = config.TriggerTime
+		}
+		if iv.timed() {
+			timed = append(timed, iv)
+		} else {
+			others = append(others, iv)
+		}
+	}
+	timed = append(timed, churn(s.Churn, replicas)...)
+
+	sort.SliceStable(timed, func(i, j int) bool {
+		if timed[i].At != timed[j].At {
+			return timed[i].At < timed[j].At
+		}
+		return timed[i].Replica < timed[j].Replica
+	})
+	var admitted, dropped []Interval
+	for _, iv := range append(append(append([]Interval{}, first...), timed...), others...) {
+		// the other replicas that may be asleep during iv
+		asleep := make(map[int64]bool)
+		busy := false
+		for _, a := range admitted {
+			if !a.overlaps(iv, s.Recovery) {
+				continue
+			}
+			if a.Replica != iv.Replica {
+				asleep[a.Replica] = true
+			} else if a.timed() && iv.timed() {
+				// a replica only goes through its other intervals after it has recovered
+				busy = true
+			}
+		}
+		if busy || (maxAsleep >= 0 && len(asleep) >= maxAsleep) {
+			dropped = append(dropped, iv)
+			continue
+		}
+		admitted = append(admitted, iv)
+	}
+	return admitted, dropped
+}
+
+// Random intervals of the replicas taking part in the churn.
+func churn(c config.Churn, replicas []int64) []Interval {
+	if c.Interval <= 0 || c.Until <= 0 {
+		return nil
+	}
+	var candidates []int64
+	if len(c.Replicas) == 0 {
+		candidates = append(candidates, replicas...)
+	} else {
+		for _, r := range c.Replicas {
+			if nid, err := utils.StringToInt64(r); err == nil {
+				candidates = append(candidates, nid)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	rng := rand.New(rand.NewSource(c.Seed))
+	var result []Interval
+	for _, nid := range candidates {
+		t := 0
+		for {
+			t += int(rng.ExpFloat64() * float64(c.Interval))
+			if t >= c.Until {
+				break
+			}
+			d := c.MinSleep
+			if c.MaxSleep > c.MinSleep {
+				d += rng.Intn(c.MaxSleep - c.MinSleep + 1)
+			}
+			result = append(result, Interval{Replica: nid, Trigger: config.TriggerTime, At: t, Duration: d, RecMode: c.RecMode, Churn: true})
+			t += d
+		}
+	}
+	return result
+}
+
+// Of returns the intervals of replica nid in the plan.
+func Of(plan []Interval, nid int64) []Interval {
+	var result []Interval
+	for _, iv := range plan {
+		if iv.Replica == nid {
+			result = append(result, iv)
+		}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"reflect"
+	"sleepy-hotstuff/src/config"
+	"testing"
+)
+
+var replicas = []int64{0, 1, 2, 3, 4, 5}
+
+func TestPlanBound(test *testing.T) {
+	s := config.Schedule{Intervals: []config.SleepInterval{
+		{Id: "1", At: 0, Duration: 1000},
+		{Id: "2", At: 500, Duration: 1000},  // overlaps with 1
+		{Id: "3", At: 1000, Duration: 1000}, // starts when 1 wakes up
+		{Id: "3", At: 1500, Duration: 100},  // 3 is already asleep
+		{Id: "4", Trigger: config.TriggerHeight, At: 10, Duration: 1000},
+	}}
+	plan, dropped := Plan(s, nil, replicas, 1)
+	if len(plan) != 2 || plan[0].Replica != 1 || plan[1].Replica != 3 {
+		test.Fatalf("plan: %+v", plan)
+	}
+	// 4 may reach the height while 1 or 3 sleeps
+	if len(dropped) != 3 || dropped[0].Replica != 2 || dropped[1].Replica != 3 || dropped[2].Replica != 4 {
+		test.Fatalf("dropped: %+v", dropped)
+	}
+
+	plan, dropped = Plan(s, nil, replicas, -1)
+	if len(plan) != 4 || len(dropped) != 1 {
+		test.Fatalf("without bound: %+v, dropped %+v", plan, dropped)
+	}
+}
+
+// Sleeps of the test parameters, intervals triggered by time, view and height, and the time
+// replicas take to recover.
+func TestPlanMixed(test *testing.T) {
+	first := []Interval{{Replica: 5, Trigger: config.TriggerHeight, At: 5, Duration: 3000}}
+	s := config.Schedule{Intervals: []config.SleepInterval{
+		{Id: "4", Trigger: config.TriggerHeight, At: 10, Duration: 1000},
+		{Id: "1", At: 0, Duration: 1000},
+		{Id: "2", At: 1200, Duration: 500}, // while 1 recovers
+		{Id: "3", Trigger: config.TriggerView, At: 4, Duration: 1000},
+		{Id: "5", At: 0, Duration: 100}, // after the first sleep of 5
+	}, Recovery: 500}
+
+	replicasOf := func(ivs []Interval) []int64 {
+		var result []int64
+		for _, iv := range ivs {
+			result = append(result, iv.Replica)
+		}
+		return result
+	}
+	check := func(maxAsleep int, admitted []int64, dropped []int64) {
+		plan, out := Plan(s, first, replicas, maxAsleep)
+		if !reflect.DeepEqual(replicasOf(plan), admitted) || !reflect.DeepEqual(replicasOf(out), dropped) {
+			test.Fatalf("bound %d, recovery %d: plan %+v, dropped %+v", maxAsleep, s.Recovery, plan, out)
+		}
+		// the sleep of the test parameters comes first for its replica
+		if Of(plan, 5)[0] != first[0] {
+			test.Fatalf("plan of replica 5: %+v", Of(plan, 5))
+		}
+	}
+	check(2, []int64{5, 1, 5}, []int64{2, 4, 3})
+	check(3, []int64{5, 1, 5, 2}, []int64{4, 3})
+	s.Recovery = 0
+	check(2, []int64{5, 1, 5, 2}, []int64{4, 3})
+	check(-1, []int64{5, 1, 5, 2, 4, 3}, nil)
+}
+
+func TestChurn(test *testing.T) {
+	s := config.Schedule{Churn: config.Churn{Seed: 7, Interval: 2000, MinSleep: 500, MaxSleep: 1500, Until: 60000}}
+	plan, dropped := Plan(s, nil, replicas, 2)
+	if len(plan) == 0 || len(dropped) == 0 {
+		test.Fatalf("plan %d intervals, dropped %d", len(plan), len(dropped))
+	}
+	again, _ := Plan(s, nil, replicas, 2)
+	if !reflect.DeepEqual(plan, again) {
+		test.Fatal("the same seed gives another plan")
+	}
+
+	for _, iv := range plan {
+		if iv.Duration < 500 || iv.Duration > 1500 || iv.At >= 60000 {
+			test.Fatalf("interval out of the churn parameters: %+v", iv)
+		}
+		asleep := 0
+		for _, other := range plan {
+			if other.At <= iv.At && iv.At < other.end() {
+				asleep++
+			}
+		}
+		if asleep > 2 {
+			test.Fatalf("%d replicas asleep at %d ms", asleep, iv.At)
+		}
+	}
+
+	s.Churn.Replicas = []string{"5"}
+	plan, _ = Plan(s, nil, replicas, -1)
+	if len(Of(plan, 5)) != len(plan) {
+		test.Fatal("churn of replicas not listed")
+	}
+}
@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"encoding/json"
+	"os"
+	"sleepy-hotstuff/src/config"
+	"sleepy-hotstuff/src/logging"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	Planned EventType = "planned" // an interval of this replica in the plan
+	Dropped EventType = "dropped" // an interval left out to respect maxAsleep
+	Sleep   EventType = "sleep"
+	Wake    EventType = "wake"  // the sleep is over and the recovery starts
+	Ready   EventType = "ready" // the recovery is over
+)
+
+// An Event is a line of the trace.
+type Event struct {
+	Time     int64              `json:"time"` // Unix time in milliseconds
+	Replica  int64              `json:"replica"`
+	Event    EventType          `json:"event"`
+	Interval int                `json:"interval"` // index of the interval among those of the replica
+	Trigger  config.TriggerType `json:"trigger,omitempty"`
+	At       int                `json:"at"`
+	Duration int                `json:"duration,omitempty"`
+	Churn    bool               `json:"churn,omitempty"`
+	View     int                `json:"view"`
+	Height   int                `json:"height"`
+}
+
+// A Trace writes events as JSON lines, to var/log/[id]/schedule.jsonl.
+type Trace struct {
+	f       *os.File
+	replica int64
+	sync.Mutex
+}
+
+func OpenTrace(replica int64) (*Trace, error) {
+	f, err := logging.CreateLogFile("schedule.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return &Trace{f: f, replica: replica}, nil
+}
+
+// Log writes an event about interval i, at the given view and height.
+func (t *Trace) Log(event EventType, i int, iv Interval, view int, height int) {
+	if t == nil {
+		return
+	}
+	e := Event{
+		Time:     time.Now().UnixNano() / int64(time.Millisecond),
+		Replica:  t.replica,
+		Event:    event,
+		Interval: i,
+		Trigger:  iv.Trigger,
+		At:       iv.At,
+		Duration: iv.Duration,
+		Churn:    iv.Churn,
+		View:     view,
+		Height:   height,
+	}
+	line, _ := json.Marshal(e)
+	t.Lock()
+	defer t.Unlock()
+	t.f.Write(append(line, '\n'))
+}
+
+func (t *Trace) Close() {
+	if t != nil {
+		t.f.Close()
+	}
+}